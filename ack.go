@@ -0,0 +1,71 @@
+package colony
+
+import (
+	"errors"
+	"time"
+)
+
+// An AckMode says when a consumed Message counts as handled, so that the
+// transport doesn't deliver it again.
+type AckMode int
+
+const (
+	// AckOnDelivery acknowledges a Message as soon as the handler receives it.
+	AckOnDelivery AckMode = iota
+	// AckManual waits for the handler to call the Message's Ack or Nack.
+	AckManual
+)
+
+// errNacked is how a Nacked Message is handed back to the transport.
+var errNacked = errors.New("message not acknowledged")
+
+// ConsumeOptions change how one call of ConsumeWith consumes. MaxInFlight and
+// Concurrency take precedence over the service's RuntimeConfig when set. The
+// RuntimeConfig's Concurrency only applies to consumers that set Concurrent,
+// as not every handler is safe to run as several copies.
+type ConsumeOptions struct {
+	MaxInFlight int           // Messages the transport may have outstanding
+	Concurrency int           // copies of the handler run at once
+	Concurrent  bool          // the RuntimeConfig may run copies of the handler at once
+	Ack         AckMode       // when a Message counts as handled
+	Backoff     time.Duration // how long a Nacked Message waits to be delivered again; 0 leaves it to the transport
+	Buffer      int           // Messages waiting for the handler, if not the service's Buffers.Consumer
+
+	// ReusePayloads decodes each payload into space that is reused once the
+	// Message is acked or nacked, to spare the garbage collector. The
+	// Payload must not be used after that. It needs AckManual.
+	ReusePayloads bool
+}
+
+// ConsumeWith is Consume with opts, for content types that need handling
+// differently from the rest, such as many workers for thumbnails or a single
+// ordered worker for billing.
+func (s Service) ConsumeWith(contentType string, opts ConsumeOptions, h Handler) error {
+	return s.consume(contentType, consumeOptions{ConsumeOptions: opts}, h)
+}
+
+// Ack acknowledges a Message consumed with AckManual, so that it isn't
+// delivered again. It does nothing to other Messages.
+func (m Message) Ack() {
+	if m.job != nil {
+		m.job.Done()
+	}
+}
+
+// Nack hands a Message consumed with AckManual back to be delivered again,
+// after the consumer's Backoff, or through the next of its retry tiers if the
+// service has any for its content type. It does nothing to other Messages.
+func (m Message) Nack() {
+	if m.job != nil {
+		m.job.finish(errNacked)
+	}
+}
+
+// backoff has the transport wait for the consumer's Backoff before
+// delivering again a Message handed back with err.
+func (c queueConsumer) backoff(err error) error {
+	if err == nil || c.opts.Backoff <= 0 {
+		return err
+	}
+	return &RequeueError{Err: err, Delay: c.opts.Backoff}
+}
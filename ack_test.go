@@ -0,0 +1,71 @@
+package colony
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNackGoesToRetryTier(t *testing.T) {
+	transport := NewMemoryTransport()
+	s := NewService("anteater", "1", "", WithTransport(transport), WithRetryTiers("ants", 10*time.Millisecond))
+	defer s.Close()
+	if err := s.Announce("ants"); err != nil {
+		t.Fatal(err)
+	}
+
+	retried := make(chan struct{}, 1)
+	_, err := transport.Subscribe(s.retryTopic("ants", 1), "watch", func([]byte) error {
+		select {
+		case retried <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(chan Message, 2)
+	go s.ConsumeWith("ants", ConsumeOptions{Ack: AckManual}, func(msgs <-chan Message) error {
+		for m := range msgs {
+			got <- m
+			if m.Attempt == 0 {
+				m.Nack()
+			} else {
+				m.Ack()
+			}
+		}
+		return nil
+	})
+
+	deadline := time.After(5 * time.Second)
+	for sent := false; !sent; {
+		if err := s.Emit(s.NewMessage("ants", []byte("hello"))); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case m := <-got:
+			if m.Attempt != 0 {
+				t.Fatalf("first delivery has Attempt %d", m.Attempt)
+			}
+			sent = true
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("nothing consumed")
+		}
+	}
+
+	select {
+	case <-retried:
+	case <-deadline:
+		t.Fatal("the Nacked Message was not sent to .retry1")
+	}
+	select {
+	case m := <-got:
+		if m.Attempt != 1 {
+			t.Fatalf("delivered again with Attempt %d, want 1", m.Attempt)
+		}
+	case <-deadline:
+		t.Fatal("the Nacked Message was not delivered again")
+	}
+}
@@ -0,0 +1,84 @@
+package colony
+
+import (
+	"errors"
+	"log"
+)
+
+// ErrForbidden is returned when a service's Policy does not allow it to emit
+// or consume a content type.
+var ErrForbidden = errors.New("forbidden by policy")
+
+// An Action is something a service can do with a content type.
+type Action int
+
+// The actions a Policy decides on.
+const (
+	ActionEmit Action = iota
+	ActionConsume
+)
+
+func (a Action) String() string {
+	if a == ActionConsume {
+		return "consume"
+	}
+	return "emit"
+}
+
+// A Policy decides which services may emit and consume each content type.
+type Policy interface {
+	Allow(action Action, service, contentType string) bool
+}
+
+// PolicyFunc adapts an ordinary function to a Policy.
+type PolicyFunc func(action Action, service, contentType string) bool
+
+// Allow calls f.
+func (f PolicyFunc) Allow(action Action, service, contentType string) bool {
+	return f(action, service, contentType)
+}
+
+// ACL is a static Policy listing, per content type, the services allowed to
+// emit and consume it. Content types that are not listed are open to
+// everybody, and the service name "*" matches every service.
+type ACL struct {
+	Emit    map[string][]string // contentType -> services allowed to emit it
+	Consume map[string][]string // contentType -> services allowed to consume it
+}
+
+// Allow reports whether service may perform action on contentType.
+func (a ACL) Allow(action Action, service, contentType string) bool {
+	rules := a.Emit
+	if action == ActionConsume {
+		rules = a.Consume
+	}
+	allowed, ok := rules[contentType]
+	if !ok {
+		return true
+	}
+	for _, name := range allowed {
+		if name == service || name == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// WithPolicy restricts the content types the service may emit and consume.
+// Inbound Messages whose sender is not allowed to emit them are sent to the
+// service's RejectHandler.
+func WithPolicy(p Policy) Option {
+	return func(s *Service) {
+		s.policy = p
+	}
+}
+
+// allowed reports whether the service's Policy allows service to perform
+// action on contentType, logging violations.
+func (s Service) allowed(action Action, service, contentType string) bool {
+	if s.policy == nil || s.policy.Allow(action, service, contentType) {
+		return true
+	}
+	log.Println("COLONY\t policy forbids", service, "to", action, contentType)
+	return false
+}
@@ -0,0 +1,327 @@
+package colony
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// recentAnnouncements is how many announcements an Admin remembers.
+const recentAnnouncements = 100
+
+// Admin is an http.Handler serving a web dashboard for the colony its Service
+// belongs to: which services feed which, message rates and queue depths for
+// every topic, recent announcements, what each topic carries, and a live tail
+// of any content type. Rates and depths need a StatsTransport. Admin can be
+// mounted in an existing service's HTTP server or given a service of its own,
+// as the colonyadmin command does.
+type Admin struct {
+	Service *Service
+
+	mux           *http.ServeMux
+	accept        func(Message) bool
+	mu            sync.Mutex
+	announcements []adminAnnouncement
+	counts        map[string]int64
+	countedAt     time.Time
+}
+
+type adminAnnouncement struct {
+	Time        time.Time
+	Service     string
+	Topic       string
+	ContentType string
+}
+
+type adminTopic struct {
+	Name     string
+	Producer string
+	Depth    int64
+	Rate     float64 // messages per second since the previous poll
+	Channels []ChannelStats
+}
+
+type adminStats struct {
+	Topics  []adminTopic
+	Edges   []TopologyEdge
+	Expired int64 // expired Messages the service has dropped
+}
+
+// NewAdmin returns an Admin for s and starts following announcements.
+func NewAdmin(s *Service) *Admin {
+	a := &Admin{
+		Service: s,
+		mux:     http.NewServeMux(),
+		counts:  make(map[string]int64),
+		accept:  s.accept,
+	}
+	a.mux.HandleFunc("/", a.servePage)
+	a.mux.HandleFunc("/stats", a.serveStats)
+	a.mux.HandleFunc("/announcements", a.serveAnnouncements)
+	a.mux.HandleFunc("/tail", a.serveTail)
+	a.mux.HandleFunc("/topology", a.serveTopology)
+	a.mux.HandleFunc("/topics", a.serveTopics)
+
+	channel := s.Name + "-" + s.ID + "-admin#ephemeral"
+	_, err := s.subscribe("colony-announce", channel, a.announced)
+	if err != nil {
+		log.Println("COLONY\t admin could not follow announcements:", err)
+	}
+	return a
+}
+
+func (a *Admin) announced(body []byte) error {
+	var m Message
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil
+	}
+	if !a.accept(m) {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.announcements = append(a.announcements, adminAnnouncement{
+		Time:        m.Time,
+		Service:     m.Topic.ServiceName + "-" + m.Topic.ServiceID,
+		Topic:       m.Topic.getName(),
+		ContentType: m.ContentType,
+	})
+	if len(a.announcements) > recentAnnouncements {
+		a.announcements = a.announcements[len(a.announcements)-recentAnnouncements:]
+	}
+	return nil
+}
+
+// ServeHTTP serves the dashboard page at the root of the handler, and the JSON
+// and event streams it is built from at /stats, /announcements and /tail. The
+// colony's Topology is served at /topology.
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+func (a *Admin) servePage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(adminPage))
+}
+
+func (a *Admin) serveStats(w http.ResponseWriter, r *http.Request) {
+	st, ok := a.Service.transport.(StatsTransport)
+	if !ok {
+		http.Error(w, "transport does not report stats", http.StatusNotImplemented)
+		return
+	}
+	topics, err := st.TopicStats()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	a.mu.Lock()
+	now := time.Now()
+	elapsed := now.Sub(a.countedAt).Seconds()
+	var out adminStats
+	for _, t := range topics {
+		service, id, contentType, ok := SplitTopic(t.Name)
+		if !ok || contentType == "responses" {
+			continue
+		}
+		at := adminTopic{
+			Name:     t.Name,
+			Producer: service + "-" + id,
+			Depth:    t.Depth,
+			Channels: t.Channels,
+		}
+		if last, ok := a.counts[t.Name]; ok && elapsed > 0 && t.MessageCount >= last {
+			at.Rate = float64(t.MessageCount-last) / elapsed
+		}
+		a.counts[t.Name] = t.MessageCount
+		out.Topics = append(out.Topics, at)
+	}
+	out.Edges = buildTopology(nil, topics).Edges
+	out.Expired = a.Service.ExpiredCount()
+	a.countedAt = now
+	a.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+func (a *Admin) serveAnnouncements(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	out := make([]adminAnnouncement, len(a.announcements))
+	copy(out, a.announcements)
+	a.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// serveTopology serves the colony's Topology as JSON, or as DOT when the
+// format query parameter is dot.
+func (a *Admin) serveTopology(w http.ResponseWriter, r *http.Request) {
+	t, err := a.Service.Topology()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if r.URL.Query().Get("format") == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		t.WriteDOT(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// serveTail taps the contentType given in the query and streams its Messages
+// as Server-Sent Events until the client disconnects.
+func (a *Admin) serveTail(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	contentType := r.URL.Query().Get("contentType")
+	if contentType == "" {
+		http.Error(w, "missing contentType", http.StatusBadRequest)
+		return
+	}
+	msgs, stop := a.Service.Tap(contentType)
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+	for {
+		select {
+		case m := <-msgs:
+			writeSSE(w, flusher, m)
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+const adminPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>colony</title>
+<style>
+body { font: 14px sans-serif; margin: 2em; color: #222; }
+h2 { margin-top: 1.5em; }
+table { border-collapse: collapse; }
+td, th { padding: 2px 12px 2px 0; text-align: left; }
+td.n { text-align: right; }
+#graph text { font: 12px sans-serif; }
+#tail { font: 12px monospace; white-space: pre; max-height: 20em; overflow: auto; background: #f4f4f4; padding: 0.5em; }
+</style>
+</head>
+<body>
+<h1>colony</h1>
+
+<h2>Services</h2>
+<svg id="graph" width="720" height="480"></svg>
+
+<h2>Topics</h2>
+<table>
+<thead><tr><th>topic</th><th>channel</th><th>depth</th><th>in flight</th><th>msg/s</th></tr></thead>
+<tbody id="topics"></tbody>
+</table>
+
+<h2>Announcements</h2>
+<table>
+<thead><tr><th>time</th><th>service</th><th>content type</th></tr></thead>
+<tbody id="announcements"></tbody>
+</table>
+
+<h2>Tail</h2>
+<form id="tailForm"><input id="contentType" placeholder="content type"> <button>tail</button></form>
+<div id="tail"></div>
+
+<script>
+function el(tag, attrs, text) {
+	var e = document.createElementNS(tag == "svg" || attrs.svg ? "http://www.w3.org/2000/svg" : "http://www.w3.org/1999/xhtml", tag);
+	for (var k in attrs) if (k != "svg") e.setAttribute(k, attrs[k]);
+	if (text !== undefined) e.textContent = text;
+	return e;
+}
+
+function row(cells) {
+	var tr = el("tr", {});
+	cells.forEach(function(c) {
+		tr.appendChild(el("td", typeof c == "number" ? {"class": "n"} : {}, c));
+	});
+	return tr;
+}
+
+function drawGraph(stats) {
+	var svg = document.getElementById("graph");
+	svg.innerHTML = "";
+	var names = [];
+	(stats.Topics || []).forEach(function(t) { if (names.indexOf(t.Producer) < 0) names.push(t.Producer); });
+	(stats.Edges || []).forEach(function(e) { if (names.indexOf(e.To) < 0) names.push(e.To); });
+	var pos = {}, cx = 360, cy = 240, r = 180;
+	names.forEach(function(n, i) {
+		var a = 2 * Math.PI * i / names.length;
+		pos[n] = [cx + r * Math.cos(a), cy + r * Math.sin(a)];
+	});
+	(stats.Edges || []).forEach(function(e) {
+		var p = pos[e.From], q = pos[e.To];
+		svg.appendChild(el("line", {svg: 1, x1: p[0], y1: p[1], x2: q[0], y2: q[1], stroke: "#999"}));
+		svg.appendChild(el("text", {svg: 1, x: (p[0] + q[0]) / 2, y: (p[1] + q[1]) / 2, fill: "#666"}, e.ContentType));
+	});
+	names.forEach(function(n) {
+		svg.appendChild(el("circle", {svg: 1, cx: pos[n][0], cy: pos[n][1], r: 6, fill: "#c3c"}));
+		svg.appendChild(el("text", {svg: 1, x: pos[n][0] + 9, y: pos[n][1] + 4}, n));
+	});
+}
+
+function refresh() {
+	fetch("stats").then(function(r) { return r.json(); }).then(function(stats) {
+		drawGraph(stats);
+		var body = document.getElementById("topics");
+		body.innerHTML = "";
+		(stats.Topics || []).forEach(function(t) {
+			body.appendChild(row([t.Name, "", t.Depth, "", Math.round(t.Rate * 10) / 10]));
+			(t.Channels || []).forEach(function(c) {
+				body.appendChild(row(["", c.Name, c.Depth, c.InFlight, ""]));
+			});
+		});
+	});
+	fetch("announcements").then(function(r) { return r.json(); }).then(function(as) {
+		var body = document.getElementById("announcements");
+		body.innerHTML = "";
+		(as || []).slice().reverse().forEach(function(a) {
+			body.appendChild(row([new Date(a.Time).toLocaleTimeString(), a.Service, a.ContentType]));
+		});
+	});
+}
+
+var source;
+document.getElementById("tailForm").onsubmit = function(ev) {
+	ev.preventDefault();
+	if (source) source.close();
+	var ct = document.getElementById("contentType").value;
+	var out = document.getElementById("tail");
+	out.textContent = "";
+	source = new EventSource("tail?contentType=" + encodeURIComponent(ct));
+	source.addEventListener(ct, function(e) {
+		var m = JSON.parse(e.data);
+		out.textContent += new Date(m.time).toLocaleTimeString() + "  " + m.from + "  " + m.payload + "\n";
+		out.scrollTop = out.scrollHeight;
+	});
+};
+
+refresh();
+setInterval(refresh, 2000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,187 @@
+package colony
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// A Reducer folds a Message into the running value of a window, which is nil
+// when the window opens.
+type Reducer func(acc []byte, m Message) []byte
+
+// Count is a Reducer counting the Messages in a window, as a decimal number.
+func Count() Reducer {
+	return func(acc []byte, m Message) []byte {
+		n, _ := strconv.ParseInt(string(acc), 10, 64)
+		return strconv.AppendInt(nil, n+1, 10)
+	}
+}
+
+// Sum is a Reducer adding up the value f takes from each Message in a window,
+// as a decimal number.
+func Sum(f func(Message) float64) Reducer {
+	return func(acc []byte, m Message) []byte {
+		sum, _ := strconv.ParseFloat(string(acc), 64)
+		return strconv.AppendFloat(nil, sum+f(m), 'g', -1, 64)
+	}
+}
+
+// A Checkpointer keeps the state of an Aggregator so that open windows survive
+// a restart.
+type Checkpointer interface {
+	SaveCheckpoint(name string, state []byte) error
+	// LoadCheckpoint returns the state saved as name, or nil if there's none.
+	LoadCheckpoint(name string) ([]byte, error)
+}
+
+// FileCheckpointer is a Checkpointer keeping each checkpoint in a file in Dir.
+type FileCheckpointer struct {
+	Dir string
+}
+
+// SaveCheckpoint replaces the checkpoint called name with state.
+func (f FileCheckpointer) SaveCheckpoint(name string, state []byte) error {
+	tmp, err := ioutil.TempFile(f.Dir, name)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(state); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(f.Dir, name))
+}
+
+// LoadCheckpoint returns the checkpoint called name.
+func (f FileCheckpointer) LoadCheckpoint(name string) ([]byte, error) {
+	state, err := ioutil.ReadFile(filepath.Join(f.Dir, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return state, err
+}
+
+// An Aggregator reduces the Messages of a content type over windows of time,
+// and emits the value of each window as a Message of Output when it closes.
+// Messages are placed in windows by the time they were sent, and those for
+// windows already closed are dropped.
+//
+// Windows are Size long. They tumble, one starting as the last ends, unless
+// Slide is set, in which case a new window starts every Slide.
+type Aggregator struct {
+	Name        string
+	Service     *Service
+	ContentType string
+	Output      string
+	Size        time.Duration
+	Slide       time.Duration
+	Reduce      Reducer
+	// Checkpoint, if set, saves the open windows as Name each time a window
+	// closes, and restores them when the Aggregator is run again. Messages
+	// received since the last checkpoint are lost if the service crashes.
+	Checkpoint Checkpointer
+}
+
+// Run consumes ContentType and emits the aggregate of each window until the
+// service is closed.
+func (a *Aggregator) Run() error {
+	if a.Size <= 0 {
+		return errors.New("aggregation window must be positive")
+	}
+	slide := a.Slide
+	if slide <= 0 {
+		slide = a.Size
+	}
+	windows, err := a.restore()
+	if err != nil {
+		return err
+	}
+	if err := a.Service.Announce(a.Output); err != nil {
+		return err
+	}
+
+	in := make(chan Message)
+	go func() {
+		err := a.Service.Consume(a.ContentType, func(c <-chan Message) error {
+			for m := range c {
+				select {
+				case in <- m:
+				case <-a.Service.lifecycle.done:
+					return nil
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Println("COLONY\t aggregator", a.Name, "could not consume", a.ContentType+":", err)
+		}
+	}()
+
+	ticker := time.NewTicker(slide)
+	defer ticker.Stop()
+	closed := time.Now().Truncate(slide) // windows ending by then are closed
+	for {
+		select {
+		case m := <-in:
+			// every window open at m.Time, latest first
+			for start := m.Time.Truncate(slide); m.Time.Sub(start) < a.Size; start = start.Add(-slide) {
+				if !start.Add(a.Size).After(closed) {
+					break
+				}
+				key := start.UnixNano()
+				windows[key] = a.Reduce(windows[key], m)
+			}
+		case now := <-ticker.C:
+			closed = now
+			for key, acc := range windows {
+				if time.Unix(0, key).Add(a.Size).After(now) {
+					continue
+				}
+				if err := a.Service.Emit(a.Service.NewMessage(a.Output, acc)); err != nil {
+					log.Println("COLONY\t aggregator", a.Name, "could not emit", a.Output+":", err)
+				}
+				delete(windows, key)
+			}
+			a.save(windows)
+		case <-a.Service.lifecycle.done:
+			return nil
+		}
+	}
+}
+
+// restore returns the windows saved at the last checkpoint.
+func (a *Aggregator) restore() (map[int64][]byte, error) {
+	windows := make(map[int64][]byte)
+	if a.Checkpoint == nil {
+		return windows, nil
+	}
+	state, err := a.Checkpoint.LoadCheckpoint(a.Name)
+	if err != nil || state == nil {
+		return windows, err
+	}
+	return windows, json.Unmarshal(state, &windows)
+}
+
+func (a *Aggregator) save(windows map[int64][]byte) {
+	if a.Checkpoint == nil {
+		return
+	}
+	state, err := json.Marshal(windows)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if err := a.Checkpoint.SaveCheckpoint(a.Name, state); err != nil {
+		log.Println("COLONY\t aggregator", a.Name, "could not save checkpoint:", err)
+	}
+}
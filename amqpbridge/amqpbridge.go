@@ -0,0 +1,87 @@
+// Package amqpbridge mirrors colony content types to RabbitMQ exchanges and
+// RabbitMQ queues into colony content types, so legacy AMQP consumers and
+// producers can take part in a colony during a migration.
+package amqpbridge
+
+import (
+	"log"
+
+	"github.com/nytlabs/colony"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// A Bridge relays messages between a colony service and an AMQP channel.
+// Messages the bridge itself relayed are never relayed back, so a content type
+// can be mirrored in both directions.
+type Bridge struct {
+	Service *colony.Service
+	Channel *amqp.Channel
+
+	// ToAMQP maps colony content types to the exchange they are published
+	// on. The content type is used as the routing key.
+	ToAMQP map[string]string
+	// FromAMQP maps AMQP queues to the colony content type their messages
+	// are emitted as.
+	FromAMQP map[string]string
+}
+
+// Run starts relaying in both directions. It returns once every relay is
+// running, or with the first error encountered.
+func (b *Bridge) Run() error {
+	for queue, contentType := range b.FromAMQP {
+		deliveries, err := b.Channel.Consume(queue, b.Service.Name+"-"+b.Service.ID, false, false, false, false, nil)
+		if err != nil {
+			return err
+		}
+		if err := b.Service.Announce(contentType); err != nil {
+			return err
+		}
+		go b.fromAMQP(deliveries, contentType)
+	}
+	for contentType, exchange := range b.ToAMQP {
+		go b.toAMQP(contentType, exchange)
+	}
+	return nil
+}
+
+func (b *Bridge) fromAMQP(deliveries <-chan amqp.Delivery, contentType string) {
+	for d := range deliveries {
+		if d.AppId == b.Service.Name {
+			d.Ack(false)
+			continue
+		}
+		m := b.Service.NewMessage(contentType, d.Body)
+		if err := b.Service.Emit(m); err != nil {
+			log.Println("COLONY\t could not relay AMQP message as", contentType+":", err)
+			d.Nack(false, true)
+			continue
+		}
+		d.Ack(false)
+	}
+}
+
+func (b *Bridge) toAMQP(contentType, exchange string) {
+	err := b.Service.Consume(contentType, func(msgs <-chan colony.Message) error {
+		for m := range msgs {
+			if m.FromName == b.Service.Name {
+				continue
+			}
+			err := b.Channel.Publish(exchange, contentType, false, false, amqp.Publishing{
+				ContentType: "application/octet-stream",
+				MessageId:   string(m.MessageID),
+				Timestamp:   m.Time,
+				Type:        m.ContentType,
+				AppId:       b.Service.Name,
+				Headers:     amqp.Table{"colony-from": m.FromName},
+				Body:        m.Payload,
+			})
+			if err != nil {
+				log.Println("COLONY\t could not relay", contentType, "to AMQP:", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("COLONY\t could not consume", contentType, "for AMQP:", err)
+	}
+}
@@ -0,0 +1,88 @@
+package colony
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// An Announcement is a service announcing that it produces a content type.
+type Announcement struct {
+	Service     string
+	ID          string
+	ContentType string
+	Topic       string
+	Time        time.Time
+	// Descriptor is what the service announced about itself, or nil if it
+	// is too old to describe itself.
+	Descriptor *ServiceDescriptor
+}
+
+// Announcements returns the announcements made in the colony from now on, so
+// that applications and tooling can react to new services and content types.
+// Each call follows announcements on a channel of its own. Announcements that
+// aren't read hold up the ones behind them once the channel's buffer is full,
+// and none arrive once the service is closed.
+func (s Service) Announcements() <-chan Announcement {
+	out := make(chan Announcement, s.buffers.Announcements)
+	channel := fmt.Sprintf("%s-%s-announcements-%x#ephemeral", s.Name, s.ID, s.randInt63())
+	accept := s.accept
+	done := s.lifecycle.done
+	_, err := s.subscribe("colony-announce", channel, func(body []byte) error {
+		var m Message
+		if err := json.Unmarshal(body, &m); err != nil {
+			return nil
+		}
+		if !accept(m) {
+			return nil
+		}
+		a := Announcement{
+			Service:     m.Topic.ServiceName,
+			ID:          m.Topic.ServiceID,
+			ContentType: m.ContentType,
+			Topic:       m.Topic.getName(),
+			Time:        m.Time,
+		}
+		var desc ServiceDescriptor
+		if err := json.Unmarshal(m.Payload, &desc); err == nil {
+			a.Descriptor = &desc
+		}
+		select {
+		case out <- a:
+		case <-done:
+		}
+		return nil
+	})
+	if err != nil && err != ErrClosed {
+		log.Println("COLONY\t could not follow announcements:", err)
+	}
+	return out
+}
+
+// WithReannounce makes the service announce every content type it produces
+// again every interval, so that services which missed an announcement, or
+// started after it, still find its topics.
+func WithReannounce(interval time.Duration) Option {
+	return func(s *Service) {
+		s.reannounce = interval
+	}
+}
+
+// reannouncer repeats the service's announcements until it is closed.
+func (s Service) reannouncer(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.lifecycle.done:
+			return
+		}
+		for _, contentType := range s.Describe().Produces {
+			if err := s.Announce(contentType); err != nil && err != ErrClosed {
+				log.Println("COLONY\t", s.Name, "could not announce", contentType, "again:", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package colony
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// An Archiver keeps the Messages of some content types for the long term, in
+// a BlobStore such as S3 or Cloud Storage. Messages are gathered into
+// segments, one content type to a segment, written as gzipped lines of JSON.
+// Alongside the segments each run of the Archiver keeps an index of them, so
+// that the Messages of a content type and time can be found without opening
+// every segment.
+//
+// An Archiver consumes as its Service, so it is best given a service of its
+// own.
+type Archiver struct {
+	Service      *Service
+	ContentTypes []string
+	Store        BlobStore
+	Prefix       string // prepended to the key of every segment and index
+	// A segment is written once it holds MaxMessages Messages, or its first
+	// Message is MaxAge old. They default to 10000 and 10 minutes.
+	MaxMessages int
+	MaxAge      time.Duration
+}
+
+// An ArchiveSegment is the index entry of a segment written by an Archiver.
+type ArchiveSegment struct {
+	Key         string
+	ContentType string
+	Count       int
+	First       time.Time // Time of the earliest Message in the segment
+	Last        time.Time // Time of the latest
+}
+
+type archiveBuffer struct {
+	msgs    []Message
+	started time.Time
+}
+
+// Run archives Messages until the service is closed.
+func (a *Archiver) Run() error {
+	maxMessages := a.MaxMessages
+	if maxMessages <= 0 {
+		maxMessages = 10000
+	}
+	maxAge := a.MaxAge
+	if maxAge <= 0 {
+		maxAge = 10 * time.Minute
+	}
+	started := time.Now().UTC()
+	indexKey := fmt.Sprintf("%sindex/%s-%s-%s.json", a.Prefix, started.Format("20060102T150405Z"), a.Service.Name, a.Service.ID)
+	var index []ArchiveSegment
+	buffers := make(map[string]*archiveBuffer)
+
+	flush := func(contentType string) {
+		b := buffers[contentType]
+		if b == nil || len(b.msgs) == 0 {
+			return
+		}
+		seg, err := a.write(contentType, b.msgs)
+		if err != nil {
+			// keep the buffer, and try again next time
+			log.Println("COLONY\t archiver could not write a segment of", contentType+":", err)
+			return
+		}
+		delete(buffers, contentType)
+		index = append(index, seg)
+		out, err := json.Marshal(index)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		if err := a.Store.Put(indexKey, out); err != nil {
+			log.Println("COLONY\t archiver could not write its index:", err)
+		}
+	}
+
+	return a.Service.Merge(func(c <-chan Message) error {
+		ticker := time.NewTicker(maxAge / 10)
+		defer ticker.Stop()
+		for {
+			select {
+			case m := <-c:
+				b := buffers[m.ContentType]
+				if b == nil {
+					b = &archiveBuffer{started: time.Now()}
+					buffers[m.ContentType] = b
+				}
+				b.msgs = append(b.msgs, m)
+				if len(b.msgs) >= maxMessages {
+					flush(m.ContentType)
+				}
+			case now := <-ticker.C:
+				for contentType, b := range buffers {
+					if now.Sub(b.started) >= maxAge {
+						flush(contentType)
+					}
+				}
+			case <-a.Service.lifecycle.done:
+				for contentType := range buffers {
+					flush(contentType)
+				}
+				return nil
+			}
+		}
+	}, a.ContentTypes...)
+}
+
+// write stores msgs as a segment.
+func (a *Archiver) write(contentType string, msgs []Message) (ArchiveSegment, error) {
+	seg := ArchiveSegment{
+		ContentType: contentType,
+		Count:       len(msgs),
+		First:       msgs[0].Time,
+		Last:        msgs[0].Time,
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	for _, m := range msgs {
+		if err := enc.Encode(m); err != nil {
+			return seg, err
+		}
+		if m.Time.Before(seg.First) {
+			seg.First = m.Time
+		}
+		if m.Time.After(seg.Last) {
+			seg.Last = m.Time
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return seg, err
+	}
+	seg.Key = fmt.Sprintf("%ssegments/%s/%s/%d-%s-%s.jsonl.gz", a.Prefix, topicContentType(contentType),
+		seg.First.UTC().Format("2006/01/02"), seg.First.UnixNano(), a.Service.Name, a.Service.ID)
+	return seg, a.Store.Put(seg.Key, buf.Bytes())
+}
+
+// ReadArchiveIndex returns the segments listed in the index at key.
+func ReadArchiveIndex(store BlobStore, key string) ([]ArchiveSegment, error) {
+	data, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	var index []ArchiveSegment
+	return index, json.Unmarshal(data, &index)
+}
+
+// ReadArchiveSegment returns the Messages in the segment at key.
+func ReadArchiveSegment(store BlobStore, key string) ([]Message, error) {
+	data, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var msgs []Message
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(nil, 1<<30)
+	for scanner.Scan() {
+		var m Message
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, scanner.Err()
+}
@@ -0,0 +1,115 @@
+package colony
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// The directions of an AuditRecord.
+const (
+	AuditEmitted  = "emitted"
+	AuditConsumed = "consumed"
+)
+
+// An AuditRecord is the trace a Message leaves in an AuditStore each time a
+// service emits or consumes it.
+type AuditRecord struct {
+	Time          time.Time // when the service emitted or consumed the Message
+	Direction     string    // AuditEmitted or AuditConsumed
+	Service       string    // name-id of the service recording it
+	From          string    // FromName of the Message
+	ContentType   string
+	MessageID     string
+	Topic         string
+	MessageTime   time.Time // the Message's own Time
+	CorrelationID string    `json:",omitempty"`
+	Span          string    `json:",omitempty"` // the Message's Span, if it has a CorrelationID
+	Parent        string    `json:",omitempty"` // the Span of the Message it was sent for, if known
+	PayloadSize   int
+	PayloadRef    string `json:",omitempty"`
+	PayloadHash   string `json:",omitempty"` // hex SHA-256 of the payload, if hashing
+	Signed        bool   // whether the Message carried a signature
+}
+
+// An AuditStore keeps AuditRecords, such as in an append-only log.
+type AuditStore interface {
+	Record(r AuditRecord) error
+}
+
+type auditor struct {
+	store  AuditStore
+	hashes bool
+}
+
+// WithAudit records every Message the service emits or consumes in store.
+// If hashPayloads is set each record carries a hash of the payload, so that
+// what was sent can be proved without keeping it.
+func WithAudit(store AuditStore, hashPayloads bool) Option {
+	return func(s *Service) {
+		s.auditor = &auditor{store: store, hashes: hashPayloads}
+	}
+}
+
+// audit records that the service emitted or consumed m on topic.
+func (s Service) audit(direction, topic string, m Message) {
+	if s.auditor == nil && !s.tracing {
+		return
+	}
+	r := AuditRecord{
+		Time:          time.Now(),
+		Direction:     direction,
+		Service:       s.Name + "-" + s.ID,
+		From:          m.FromName,
+		ContentType:   m.ContentType,
+		MessageID:     string(m.MessageID),
+		Topic:         topic,
+		MessageTime:   m.Time,
+		CorrelationID: m.CorrelationID,
+		PayloadSize:   len(m.Payload),
+		PayloadRef:    m.PayloadRef,
+		Signed:        len(m.Signature) > 0,
+	}
+	if m.CorrelationID != "" {
+		r.Span = m.Span()
+		r.Parent = m.Header(ParentHeader)
+		s.trace(r)
+	}
+	if s.auditor == nil {
+		return
+	}
+	if s.auditor.hashes && m.PayloadRef == "" {
+		sum := sha256.Sum256(m.Payload)
+		r.PayloadHash = hex.EncodeToString(sum[:])
+	}
+	if err := s.auditor.store.Record(r); err != nil {
+		log.Println("COLONY\t could not audit", direction, m.ContentType, "message", m.MessageID+":", err)
+	}
+}
+
+// AuditLog is an AuditStore writing each record to W as a line of JSON.
+type AuditLog struct {
+	mu sync.Mutex
+	W  io.Writer
+}
+
+// NewAuditLog returns an AuditLog writing to w.
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{W: w}
+}
+
+// Record writes r to the log.
+func (a *AuditLog) Record(r AuditRecord) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.W.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,141 @@
+package colony
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// benchSize is the payload size of the benchmarks' Messages, in bytes.
+const benchSize = 256
+
+// benchServices returns n services sharing a fresh MemoryTransport.
+func benchServices(b *testing.B, n int) []*Service {
+	transport := NewMemoryTransport()
+	var out []*Service
+	for i := 0; i < n; i++ {
+		s := NewService(fmt.Sprintf("bench%d", i), "1", "", WithTransport(transport), WithQuiet())
+		b.Cleanup(func() { s.Close() })
+		out = append(out, s)
+	}
+	return out
+}
+
+// latencies records how long Messages took to arrive.
+type latencies struct {
+	mu sync.Mutex
+	d  []time.Duration
+}
+
+func (l *latencies) add(m Message) {
+	d := time.Since(m.Time)
+	l.mu.Lock()
+	l.d = append(l.d, d)
+	l.mu.Unlock()
+}
+
+// report adds the throughput of n messages and the recorded latencies to b.
+func (l *latencies) report(b *testing.B, n int) {
+	b.StopTimer()
+	b.ReportMetric(float64(n)/b.Elapsed().Seconds(), "msgs/s")
+	if len(l.d) == 0 {
+		return
+	}
+	sort.Slice(l.d, func(i, j int) bool { return l.d[i] < l.d[j] })
+	pct := func(p float64) float64 {
+		return float64(l.d[int(p*float64(len(l.d)-1))].Microseconds())
+	}
+	b.ReportMetric(pct(0.5), "p50-µs")
+	b.ReportMetric(pct(0.99), "p99-µs")
+}
+
+// benchConsume delivers every Message of contentType to each, and returns once
+// consuming has begun.
+func benchConsume(s *Service, contentType string, each func(Message)) {
+	go s.Consume(contentType, func(c <-chan Message) error {
+		for m := range c {
+			each(m)
+		}
+		return nil
+	})
+	// let the consumer find the topic
+	time.Sleep(200 * time.Millisecond)
+}
+
+// BenchmarkEmit emits b.N Messages to a single consumer.
+func BenchmarkEmit(b *testing.B) {
+	ss := benchServices(b, 2)
+	producer, consumer := ss[0], ss[1]
+	producer.Announce("bench")
+	payload := make([]byte, benchSize)
+	var lat latencies
+	done := make(chan struct{})
+	var received int
+	benchConsume(consumer, "bench", func(m Message) {
+		lat.add(m)
+		if received++; received == b.N {
+			close(done)
+		}
+	})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := producer.Emit(producer.NewMessage("bench", payload)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	<-done
+	lat.report(b, b.N)
+}
+
+// BenchmarkRequest makes b.N calls, one at a time, to a service that answers
+// each.
+func BenchmarkRequest(b *testing.B) {
+	ss := benchServices(b, 2)
+	client, server := ss[0], ss[1]
+	client.Announce("benchrequest")
+	payload := make([]byte, benchSize)
+	benchConsume(server, "benchrequest", func(m Message) {
+		server.Emit(server.NewResponse(m, "benchresponse", m.Payload))
+	})
+	var lat latencies
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		m := client.NewMessage("benchrequest", payload)
+		if _, err := client.Call(ctx, m); err != nil {
+			b.Fatal(err)
+		}
+		cancel()
+		lat.add(m)
+	}
+	lat.report(b, b.N)
+}
+
+// BenchmarkFanout emits b.N Messages each consumed by four services.
+func BenchmarkFanout(b *testing.B) {
+	const consumers = 4
+	ss := benchServices(b, consumers+1)
+	producer := ss[0]
+	producer.Announce("bench")
+	payload := make([]byte, benchSize)
+	var lat latencies
+	var wg sync.WaitGroup
+	for _, consumer := range ss[1:] {
+		wg.Add(b.N)
+		benchConsume(consumer, "bench", func(m Message) {
+			lat.add(m)
+			wg.Done()
+		})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := producer.Emit(producer.NewMessage("bench", payload)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	wg.Wait()
+	lat.report(b, b.N*consumers)
+}
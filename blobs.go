@@ -0,0 +1,141 @@
+package colony
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNoBlobStore is returned when a Message's payload was offloaded to a
+// BlobStore but the receiving service has none configured.
+var ErrNoBlobStore = errors.New("payload is in a blob store but none is configured")
+
+// ErrBadBlobKey is returned by a FileBlobStore for a key that would lead
+// outside its Dir.
+var ErrBadBlobKey = errors.New("blob key escapes the store's directory")
+
+// A BlobStore holds payloads too large to send through NSQ. Producers and
+// consumers of a content type must share the same store.
+type BlobStore interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+}
+
+// A BlobLister is a BlobStore that can list its keys, as a Restore needs.
+type BlobLister interface {
+	BlobStore
+	// List returns the keys starting with prefix, in order.
+	List(prefix string) ([]string, error)
+}
+
+// WithBlobStore offloads payloads larger than threshold bytes to store. The
+// Message sent through NSQ carries only a reference to the payload, which
+// consumers fetch with FetchPayload.
+func WithBlobStore(store BlobStore, threshold int) Option {
+	return func(s *Service) {
+		s.blobs = store
+		s.blobThreshold = threshold
+	}
+}
+
+// FetchPayload returns the Message's payload, fetching it from the service's
+// BlobStore if it was offloaded by the sender. Handlers that may receive
+// offloaded payloads should use FetchPayload rather than reading Payload.
+func (m Message) FetchPayload() ([]byte, error) {
+	if m.PayloadRef == "" {
+		return m.Payload, nil
+	}
+	if m.blobs == nil {
+		return nil, ErrNoBlobStore
+	}
+	return m.blobs.Get(m.PayloadRef)
+}
+
+// offload moves m's payload into the service's BlobStore if it is over the
+// threshold.
+func (s Service) offload(m Message) (Message, error) {
+	if s.blobs == nil || len(m.Payload) <= s.blobThreshold {
+		return m, nil
+	}
+	key := fmt.Sprintf("%s/%s/%s-%x", s.Name, s.ID, m.MessageID, s.randInt63())
+	if err := s.blobs.Put(key, m.Payload); err != nil {
+		return m, err
+	}
+	m.PayloadRef = key
+	m.Payload = nil
+	return m, nil
+}
+
+// FileBlobStore is a BlobStore keeping payloads as files below Dir, which is
+// typically a shared network filesystem.
+type FileBlobStore struct {
+	Dir string
+}
+
+// Put writes data to the file for key.
+func (f FileBlobStore) Put(key string, data []byte) error {
+	path, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Get reads the file for key.
+func (f FileBlobStore) Get(key string) ([]byte, error) {
+	path, err := f.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(path)
+}
+
+// path returns the file for key. Keys come from the PayloadRefs of received
+// Messages, so any that is absolute, climbs with .. or otherwise ends up
+// outside Dir is refused.
+func (f FileBlobStore) path(key string) (string, error) {
+	rel := filepath.FromSlash(key)
+	if key == "" || strings.HasPrefix(key, "/") || filepath.IsAbs(rel) {
+		return "", ErrBadBlobKey
+	}
+	for _, part := range strings.FieldsFunc(key, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return "", ErrBadBlobKey
+		}
+	}
+	dir := filepath.Clean(f.Dir)
+	path := filepath.Join(dir, rel)
+	inside, err := filepath.Rel(dir, path)
+	if err != nil || inside == "." || inside == ".." || strings.HasPrefix(inside, ".."+string(filepath.Separator)) {
+		return "", ErrBadBlobKey
+	}
+	return path, nil
+}
+
+// List returns the keys of the files below Dir starting with prefix.
+func (f FileBlobStore) List(prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.Walk(f.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(f.Dir, path)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return keys, err
+}
@@ -0,0 +1,100 @@
+// Package boltspool provides a colony Spool kept in a local bolt database
+// file.
+package boltspool
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/nytlabs/colony"
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucket = []byte("spool")
+
+// Spool is a colony.Spool kept in a bolt database.
+type Spool struct {
+	DB *bolt.DB
+}
+
+type entry struct {
+	Topic string
+	Body  []byte
+}
+
+// Open opens, creating if need be, the spool in the bolt database file at
+// path.
+func Open(path string) (*Spool, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Spool{DB: db}, nil
+}
+
+// Close closes the spool's database.
+func (s *Spool) Close() error {
+	return s.DB.Close()
+}
+
+// Append adds body, to be published on topic, to the end of the spool.
+func (s *Spool) Append(topic string, body []byte) error {
+	v, err := json.Marshal(entry{Topic: topic, Body: body})
+	if err != nil {
+		return err
+	}
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		return b.Put(key(seq), v)
+	})
+}
+
+// Oldest returns the entry at the front of the spool.
+func (s *Spool) Oldest() (colony.SpoolEntry, bool, error) {
+	var e colony.SpoolEntry
+	var ok bool
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		k, v := tx.Bucket(bucket).Cursor().First()
+		if k == nil {
+			return nil
+		}
+		var stored entry
+		if err := json.Unmarshal(v, &stored); err != nil {
+			return err
+		}
+		e = colony.SpoolEntry{
+			Seq:   binary.BigEndian.Uint64(k),
+			Topic: stored.Topic,
+			Body:  stored.Body,
+		}
+		ok = true
+		return nil
+	})
+	return e, ok, err
+}
+
+// Remove takes the entry numbered seq out of the spool.
+func (s *Spool) Remove(seq uint64) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Delete(key(seq))
+	})
+}
+
+// key returns the bolt key of seq, which sorts in order of seq.
+func key(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
@@ -0,0 +1,49 @@
+package colony
+
+// A Bridge brings the messages of plain NSQ topics into the colony, emitting
+// each as a Message of a content type with the message body as its Payload, so
+// that an existing NSQ estate can move to colony a piece at a time. Consumers
+// of the content types needn't know where their Messages came from.
+type Bridge struct {
+	Service *Service
+	// Topics maps each NSQ topic to the content type its messages are
+	// emitted as.
+	Topics map[string]string
+	// Channel is the channel the topics are consumed on. It defaults to
+	// the service's name.
+	Channel string
+}
+
+// Run announces the content types and bridges messages until the service is
+// closed. A message that can't be emitted is delivered to the Bridge again
+// later. Run returns the first error subscribing to a topic.
+func (b *Bridge) Run() error {
+	s := b.Service
+	channel := b.Channel
+	if channel == "" {
+		channel = s.Name
+	}
+	var subs []Subscription
+	stop := func() {
+		for _, sub := range subs {
+			sub.Stop()
+		}
+	}
+	for topic, contentType := range b.Topics {
+		if err := s.Announce(contentType); err != nil {
+			stop()
+			return err
+		}
+		contentType := contentType
+		sub, err := s.ConsumeRaw(topic, channel, func(body []byte) error {
+			return s.Emit(s.NewMessage(contentType, body))
+		})
+		if err != nil {
+			stop()
+			return err
+		}
+		subs = append(subs, sub)
+	}
+	<-s.lifecycle.done
+	return nil
+}
@@ -0,0 +1,22 @@
+package colony
+
+// Buffers sets how many Messages the channels inside a service hold. By
+// default they hold none, so each hand-off waits for the receiver: a slow
+// handler holds up the transport's goroutine feeding it, and, for responses,
+// every other handler behind it. A buffer lets the sender move on, at the
+// cost of memory for as many Messages as it holds. Buffered Messages have
+// already been acknowledged to the transport, so they are lost if the process
+// dies, and a Message of high priority waits behind those already buffered.
+type Buffers struct {
+	Consumer      int // Messages waiting for each Consume handler
+	Response      int // responses waiting for the service, and for each response handler
+	Announcements int // announcements waiting for each consumer and Announcements reader
+}
+
+// WithBuffers gives the service's internal channels the sizes in b.
+// ConsumeOptions can set a different size for one consumer.
+func WithBuffers(b Buffers) Option {
+	return func(s *Service) {
+		s.buffers = b
+	}
+}
@@ -0,0 +1,19 @@
+package colony
+
+import "context"
+
+// Bus is the set of Service methods application code uses to talk to the
+// colony. Code written against a Bus rather than a *Service can be tested with
+// a fake, such as the one in the colonytest package.
+type Bus interface {
+	NewMessage(contentType string, payload []byte) Message
+	NewResponse(m Message, contentType string, payload []byte) Message
+	NewErrorResponse(m Message, err error) Message
+	Announce(contentType string) error
+	Emit(m Message) error
+	Request(m Message, h Handler) error
+	Call(ctx context.Context, m Message, opts ...CallOption) (Message, error)
+	Consume(contentType string, h Handler) error
+}
+
+var _ Bus = (*Service)(nil)
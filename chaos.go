@@ -0,0 +1,60 @@
+package colony
+
+import (
+	"strings"
+	"time"
+)
+
+// Chaos says how often a service with WithChaos mistreats the messages it
+// receives, as a fraction between 0 and 1 of them for each kind of failure, so
+// that its handlers and retry policies can be tried out against what a real
+// network and misbehaving producers do.
+type Chaos struct {
+	Delay     float64       // held back for up to MaxDelay before delivery
+	MaxDelay  time.Duration // the longest a message is held back
+	Drop      float64       // lost without being delivered
+	Duplicate float64       // delivered twice
+	Corrupt   float64       // delivered with one byte changed
+}
+
+// WithChaos has the service mistreat the messages it receives as c says,
+// before its handlers see them. Colony's own topics, such as announcements,
+// are spared. Combined with WithSeed the same messages are mistreated in the
+// same way every run. It is meant for testing.
+func WithChaos(c Chaos) Option {
+	return func(s *Service) {
+		s.chaos = &c
+	}
+}
+
+// chaotic returns h, mistreating the messages of topic first if the service
+// has Chaos.
+func (s Service) chaotic(topic string, h func(body []byte) error) func(body []byte) error {
+	c := s.chaos
+	if c == nil || strings.HasPrefix(topic, "colony-") {
+		return h
+	}
+	return func(body []byte) error {
+		if s.chance(c.Drop) {
+			return nil
+		}
+		if s.chance(c.Delay) && c.MaxDelay > 0 {
+			time.Sleep(time.Duration(s.randInt63() % int64(c.MaxDelay)))
+		}
+		if s.chance(c.Corrupt) && len(body) > 0 {
+			body = append([]byte(nil), body...)
+			body[s.randIntn(len(body))] ^= byte(1 + s.randIntn(255))
+		}
+		if s.chance(c.Duplicate) {
+			if err := h(body); err != nil {
+				return err
+			}
+		}
+		return h(body)
+	}
+}
+
+// chance reports true for the fraction p of calls.
+func (s Service) chance(p float64) bool {
+	return p > 0 && float64(s.randInt63())/(1<<63) < p
+}
@@ -0,0 +1,135 @@
+package colony
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultChunkSize is the largest payload a service sends in a single NSQ
+// message unless configured otherwise. Payloads are base64 encoded inside the
+// JSON envelope, so this keeps messages comfortably below nsqd's default
+// --max-msg-size of 1MB.
+const DefaultChunkSize = 512 * 1024
+
+// A Chunk identifies one part of a Message whose payload was split into
+// several NSQ messages. Parts are reassembled before they reach a Handler, so
+// handlers never see a Message with a Chunk.
+//
+// All parts of a Message must reach the same service instance to be
+// reassembled, so chunking is best suited to channels with a single consumer.
+type Chunk struct {
+	Set   string // identifies the parts belonging to one Message
+	Index int    // position of this part, starting at 0
+	Count int    // total number of parts
+}
+
+// WithChunkSize sets the largest payload the service sends in a single NSQ
+// message. Larger payloads are split into parts and reassembled by the
+// receiving service. A size of 0 disables chunking.
+func WithChunkSize(n int) Option {
+	return func(s *Service) {
+		s.chunkSize = n
+	}
+}
+
+// split breaks m into parts no larger than the service's chunk size.
+func (s Service) split(m Message) []Message {
+	if s.chunkSize <= 0 || len(m.Payload) <= s.chunkSize {
+		return []Message{m}
+	}
+	count := (len(m.Payload) + s.chunkSize - 1) / s.chunkSize
+	set := fmt.Sprintf("%x", s.randInt63())
+	parts := make([]Message, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * s.chunkSize
+		if end > len(m.Payload) {
+			end = len(m.Payload)
+		}
+		part := m
+		part.Payload = m.Payload[i*s.chunkSize : end]
+		part.Chunk = &Chunk{Set: set, Index: i, Count: count}
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+type partial struct {
+	parts    [][]byte
+	received []Message // the parts as they arrived
+	count    int       // parts arrived
+	started  time.Time
+}
+
+// reassembler collects the parts of chunked Messages until they are complete.
+type reassembler struct {
+	sync.Mutex
+	timeout time.Duration
+	pending map[string]*partial
+}
+
+func newReassembler(timeout time.Duration) *reassembler {
+	return &reassembler{
+		timeout: timeout,
+		pending: make(map[string]*partial),
+	}
+}
+
+// add records a part of a chunked Message. Once every part has arrived it
+// returns the reassembled Message and true. Messages that aren't chunked are
+// returned as they are. Incomplete Messages are dropped after the
+// reassembler's timeout.
+func (r *reassembler) add(m Message) (Message, bool) {
+	if m.Chunk == nil {
+		return m, true
+	}
+	c := *m.Chunk
+	if c.Count <= 0 || c.Index < 0 || c.Index >= c.Count {
+		log.Println("COLONY\t dropping malformed chunk of message", m.MessageID, "from", m.FromName)
+		return m, false
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	now := time.Now()
+	for key, p := range r.pending {
+		if now.Sub(p.started) > r.timeout {
+			log.Println("COLONY\t dropping incomplete chunked message", key)
+			delete(r.pending, key)
+		}
+	}
+
+	key := m.FromName + "/" + c.Set
+	p, ok := r.pending[key]
+	if !ok {
+		p = &partial{
+			parts:    make([][]byte, c.Count),
+			received: make([]Message, c.Count),
+			started:  now,
+		}
+		r.pending[key] = p
+	}
+	if len(p.parts) != c.Count || p.parts[c.Index] != nil {
+		return m, false
+	}
+	p.parts[c.Index] = m.Payload
+	p.received[c.Index] = m
+	p.count++
+	if p.count < c.Count {
+		return m, false
+	}
+	delete(r.pending, key)
+
+	var payload []byte
+	for _, part := range p.parts {
+		payload = append(payload, part...)
+	}
+	m.Payload = payload
+	m.Chunk = nil
+	m.received = nil
+	for _, part := range p.received {
+		m.received = append(m.received, part.received...)
+	}
+	return m, true
+}
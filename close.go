@@ -0,0 +1,94 @@
+package colony
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by a Service that has been closed.
+var ErrClosed = errors.New("service is closed")
+
+// lifecycle keeps track of what a service has running, so that Close can stop
+// it.
+type lifecycle struct {
+	mu      sync.Mutex
+	subs    []Subscription
+	closers []func() // run by Close once subscriptions have stopped
+	closed  bool
+	done    chan struct{} // closed by Close
+}
+
+func newLifecycle() *lifecycle {
+	return &lifecycle{done: make(chan struct{})}
+}
+
+func (l *lifecycle) isClosed() bool {
+	select {
+	case <-l.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// subscribe subscribes h to topic on channel until the service is closed.
+func (s Service) subscribe(topic, channel string, h func(body []byte) error) (Subscription, error) {
+	return s.track(s.transport.Subscribe(topic, channel, s.chaotic(topic, h)))
+}
+
+// track has Close stop sub, the result of subscribing.
+func (s Service) track(sub Subscription, err error) (Subscription, error) {
+	if err != nil {
+		return nil, err
+	}
+	l := s.lifecycle
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		sub.Stop()
+		return nil, ErrClosed
+	}
+	l.subs = append(l.subs, sub)
+	return sub, nil
+}
+
+// Close says goodbye to the colony and stops the service's heartbeats and
+// subscriptions, so that Registries stop counting it as a producer and can
+// tell a clean shutdown from a crash. Handlers stop receiving Messages but are
+// left to return on their own. Closing a closed service does nothing.
+func (s Service) Close() error {
+	l := s.lifecycle
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.done)
+	subs, closers := l.subs, l.closers
+	l.subs, l.closers = nil, nil
+	l.mu.Unlock()
+
+	err := s.goodbye()
+	for _, sub := range subs {
+		sub.Stop()
+	}
+	for _, f := range closers {
+		f()
+	}
+	s.events.close(ServiceEvent{Kind: Closed, Time: time.Now()})
+	return err
+}
+
+// atClose has Close call f. It reports false if the service is already
+// closed.
+func (l *lifecycle) atClose(f func()) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return false
+	}
+	l.closers = append(l.closers, f)
+	return true
+}
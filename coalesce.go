@@ -0,0 +1,125 @@
+package colony
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// A BatchTransport is a Transport that can publish several messages on a topic
+// at once. NSQTransport and MemoryTransport are BatchTransports.
+type BatchTransport interface {
+	Transport
+	MultiPublish(topic string, bodies [][]byte) error
+}
+
+// WithCoalescing makes the service hold back the Messages it emits on each
+// topic for up to window, or until max of them are waiting, and then publish
+// them together. Each Message is delayed by at most window, in exchange for
+// far fewer round trips to the transport when many are emitted. Emit then only
+// fails if the Message can't be encoded; publishing errors are logged, and
+// any retries and spooling happen when the batch is published. Whatever is
+// held back is published when the service is closed.
+func WithCoalescing(window time.Duration, max int) Option {
+	return func(s *Service) {
+		s.coalescer = &coalescer{
+			window:  window,
+			max:     max,
+			batches: make(map[string]*batch),
+		}
+	}
+}
+
+// coalescer holds back a service's outgoing Messages by topic.
+type coalescer struct {
+	window time.Duration
+	max    int
+
+	mu      sync.Mutex
+	batches map[string]*batch
+}
+
+// batch is the Messages waiting to be published on a topic.
+type batch struct {
+	topic   string
+	sending sync.Mutex // held while publishing, so batches stay in order
+	pending []pendingMessage
+	timer   *time.Timer
+}
+
+type pendingMessage struct {
+	m    Message
+	body []byte
+}
+
+// coalesce adds body, the encoding of m, to the batch for topic, and
+// publishes the batch if it is full.
+func (s Service) coalesce(topic string, m Message, body []byte) error {
+	c := s.coalescer
+	c.mu.Lock()
+	b, ok := c.batches[topic]
+	if !ok {
+		b = &batch{topic: topic}
+		c.batches[topic] = b
+	}
+	// body is the caller's to reuse
+	b.pending = append(b.pending, pendingMessage{m, append([]byte(nil), body...)})
+	full := len(b.pending) >= c.max
+	if len(b.pending) == 1 && !full {
+		b.timer = time.AfterFunc(c.window, func() { s.flushBatch(b) })
+	}
+	c.mu.Unlock()
+	if full {
+		s.flushBatch(b)
+	}
+	return nil
+}
+
+// flushBatch publishes whatever is waiting in b.
+func (s Service) flushBatch(b *batch) {
+	b.sending.Lock()
+	defer b.sending.Unlock()
+	c := s.coalescer
+	c.mu.Lock()
+	pending := b.pending
+	b.pending = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	c.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	// a spool keeps Messages in order by publishing them one at a time
+	if bt, ok := s.transport.(BatchTransport); ok && len(pending) > 1 && s.spool == nil {
+		bodies := make([][]byte, len(pending))
+		for i, p := range pending {
+			bodies[i] = p.body
+		}
+		err := bt.MultiPublish(b.topic, bodies)
+		if err == nil {
+			return
+		}
+		log.Println("COLONY\t could not publish", len(pending), "messages on", b.topic+", sending them one by one:", err)
+	}
+	for _, p := range pending {
+		if err := s.deliver(b.topic, p.m, p.body); err != nil {
+			log.Println("COLONY\t could not publish", p.m.ContentType, "message", p.m.MessageID+":", err)
+		}
+	}
+}
+
+// flushAll publishes every batch.
+func (s Service) flushAll() {
+	c := s.coalescer
+	c.mu.Lock()
+	batches := make([]*batch, 0, len(c.batches))
+	for _, b := range c.batches {
+		batches = append(batches, b)
+	}
+	c.mu.Unlock()
+	for _, b := range batches {
+		s.flushBatch(b)
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !jsoniter
+
+package colony
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// encodeEnvelope writes the Message envelope v to w as JSON, and
+// decodeEnvelope reads one back, on the hot paths of publishing and
+// consuming. They use encoding/json unless the package is built with the
+// jsoniter tag, which swaps in json-iterator's faster encoder and decoder for
+// the same wire format.
+func encodeEnvelope(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func decodeEnvelope(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
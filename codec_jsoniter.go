@@ -0,0 +1,19 @@
+//go:build jsoniter
+
+package colony
+
+import (
+	"io"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+var envelopeJSON = jsoniter.ConfigCompatibleWithStandardLibrary
+
+func encodeEnvelope(w io.Writer, v interface{}) error {
+	return envelopeJSON.NewEncoder(w).Encode(v)
+}
+
+func decodeEnvelope(data []byte, v interface{}) error {
+	return envelopeJSON.Unmarshal(data, v)
+}
@@ -0,0 +1,26 @@
+// Command colonyadmin runs a colony service serving the admin dashboard.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/nytlabs/colony"
+)
+
+var (
+	lookupd = flag.String("lookupd", "localhost:4161", "HTTP address of nsqlookupd")
+	addr    = flag.String("http", ":8080", "address to serve the dashboard on")
+	id      = flag.String("id", strconv.Itoa(os.Getpid()), "ID of the admin service")
+)
+
+func main() {
+	flag.Parse()
+
+	s := colony.NewService("admin", *id, *lookupd)
+	log.Println("serving dashboard on", *addr)
+	log.Fatal(http.ListenAndServe(*addr, colony.NewAdmin(s)))
+}
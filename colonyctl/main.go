@@ -0,0 +1,311 @@
+// Command colonyctl inspects and pokes at a running colony.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bitly/go-nsq"
+	"github.com/nytlabs/colony"
+)
+
+var (
+	lookupd = flag.String("lookupd", "localhost:4161", "HTTP address of nsqlookupd")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: colonyctl [flags] command [args]
+
+commands:
+  services                    list services and the content types they produce
+  contenttypes                list content types and the services producing them
+  topics                      list topics and channels with their depths
+  graph [dot|json]            print which services feed which, as DOT or JSON
+  announcements               print announcements as they happen
+  tail contentType            print messages of a content type as they happen
+  emit contentType payload    emit a test message
+  pause contentType           stop delivering a content type to consumers
+  unpause contentType         deliver a paused content type again
+  delete contentType          delete the unused topics of a content type
+  channels topic              list the channels of a topic with their depths
+  empty topic channel         throw away the messages waiting in a channel
+  trace correlationID         follow a request's trace until interrupted, then
+                              print its tree of messages
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	switch flag.Arg(0) {
+	case "services":
+		services()
+	case "contenttypes":
+		contentTypes()
+	case "topics":
+		topics()
+	case "graph":
+		graph(flag.Arg(1))
+	case "announcements":
+		announcements()
+	case "tail":
+		if flag.NArg() != 2 {
+			usage()
+			os.Exit(2)
+		}
+		tail(flag.Arg(1))
+	case "emit":
+		if flag.NArg() != 3 {
+			usage()
+			os.Exit(2)
+		}
+		emit(flag.Arg(1), flag.Arg(2))
+	case "pause", "unpause":
+		if flag.NArg() != 2 {
+			usage()
+			os.Exit(2)
+		}
+		pause(flag.Arg(1), flag.Arg(0) == "pause")
+	case "delete":
+		if flag.NArg() != 2 {
+			usage()
+			os.Exit(2)
+		}
+		deleteTopics(flag.Arg(1))
+	case "channels":
+		if flag.NArg() != 2 {
+			usage()
+			os.Exit(2)
+		}
+		channels(flag.Arg(1))
+	case "empty":
+		if flag.NArg() != 3 {
+			usage()
+			os.Exit(2)
+		}
+		empty(flag.Arg(1), flag.Arg(2))
+	case "trace":
+		if flag.NArg() != 2 {
+			usage()
+			os.Exit(2)
+		}
+		trace(flag.Arg(1))
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func transport() *colony.NSQTransport {
+	t, err := colony.NewNSQTransport(*lookupd, nsq.NewConfig())
+	if err != nil {
+		log.Fatal(err)
+	}
+	return t
+}
+
+// exit closes s, then exits with err if it isn't nil.
+func exit(s *colony.Service, err error) {
+	s.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// service returns a service for commands that take part in the colony. It
+// keeps quiet, as colonyctl's output is its result, and leaves no response
+// topic behind once it is closed.
+func service() *colony.Service {
+	return colony.NewService("colonyctl", strconv.Itoa(os.Getpid()), *lookupd,
+		colony.WithQuiet(), colony.WithEphemeralResponses())
+}
+
+// producers maps each service to the content types it produces, or each
+// content type to the services producing it.
+func producers(byService bool) map[string][]string {
+	names, err := transport().Discover()
+	if err != nil {
+		log.Fatal(err)
+	}
+	out := make(map[string][]string)
+	for _, name := range names {
+		service, id, contentType, ok := colony.SplitTopic(name)
+		if !ok || contentType == "responses" {
+			continue
+		}
+		if byService {
+			out[service+"-"+id] = append(out[service+"-"+id], contentType)
+		} else {
+			out[contentType] = append(out[contentType], service+"-"+id)
+		}
+	}
+	return out
+}
+
+func printTable(m map[string][]string, heading string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, heading)
+	for _, k := range keys {
+		sort.Strings(m[k])
+		fmt.Fprintf(w, "%s\t%s\n", k, strings.Join(m[k], ", "))
+	}
+	w.Flush()
+}
+
+func services() {
+	printTable(producers(true), "SERVICE\tCONTENT TYPES")
+}
+
+func contentTypes() {
+	printTable(producers(false), "CONTENT TYPE\tPRODUCERS")
+}
+
+func topics() {
+	stats, err := transport().TopicStats()
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TOPIC\tCHANNEL\tDEPTH\tIN FLIGHT\tMESSAGES\tPAUSED")
+	for _, t := range stats {
+		fmt.Fprintf(w, "%s\t\t%d\t\t%d\t%t\n", t.Name, t.Depth, t.MessageCount, t.Paused)
+		for _, c := range t.Channels {
+			fmt.Fprintf(w, "\t%s\t%d\t%d\t%d\t%t\n", c.Name, c.Depth, c.InFlight, c.MessageCount, c.Paused)
+		}
+	}
+	w.Flush()
+}
+
+func graph(format string) {
+	t, err := colony.DiscoverTopology(transport())
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch format {
+	case "", "dot":
+		err = t.WriteDOT(os.Stdout)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(t)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func waitForInterrupt() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	<-c
+}
+
+func announcements() {
+	channel := "colonyctl-" + strconv.Itoa(os.Getpid()) + "#ephemeral"
+	sub, err := transport().Subscribe("colony-announce", channel, func(body []byte) error {
+		var m colony.Message
+		if err := json.Unmarshal(body, &m); err != nil {
+			log.Println("unreadable announcement:", err)
+			return nil
+		}
+		fmt.Printf("%s\t%s-%s\t%s\n", m.Time.Format("15:04:05.000"), m.Topic.ServiceName, m.Topic.ServiceID, m.ContentType)
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	waitForInterrupt()
+	sub.Stop()
+}
+
+func tail(contentType string) {
+	s := service()
+	defer s.Close()
+	msgs, stop := s.Tap(contentType)
+	go func() {
+		for m := range msgs {
+			fmt.Printf("%s\t%s\t%s\t%s\n", m.Time.Format("15:04:05.000"), m.FromName, m.MessageID, m.Payload)
+		}
+	}()
+	waitForInterrupt()
+	stop()
+}
+
+func trace(correlationID string) {
+	s := service()
+	c := colony.NewTraceCollector(s, 0)
+	waitForInterrupt()
+	spans, ok := c.Trace(correlationID)
+	s.Close()
+	if !ok {
+		log.Fatal("no trace events seen for ", correlationID)
+	}
+	if err := colony.WriteTrace(os.Stdout, spans); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func emit(contentType, payload string) {
+	s := service()
+	err := s.Announce(contentType)
+	if err == nil {
+		err = s.Emit(s.NewMessage(contentType, []byte(payload)))
+	}
+	exit(s, err)
+}
+
+func pause(contentType string, paused bool) {
+	s := service()
+	var err error
+	if paused {
+		err = s.PauseContentType(contentType)
+	} else {
+		err = s.UnpauseContentType(contentType)
+	}
+	exit(s, err)
+}
+
+func deleteTopics(contentType string) {
+	s := service()
+	exit(s, s.DeleteTopic(contentType))
+}
+
+func channels(topic string) {
+	s := service()
+	defer s.Close()
+	cs, err := s.Channels(topic)
+	if err != nil {
+		exit(s, err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CHANNEL\tDEPTH\tIN FLIGHT\tDEFERRED\tREQUEUED\tTIMED OUT\tCLIENTS\tPAUSED")
+	for _, c := range cs {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%t\n", c.Name, c.Depth, c.InFlight, c.Deferred, c.RequeueCount, c.TimeoutCount, c.Clients, c.Paused)
+	}
+	w.Flush()
+}
+
+func empty(topic, channel string) {
+	s := service()
+	exit(s, s.EmptyChannel(topic, channel))
+}
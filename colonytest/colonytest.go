@@ -0,0 +1,151 @@
+// Package colonytest provides a fake colony Service for unit tests of colony
+// services, so they can run without NSQ.
+//
+// The fake is a real colony Service running over an in-memory transport that
+// records everything the service emits. Tests inject messages from a peer
+// service sharing the transport, and assert on what was emitted in response.
+package colonytest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nytlabs/colony"
+)
+
+// PeerName is the name of the peer service messages are injected from.
+const PeerName = "colonytest"
+
+// Service is a colony Service whose traffic stays in process. It satisfies
+// colony.Bus.
+type Service struct {
+	*colony.Service
+
+	// Peer is a second service on the same transport. Messages to inject
+	// should be built with Peer.NewMessage or Peer.NewResponse.
+	Peer *colony.Service
+	// Timeout is how long ExpectEmitted waits for a message.
+	Timeout time.Duration
+
+	rec *recorder
+}
+
+var _ colony.Bus = (*Service)(nil)
+
+// recorder is a MemoryTransport that keeps a copy of every Message published
+// by the service under test.
+type recorder struct {
+	*colony.MemoryTransport
+	mu      sync.Mutex
+	emitted []colony.Message
+	claimed []bool
+	changed chan struct{}
+}
+
+func (r *recorder) Publish(topic string, body []byte) error {
+	m, err := colony.UnmarshalMessage(body)
+	if topic != "colony-announce" && err == nil && m.FromName != PeerName {
+		// body, which a zero-copy payload is part of, isn't ours to keep
+		m.Payload = append([]byte(nil), m.Payload...)
+		r.mu.Lock()
+		r.emitted = append(r.emitted, m)
+		r.claimed = append(r.claimed, false)
+		close(r.changed)
+		r.changed = make(chan struct{})
+		r.mu.Unlock()
+	}
+	return r.MemoryTransport.Publish(topic, body)
+}
+
+// NewService returns a fake service called name with the given id. opts are
+// passed on to colony.NewService.
+func NewService(name, id string, opts ...colony.Option) *Service {
+	rec := &recorder{
+		MemoryTransport: colony.NewMemoryTransport(),
+		changed:         make(chan struct{}),
+	}
+	opts = append(opts, colony.WithTransport(rec), colony.WithChunkSize(0))
+	return &Service{
+		Service: colony.NewService(name, id, "", opts...),
+		Peer:    colony.NewService(PeerName, "peer", "", colony.WithTransport(rec)),
+		Timeout: time.Second,
+		rec:     rec,
+	}
+}
+
+// InjectMessage delivers m to the service as if another service had emitted
+// it: to its consumers of m's content type, or to the handler of the request
+// m responds to.
+func (s *Service) InjectMessage(m colony.Message) error {
+	if err := s.Peer.Announce(m.ContentType); err != nil {
+		return err
+	}
+	return s.Peer.Emit(m)
+}
+
+// Inject builds a Message of contentType from the peer service, injects it,
+// and returns it.
+func (s *Service) Inject(contentType string, payload []byte) (colony.Message, error) {
+	m := s.Peer.NewMessage(contentType, payload)
+	return m, s.InjectMessage(m)
+}
+
+// Emitted returns every Message the service has emitted or requested so far.
+func (s *Service) Emitted() []colony.Message {
+	s.rec.mu.Lock()
+	defer s.rec.mu.Unlock()
+	return append([]colony.Message(nil), s.rec.emitted...)
+}
+
+// ExpectEmitted waits for the service to emit a Message of contentType and
+// returns it, failing t if none arrives within the service's Timeout. Each
+// emitted Message satisfies only one expectation.
+func (s *Service) ExpectEmitted(t testing.TB, contentType string) colony.Message {
+	t.Helper()
+	deadline := time.After(s.Timeout)
+	for {
+		s.rec.mu.Lock()
+		for i, m := range s.rec.emitted {
+			if !s.rec.claimed[i] && m.ContentType == contentType {
+				s.rec.claimed[i] = true
+				s.rec.mu.Unlock()
+				return m
+			}
+		}
+		changed := s.rec.changed
+		s.rec.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline:
+			t.Fatalf("colonytest: %s emitted no %s message within %s", s.Name, contentType, s.Timeout)
+			return colony.Message{}
+		}
+	}
+}
+
+// ExpectNothingEmitted fails t if the service emits a Message of contentType
+// within the service's Timeout.
+func (s *Service) ExpectNothingEmitted(t testing.TB, contentType string) {
+	t.Helper()
+	deadline := time.After(s.Timeout)
+	for {
+		s.rec.mu.Lock()
+		for i, m := range s.rec.emitted {
+			if !s.rec.claimed[i] && m.ContentType == contentType {
+				s.rec.mu.Unlock()
+				t.Fatalf("colonytest: %s emitted an unexpected %s message", s.Name, contentType)
+				return
+			}
+		}
+		changed := s.rec.changed
+		s.rec.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package colonytest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nsqio/nsq/nsqd"
+	"github.com/nsqio/nsq/nsqlookupd"
+)
+
+// NSQ is an nsqd and nsqlookupd running in process on random local ports, for
+// integration tests of colony services.
+type NSQ struct {
+	LookupdHTTPAddr string // pass this to colony.NewService
+	NSQDTCPAddr     string
+	NSQDHTTPAddr    string
+
+	lookupd  *nsqlookupd.NSQLookupd
+	nsqd     *nsqd.NSQD
+	dataPath string
+}
+
+// StartNSQ starts an nsqlookupd and an nsqd registered with it, and waits
+// until lookupd knows about the nsqd. Both are stopped when the test ends.
+//
+//	s := colony.NewService("anteater", "1", colonytest.StartNSQ(t).LookupdHTTPAddr)
+func StartNSQ(t testing.TB) *NSQ {
+	t.Helper()
+	n := &NSQ{}
+	t.Cleanup(n.Stop)
+
+	lopts := nsqlookupd.NewOptions()
+	lopts.TCPAddress = "127.0.0.1:0"
+	lopts.HTTPAddress = "127.0.0.1:0"
+	lookupd, err := nsqlookupd.New(lopts)
+	if err != nil {
+		t.Fatalf("colonytest: starting nsqlookupd: %s", err)
+	}
+	n.lookupd = lookupd
+	go lookupd.Main()
+	n.LookupdHTTPAddr = lookupd.RealHTTPAddr().String()
+
+	n.dataPath, err = ioutil.TempDir("", "colonytest-nsqd")
+	if err != nil {
+		t.Fatalf("colonytest: %s", err)
+	}
+	opts := nsqd.NewOptions()
+	opts.TCPAddress = "127.0.0.1:0"
+	opts.HTTPAddress = "127.0.0.1:0"
+	opts.HTTPSAddress = "127.0.0.1:0"
+	opts.BroadcastAddress = "127.0.0.1"
+	opts.NSQLookupdTCPAddresses = []string{lookupd.RealTCPAddr().String()}
+	opts.DataPath = n.dataPath
+	d, err := nsqd.New(opts)
+	if err != nil {
+		t.Fatalf("colonytest: starting nsqd: %s", err)
+	}
+	n.nsqd = d
+	go d.Main()
+	n.NSQDTCPAddr = d.RealTCPAddr().String()
+	n.NSQDHTTPAddr = d.RealHTTPAddr().String()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !n.registered() {
+		if time.Now().After(deadline) {
+			t.Fatalf("colonytest: nsqd did not register with nsqlookupd")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return n
+}
+
+// registered reports whether lookupd lists any nsqd nodes yet.
+func (n *NSQ) registered() bool {
+	resp, err := http.Get("http://" + n.LookupdHTTPAddr + "/nodes")
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false
+	}
+	// lookupd answers in either its current or its older, wrapped format
+	var nodes struct {
+		Producers []json.RawMessage
+		Data      struct {
+			Producers []json.RawMessage
+		}
+	}
+	if json.Unmarshal(body, &nodes) != nil {
+		return false
+	}
+	return len(nodes.Producers)+len(nodes.Data.Producers) > 0
+}
+
+// Stop shuts down nsqd and nsqlookupd and removes nsqd's data. It is called
+// automatically when the test that started them ends.
+func (n *NSQ) Stop() {
+	if n.nsqd != nil {
+		n.nsqd.Exit()
+		n.nsqd = nil
+	}
+	if n.lookupd != nil {
+		n.lookupd.Exit()
+		n.lookupd = nil
+	}
+	if n.dataPath != "" {
+		os.RemoveAll(n.dataPath)
+		n.dataPath = ""
+	}
+}
@@ -0,0 +1,66 @@
+package colony
+
+import "sync"
+
+// WithConcurrentHandlers has the transport call the handlers of the service's
+// consumers and of its responses from n goroutines each, so that Messages are
+// unmarshalled, verified and reassembled n at a time rather than one by one.
+// Responses are still handed over in the order they reached the service, so
+// that the responses to a request keep their order, as are the Messages of
+// consumers given a Concurrency of 1 with ConsumeWith. It takes a
+// ConcurrentTransport; others call each handler from one goroutine.
+func WithConcurrentHandlers(n int) Option {
+	return func(s *Service) {
+		s.handlerConcurrency = n
+	}
+}
+
+// subscribeConcurrent is subscribe with h called from n goroutines, if the
+// transport can.
+func (s Service) subscribeConcurrent(topic, channel string, n int, h func(body []byte) error) (Subscription, error) {
+	if ct, ok := s.transport.(ConcurrentTransport); ok && n > 1 {
+		return s.track(ct.SubscribeConcurrent(topic, channel, n, s.chaotic(topic, h)))
+	}
+	return s.subscribe(topic, channel, h)
+}
+
+// A sequencer lets handlers called at once do their work at once, but finish
+// in the order they were called.
+type sequencer struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	next uint64 // the ticket handed to the next caller
+	turn uint64 // the ticket of the caller allowed to finish
+}
+
+func newSequencer() *sequencer {
+	q := new(sequencer)
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// ticket returns the caller's place in line.
+func (q *sequencer) ticket() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	t := q.next
+	q.next++
+	return t
+}
+
+// wait blocks until it is the turn of ticket t. The caller must then call done.
+func (q *sequencer) wait(t uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.turn != t {
+		q.cond.Wait()
+	}
+}
+
+// done passes the turn on to the next ticket.
+func (q *sequencer) done() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.turn++
+	q.cond.Broadcast()
+}
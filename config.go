@@ -0,0 +1,176 @@
+package colony
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+	"github.com/bitly/go-nsq"
+	"gopkg.in/yaml.v2"
+)
+
+// A Config describes a service and the colony it joins, so that it can be
+// set up from a file with LoadConfig and NewServiceFromConfig.
+type Config struct {
+	Name       string                      `yaml:"name" toml:"name"`
+	ID         string                      `yaml:"id" toml:"id"`           // the host name if empty
+	Lookupd    []string                    `yaml:"lookupd" toml:"lookupd"` // lookupd HTTP addresses, tried in order
+	Namespace  string                      `yaml:"namespace" toml:"namespace"`
+	Datacenter string                      `yaml:"datacenter" toml:"datacenter"`
+	TLS        TLSConfig                   `yaml:"tls" toml:"tls"`
+	Consumers  map[string]ConsumerSettings `yaml:"consumers" toml:"consumers"` // the service's first RuntimeConfig
+	Metrics    MetricsConfig               `yaml:"metrics" toml:"metrics"`
+	Log        LogConfig                   `yaml:"log" toml:"log"`
+}
+
+// TLSConfig names the PEM files of a service's Identity.
+type TLSConfig struct {
+	Cert string `yaml:"cert" toml:"cert"`
+	Key  string `yaml:"key" toml:"key"`
+	CA   string `yaml:"ca" toml:"ca"` // if set, the only CAs trusted to sign peers' certificates
+}
+
+// MetricsConfig says where a service reports on itself.
+type MetricsConfig struct {
+	Addr  string `yaml:"addr" toml:"addr"`   // if set, where to serve an Admin for the service, /stats included
+	Debug string `yaml:"debug" toml:"debug"` // if set, where to serve the service's DebugHandler, as with WithDebug
+}
+
+// LogConfig says where a service logs to.
+type LogConfig struct {
+	File string `yaml:"file" toml:"file"` // if set, the file the process logs to instead of stderr
+}
+
+// LoadConfig reads a Config from path, in YAML if its name ends in .yaml or
+// .yml, TOML if it ends in .toml, and JSON otherwise.
+func LoadConfig(path string) (*Config, error) {
+	c := new(Config)
+	if err := decodeConfigFile(path, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func decodeConfigFile(path string, v interface{}) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		return yaml.Unmarshal(b, v)
+	case ".toml":
+		return toml.Unmarshal(b, v)
+	default:
+		return json.Unmarshal(b, v)
+	}
+}
+
+// NewServiceFromConfig returns a service set up as c describes. Options are
+// applied after those c gives, so they can override them.
+func NewServiceFromConfig(c *Config, opts ...Option) (*Service, error) {
+	if c.Name == "" {
+		return nil, errors.New("config has no service name")
+	}
+	id := c.ID
+	if id == "" {
+		var err error
+		if id, err = os.Hostname(); err != nil {
+			return nil, err
+		}
+	}
+	if c.Log.File != "" {
+		f, err := os.OpenFile(c.Log.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		log.SetOutput(f)
+	}
+
+	var configured []Option
+	if c.TLS.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLS.Cert, c.TLS.Key)
+		if err != nil {
+			return nil, err
+		}
+		configured = append(configured, WithIdentity(cert))
+	}
+	if c.TLS.CA != "" {
+		pem, err := ioutil.ReadFile(c.TLS.CA)
+		if err != nil {
+			return nil, err
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates in " + c.TLS.CA)
+		}
+		configured = append(configured, WithIdentityRoots(roots))
+	}
+	if c.Namespace != "" {
+		configured = append(configured, WithNamespace(c.Namespace))
+	}
+	if c.Metrics.Debug != "" {
+		configured = append(configured, WithDebug(c.Metrics.Debug))
+	}
+	if c.Datacenter != "" {
+		configured = append(configured, WithDatacenter(c.Datacenter))
+	}
+	if len(c.Lookupd) > 0 {
+		// the caller's options too, as they may configure the transport
+		t, err := dialLookupd(c.Lookupd, append(configured[:len(configured):len(configured)], opts...))
+		if err != nil {
+			return nil, err
+		}
+		configured = append(configured, WithTransport(t))
+	}
+
+	s := NewService(c.Name, id, "", append(configured, opts...)...)
+	if len(c.Consumers) > 0 {
+		s.Reconfigure(RuntimeConfig{Consumers: c.Consumers})
+	}
+	if c.Metrics.Addr != "" {
+		ln, err := net.Listen("tcp", c.Metrics.Addr)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.lifecycle.atClose(func() { ln.Close() })
+		go http.Serve(ln, NewAdmin(s))
+	}
+	return s, nil
+}
+
+// dialLookupd returns an NSQTransport for the first of addrs that answers,
+// configured for a service with opts.
+func dialLookupd(addrs []string, opts []Option) (*NSQTransport, error) {
+	var s Service
+	for _, opt := range opts {
+		opt(&s)
+	}
+	conf := s.nsqConfig()
+	if err := conf.Set("lookupd_poll_interval", "5s"); err != nil {
+		return nil, err
+	}
+	return s.dialFirst(addrs, conf)
+}
+
+// dialFirst returns an NSQTransport for the first of addrs that answers.
+func (s Service) dialFirst(addrs []string, conf *nsq.Config) (*NSQTransport, error) {
+	err := errors.New("no lookupd addresses")
+	for _, addr := range addrs {
+		var t *NSQTransport
+		if t, err = s.dialNSQ(addr, conf); err == nil {
+			return t, nil
+		}
+		log.Println("COLONY\t could not use lookupd", addr+":", err)
+	}
+	return nil, err
+}
@@ -0,0 +1,92 @@
+// Package consuldiscovery provides a colony Discovery backed by Consul, for
+// colonies whose lookupds are registered as Consul services.
+//
+// Lookupds are found as the healthy instances of a Consul service, which must
+// be registered with their HTTP port. ServiceDescriptors are kept as JSON in
+// the KV store, one key per service.
+package consuldiscovery
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/nytlabs/colony"
+)
+
+// Discovery is a colony Discovery over Consul.
+type Discovery struct {
+	client *api.Client
+
+	// LookupdService is the name lookupds are registered under.
+	LookupdService string
+	// Prefix is prepended to the KV key of every ServiceDescriptor, which
+	// is otherwise the service's name and ID.
+	Prefix string
+}
+
+// New returns a Discovery using client, finding lookupds registered as
+// nsqlookupd and keeping descriptors under colony/services/.
+func New(client *api.Client) *Discovery {
+	return &Discovery{
+		client:         client,
+		LookupdService: "nsqlookupd",
+		Prefix:         "colony/services/",
+	}
+}
+
+// Lookupds returns the address of every instance of LookupdService passing
+// its health checks.
+func (d *Discovery) Lookupds() ([]string, error) {
+	entries, _, err := d.client.Health().Service(d.LookupdService, "", true, nil)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(entries))
+	for _, e := range entries {
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(e.Service.Port)))
+	}
+	return addrs, nil
+}
+
+// Register writes desc to the service's key.
+func (d *Discovery) Register(desc colony.ServiceDescriptor) error {
+	b, err := json.Marshal(desc)
+	if err != nil {
+		return err
+	}
+	_, err = d.client.KV().Put(&api.KVPair{Key: d.key(desc.Name, desc.ID), Value: b}, nil)
+	return err
+}
+
+// Deregister deletes the key of the service with name and id.
+func (d *Discovery) Deregister(name, id string) error {
+	_, err := d.client.KV().Delete(d.key(name, id), nil)
+	return err
+}
+
+// Services returns every ServiceDescriptor registered, in order of key.
+func (d *Discovery) Services() ([]colony.ServiceDescriptor, error) {
+	pairs, _, err := d.client.KV().List(d.Prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	descs := make([]colony.ServiceDescriptor, 0, len(pairs))
+	for _, p := range pairs {
+		var desc colony.ServiceDescriptor
+		if err := json.Unmarshal(p.Value, &desc); err != nil {
+			continue
+		}
+		descs = append(descs, desc)
+	}
+	return descs, nil
+}
+
+func (d *Discovery) key(name, id string) string {
+	return d.Prefix + name + "/" + id
+}
@@ -0,0 +1,184 @@
+// Package contracts checks that the payloads colony services produce can be
+// handled by the services consuming them, when the two live in different
+// repositories.
+//
+// A producing service writes a Contract with example payloads of each content
+// type it produces, typically from one of its tests, and publishes the file
+// where consumers can get at it, such as a shared repository. A consuming
+// service declares an Expectation for each content type it consumes and, in
+// its own tests, has Verify check every example against it. A producer change
+// that drops a field a consumer needs, or changes its type, then fails the
+// consumer's tests before either is deployed.
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/nytlabs/colony"
+)
+
+// A Contract is what a producing service promises about its payloads.
+type Contract struct {
+	Service  string
+	Examples map[string][]Example // by content type
+}
+
+// An Example is a payload a service might produce.
+type Example struct {
+	Name    string
+	Format  *colony.Format `json:",omitempty"`
+	Payload json.RawMessage
+}
+
+// Add records v, encoded as JSON, as the example called name of contentType.
+func (c *Contract) Add(contentType, name string, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if c.Examples == nil {
+		c.Examples = make(map[string][]Example)
+	}
+	c.Examples[contentType] = append(c.Examples[contentType], Example{Name: name, Payload: payload})
+	return nil
+}
+
+// WriteFile writes c to path as JSON.
+func (c Contract) WriteFile(path string) error {
+	b, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// ReadFile reads a Contract written by WriteFile.
+func ReadFile(path string) (Contract, error) {
+	var c Contract
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// ReadDir reads every Contract in the .json files of dir, in order of name.
+func ReadDir(dir string) ([]Contract, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	cs := make([]Contract, 0, len(paths))
+	for _, path := range paths {
+		c, err := ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
+
+// An Expectation is what a consuming service needs of the payloads of a
+// content type.
+type Expectation struct {
+	ContentType string
+	// Type is a value of the type the consumer decodes payloads into,
+	// such as a zero struct. Every example must decode into it.
+	Type interface{}
+	// Required lists the fields every example must have, as JSON object
+	// keys separated by dots, such as "snake.length".
+	Required []string
+	// Validate, if set, is called with a pointer to each example decoded
+	// into Type, to check whatever else the consumer relies on.
+	Validate func(v interface{}) error
+}
+
+// Check returns the reasons the examples of e's content type in c don't meet
+// e, or nil if they all do.
+func (e Expectation) Check(c Contract) []error {
+	var errs []error
+	for _, ex := range c.Examples[e.ContentType] {
+		if err := e.check(ex); err != nil {
+			errs = append(errs, fmt.Errorf("%s %s example %q: %v", c.Service, e.ContentType, ex.Name, err))
+		}
+	}
+	return errs
+}
+
+func (e Expectation) check(ex Example) error {
+	var doc interface{}
+	if err := json.Unmarshal(ex.Payload, &doc); err != nil {
+		return err
+	}
+	for _, path := range e.Required {
+		if !has(doc, strings.Split(path, ".")) {
+			return fmt.Errorf("missing %s", path)
+		}
+	}
+	if e.Type == nil {
+		return nil
+	}
+	v := reflect.New(reflect.TypeOf(e.Type)).Interface()
+	if err := json.Unmarshal(ex.Payload, v); err != nil {
+		return err
+	}
+	if e.Validate != nil {
+		return e.Validate(v)
+	}
+	return nil
+}
+
+// has reports whether doc has a non-null value at path.
+func has(doc interface{}, path []string) bool {
+	for _, key := range path {
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if doc, ok = obj[key]; !ok {
+			return false
+		}
+	}
+	return doc != nil
+}
+
+// Verify fails t for every example in contracts that doesn't meet the
+// expectation for its content type, and for every expectation no contract
+// has an example for, since then nothing is checking it.
+func Verify(t testing.TB, contracts []Contract, expectations ...Expectation) {
+	t.Helper()
+	for _, e := range expectations {
+		examples := 0
+		for _, c := range contracts {
+			examples += len(c.Examples[e.ContentType])
+			for _, err := range e.Check(c) {
+				t.Error("contracts:", err)
+			}
+		}
+		if examples == 0 {
+			t.Errorf("contracts: no examples of %s to check", e.ContentType)
+		}
+	}
+}
+
+// VerifyDir is Verify with the contracts in dir, as read by ReadDir.
+func VerifyDir(t testing.TB, dir string, expectations ...Expectation) {
+	t.Helper()
+	contracts, err := ReadDir(dir)
+	if err != nil {
+		t.Fatal("contracts:", err)
+	}
+	Verify(t, contracts, expectations...)
+}
@@ -0,0 +1,201 @@
+package colony
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A ControlTransport is a Transport that operators can steer, such as to stop
+// a misbehaving content type from being delivered while it is looked into.
+// NSQTransport and MemoryTransport are ControlTransports.
+type ControlTransport interface {
+	Transport
+	// PauseTopic holds back the messages of topic from its channels until
+	// UnpauseTopic is called. They are still accepted meanwhile.
+	PauseTopic(topic string) error
+	UnpauseTopic(topic string) error
+	// DeleteTopic deletes topic and its channels, with any messages in
+	// them.
+	DeleteTopic(topic string) error
+	// DeleteChannel deletes a channel of topic, with any messages in it.
+	DeleteChannel(topic, channel string) error
+	// EmptyTopic throws away the messages waiting in topic for it to have
+	// a channel.
+	EmptyTopic(topic string) error
+	// EmptyChannel throws away the messages waiting in a channel of topic.
+	// Messages in flight are left to finish.
+	EmptyChannel(topic, channel string) error
+}
+
+// errNoControl is returned by a service whose transport can't be steered.
+var errNoControl = errors.New("transport can't control topics")
+
+// ErrInUse is returned when deleting a topic or channel that still has
+// messages waiting in it or consumers connected, or a topic the service
+// still produces.
+var ErrInUse = errors.New("still in use")
+
+// PauseTopic holds back the messages of topic, as named in the service's
+// transport, from every consumer until UnpauseTopic is called. It takes a
+// ControlTransport.
+func (s Service) PauseTopic(topic string) error {
+	return s.eachTopic([]string{topic}, ControlTransport.PauseTopic)
+}
+
+// UnpauseTopic delivers the messages of a paused topic again.
+func (s Service) UnpauseTopic(topic string) error {
+	return s.eachTopic([]string{topic}, ControlTransport.UnpauseTopic)
+}
+
+// PauseContentType pauses every topic of contentType, from every producer
+// known to the transport. A versioned contentType can have a constraint, as
+// with Consume.
+func (s Service) PauseContentType(contentType string) error {
+	topics, err := s.contentTypeTopics(contentType)
+	if err != nil {
+		return err
+	}
+	return s.eachTopic(topics, ControlTransport.PauseTopic)
+}
+
+// UnpauseContentType unpauses every topic of contentType.
+func (s Service) UnpauseContentType(contentType string) error {
+	topics, err := s.contentTypeTopics(contentType)
+	if err != nil {
+		return err
+	}
+	return s.eachTopic(topics, ControlTransport.UnpauseTopic)
+}
+
+// DeleteTopic deletes every topic of contentType, from every producer, with
+// their partitions, priority lanes and channels, so that content types no
+// longer produced can be retired. To be safe it deletes nothing and returns
+// ErrInUse if the service produces contentType, or if any of the topics has
+// messages waiting or consumers connected; their messages can be thrown away
+// first with EmptyTopic and EmptyChannel. It takes a ControlTransport that is
+// also a StatsTransport.
+func (s Service) DeleteTopic(contentType string) error {
+	s.describer.mu.Lock()
+	produced := s.describer.produces[contentType]
+	s.describer.mu.Unlock()
+	if produced {
+		return fmt.Errorf("%s: %w by %s-%s", contentType, ErrInUse, s.Name, s.ID)
+	}
+	topics, err := s.contentTypeTopics(contentType)
+	if err != nil {
+		return err
+	}
+	if err := s.checkUnused(topics, ""); err != nil {
+		return err
+	}
+	return s.eachTopic(topics, ControlTransport.DeleteTopic)
+}
+
+// DeleteChannel deletes channel of topic, as named in the service's
+// transport. Like DeleteTopic it returns ErrInUse rather than delete a
+// channel with messages waiting or consumers connected.
+func (s Service) DeleteChannel(topic, channel string) error {
+	if err := s.checkUnused([]string{topic}, channel); err != nil {
+		return err
+	}
+	return s.eachTopic([]string{topic}, func(ct ControlTransport, topic string) error {
+		return ct.DeleteChannel(topic, channel)
+	})
+}
+
+// EmptyTopic throws away the messages waiting in topic, as named in the
+// service's transport, for it to have a channel.
+func (s Service) EmptyTopic(topic string) error {
+	return s.eachTopic([]string{topic}, ControlTransport.EmptyTopic)
+}
+
+// EmptyChannel throws away the messages waiting in channel of topic, as named
+// in the service's transport, such as to unstick consumers that fail on every
+// one of them. Messages in flight are left to finish.
+func (s Service) EmptyChannel(topic, channel string) error {
+	return s.eachTopic([]string{topic}, func(ct ControlTransport, topic string) error {
+		return ct.EmptyChannel(topic, channel)
+	})
+}
+
+// Channels returns the state of each channel of topic, as named in the
+// service's transport, in order of name. It takes a StatsTransport.
+func (s Service) Channels(topic string) ([]ChannelStats, error) {
+	st, ok := s.transport.(StatsTransport)
+	if !ok {
+		return nil, errors.New("transport does not report stats")
+	}
+	stats, err := st.TopicStats()
+	if err != nil {
+		return nil, err
+	}
+	for _, ts := range stats {
+		if ts.Name == topic {
+			return ts.Channels, nil
+		}
+	}
+	return nil, fmt.Errorf("no topic %s", topic)
+}
+
+// checkUnused returns ErrInUse if any of topics, or just their channel if
+// channel isn't empty, has messages waiting or consumers connected.
+func (s Service) checkUnused(topics []string, channel string) error {
+	st, ok := s.transport.(StatsTransport)
+	if !ok {
+		return errors.New("transport can't tell whether topics are in use")
+	}
+	stats, err := st.TopicStats()
+	if err != nil {
+		return err
+	}
+	for _, ts := range stats {
+		if !contains(topics, ts.Name) {
+			continue
+		}
+		if channel == "" && ts.Depth > 0 {
+			return fmt.Errorf("%s: %w: %d messages waiting", ts.Name, ErrInUse, ts.Depth)
+		}
+		for _, cs := range ts.Channels {
+			if channel != "" && cs.Name != channel {
+				continue
+			}
+			if cs.Depth > 0 || cs.InFlight > 0 || cs.Deferred > 0 {
+				return fmt.Errorf("%s/%s: %w: %d messages waiting", ts.Name, cs.Name, ErrInUse, cs.Depth+cs.InFlight+cs.Deferred)
+			}
+			if cs.Clients > 0 {
+				return fmt.Errorf("%s/%s: %w: %d consumers connected", ts.Name, cs.Name, ErrInUse, cs.Clients)
+			}
+		}
+	}
+	return nil
+}
+
+// contentTypeTopics returns the topics of contentType, with their partitions
+// and priority lanes, known to the transport.
+func (s Service) contentTypeTopics(contentType string) ([]string, error) {
+	topics, err := s.transport.Discover()
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, topic := range topics {
+		if info, ok := ParseTopic(topic); ok && matchContentType(contentType, info.ContentType) {
+			out = append(out, topic)
+		}
+	}
+	return out, nil
+}
+
+// eachTopic applies f to each of topics through the service's transport.
+func (s Service) eachTopic(topics []string, f func(ControlTransport, string) error) error {
+	ct, ok := s.transport.(ControlTransport)
+	if !ok {
+		return errNoControl
+	}
+	for _, topic := range topics {
+		if err := f(ct, topic); err != nil {
+			return err
+		}
+	}
+	return nil
+}
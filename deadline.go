@@ -0,0 +1,24 @@
+package colony
+
+import (
+	"context"
+	"time"
+)
+
+// WithDeadline sets the time after which nobody is waiting for m to be
+// handled. Call sets it from its context's deadline if m has none, so that
+// the services handling m, and their own requests, can give up in time.
+func (m *Message) WithDeadline(deadline time.Time) {
+	m.Deadline = &deadline
+}
+
+// Context returns a context derived from parent that is done at m's Deadline,
+// for a handler to do its work under, and to pass to Call so that the
+// deadline carries on to the requests it makes in turn. Without a Deadline
+// the context is done only when parent is, or when cancel is called.
+func (m Message) Context(parent context.Context) (ctx context.Context, cancel context.CancelFunc) {
+	if m.Deadline == nil {
+		return context.WithCancel(parent)
+	}
+	return context.WithDeadline(parent, *m.Deadline)
+}
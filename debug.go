@@ -0,0 +1,159 @@
+package colony
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"sync"
+	"time"
+)
+
+// WithDebug has the service serve its DebugHandler at addr until it is
+// closed, so that a service that hangs in production can be looked into.
+func WithDebug(addr string) Option {
+	return func(s *Service) {
+		s.debugAddr = addr
+	}
+}
+
+// serveDebug serves the service's DebugHandler at addr until it is closed.
+func (s *Service) serveDebug(addr string) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Println("COLONY\t", s.Name, "could not serve debug listener:", err)
+		return
+	}
+	if !s.lifecycle.atClose(func() { ln.Close() }) {
+		ln.Close()
+		return
+	}
+	log.Println("COLONY\t", s.Name, "is serving debug listener on", ln.Addr())
+	http.Serve(ln, s.DebugHandler())
+}
+
+// DebugHandler returns an http.Handler serving what is needed to diagnose a
+// service live: the runtime's profiles under /debug/pprof/, the service's
+// descriptor, counters and topic stats at /debug/stats, and the response
+// Handlers it is running at /debug/handlers, with any a response is blocked
+// on. It can be mounted in a service's own HTTP server instead of using
+// WithDebug.
+func (s *Service) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/stats", s.serveDebugStats)
+	mux.HandleFunc("/debug/handlers", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, s.Handlers())
+	})
+	return mux
+}
+
+type debugStats struct {
+	Service ServiceDescriptor
+	Expired int64        // expired Messages the service has dropped
+	Topics  []TopicStats `json:",omitempty"` // if the transport reports stats
+	Error   string       `json:",omitempty"` // why Topics is missing, if it is
+}
+
+func (s *Service) serveDebugStats(w http.ResponseWriter, r *http.Request) {
+	out := debugStats{
+		Service: s.Describe(),
+		Expired: s.ExpiredCount(),
+	}
+	if st, ok := s.transport.(StatsTransport); ok {
+		var err error
+		if out.Topics, err = st.TopicStats(); err != nil {
+			out.Error = err.Error()
+		}
+	}
+	writeJSON(w, out)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// HandlerInfo describes a response Handler the service is running.
+type HandlerInfo struct {
+	MessageID string    // the request it receives responses to
+	Started   time.Time // when the request was sent
+	Delivered int       // responses handed to it so far
+	// Blocked is when a response started waiting for the Handler to take
+	// it, if one is waiting. Until the Handler takes it, or returns, no
+	// response to any request is delivered.
+	Blocked *time.Time `json:",omitempty"`
+}
+
+// Handlers returns the response Handlers the service is running, in the order
+// they were started.
+func (s Service) Handlers() []HandlerInfo {
+	return s.handlerInfo.list()
+}
+
+// handlerTable keeps a HandlerInfo for each running response Handler, apart
+// from the handlers map, which only the service's start loop may touch and
+// which can't be inspected once that loop is blocked.
+type handlerTable struct {
+	mu sync.Mutex
+	m  map[messageID]*HandlerInfo
+}
+
+func newHandlerTable() *handlerTable {
+	return &handlerTable{m: make(map[messageID]*HandlerInfo)}
+}
+
+func (t *handlerTable) add(id messageID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.m[id] = &HandlerInfo{MessageID: string(id), Started: time.Now()}
+}
+
+func (t *handlerTable) remove(id messageID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.m, id)
+}
+
+// waiting records that a response to id is waiting for its Handler to take
+// it.
+func (t *handlerTable) waiting(id messageID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if h, ok := t.m[id]; ok {
+		now := time.Now()
+		h.Blocked = &now
+	}
+}
+
+// delivered records that a response to id no longer waits, having been taken
+// by its Handler or dropped as the Handler returned.
+func (t *handlerTable) delivered(id messageID, taken bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if h, ok := t.m[id]; ok {
+		h.Blocked = nil
+		if taken {
+			h.Delivered++
+		}
+	}
+}
+
+func (t *handlerTable) list() []HandlerInfo {
+	t.mu.Lock()
+	out := make([]HandlerInfo, 0, len(t.m))
+	for _, h := range t.m {
+		out = append(out, *h)
+	}
+	t.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Started.Before(out[j].Started) })
+	return out
+}
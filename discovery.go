@@ -0,0 +1,63 @@
+package colony
+
+import (
+	"errors"
+	"log"
+
+	"github.com/bitly/go-nsq"
+)
+
+// A Discovery is a service registry run alongside the colony, such as Consul
+// or etcd, that a service finds its lookupds through and keeps its
+// ServiceDescriptor in. The consuldiscovery and etcddiscovery packages
+// provide Discoveries.
+type Discovery interface {
+	// Lookupds returns the HTTP addresses of the colony's lookupds, through
+	// which its nsqds are found.
+	Lookupds() ([]string, error)
+	// Register records desc, replacing what was recorded for the service
+	// before.
+	Register(desc ServiceDescriptor) error
+	// Deregister removes what was recorded for the service with name and
+	// id.
+	Deregister(name, id string) error
+}
+
+// WithDiscovery has the service find its lookupds through d, rather than
+// at the address given to NewService, and record its ServiceDescriptor in d
+// whenever it announces or sends a heartbeat, until it is closed.
+func WithDiscovery(d Discovery) Option {
+	return func(s *Service) {
+		s.discovery = d
+	}
+}
+
+// discoverNSQ returns an NSQTransport for the first lookupd found through the
+// service's Discovery that answers.
+func (s Service) discoverNSQ(conf *nsq.Config) (*NSQTransport, error) {
+	addrs, err := s.discovery.Lookupds()
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("discovery found no lookupds")
+	}
+	return s.dialFirst(addrs, conf)
+}
+
+// register records desc in the service's Discovery, if it has one, or
+// removes the service from it if desc says it is leaving.
+func (s Service) register(desc ServiceDescriptor) {
+	if s.discovery == nil {
+		return
+	}
+	var err error
+	if desc.Leaving {
+		err = s.discovery.Deregister(desc.Name, desc.ID)
+	} else {
+		err = s.discovery.Register(desc)
+	}
+	if err != nil {
+		log.Println("COLONY\t", s.Name, "could not update discovery:", err)
+	}
+}
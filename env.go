@@ -0,0 +1,59 @@
+package colony
+
+import (
+	"os"
+	"strings"
+)
+
+// ConfigFromEnv returns the Config given by the environment. If COLONY_CONFIG
+// names a file the Config is loaded from it first, and these variables, where
+// set, override it:
+//
+//	COLONY_SERVICE_NAME   Name
+//	COLONY_SERVICE_ID     ID
+//	COLONY_LOOKUPD        Lookupd, comma separated
+//	COLONY_NAMESPACE      Namespace
+//	COLONY_DATACENTER     Datacenter
+//	COLONY_TLS_CERT       TLS.Cert
+//	COLONY_TLS_KEY        TLS.Key
+//	COLONY_TLS_CA         TLS.CA
+//	COLONY_METRICS_ADDR   Metrics.Addr
+//	COLONY_LOG_FILE       Log.File
+func ConfigFromEnv() (*Config, error) {
+	c := new(Config)
+	if path := os.Getenv("COLONY_CONFIG"); path != "" {
+		var err error
+		if c, err = LoadConfig(path); err != nil {
+			return nil, err
+		}
+	}
+	for name, field := range map[string]*string{
+		"COLONY_SERVICE_NAME": &c.Name,
+		"COLONY_SERVICE_ID":   &c.ID,
+		"COLONY_NAMESPACE":    &c.Namespace,
+		"COLONY_DATACENTER":   &c.Datacenter,
+		"COLONY_TLS_CERT":     &c.TLS.Cert,
+		"COLONY_TLS_KEY":      &c.TLS.Key,
+		"COLONY_TLS_CA":       &c.TLS.CA,
+		"COLONY_METRICS_ADDR": &c.Metrics.Addr,
+		"COLONY_LOG_FILE":     &c.Log.File,
+	} {
+		if v := os.Getenv(name); v != "" {
+			*field = v
+		}
+	}
+	if v := os.Getenv("COLONY_LOOKUPD"); v != "" {
+		c.Lookupd = strings.Split(v, ",")
+	}
+	return c, nil
+}
+
+// NewServiceFromEnv returns a service set up by the environment, as
+// ConfigFromEnv describes, so that the same build can run anywhere.
+func NewServiceFromEnv(opts ...Option) (*Service, error) {
+	c, err := ConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return NewServiceFromConfig(c, opts...)
+}
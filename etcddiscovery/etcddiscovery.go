@@ -0,0 +1,100 @@
+// Package etcddiscovery provides a colony Discovery backed by etcd.
+//
+// Lookupds are found as the values of the keys under a prefix, each the HTTP
+// address of one lookupd. ServiceDescriptors are kept as JSON, one key per
+// service, leased for TTL if it is set so that those of services that crash
+// expire.
+package etcddiscovery
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/nytlabs/colony"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// Discovery is a colony Discovery over etcd.
+type Discovery struct {
+	client *clientv3.Client
+
+	// LookupdPrefix is the prefix of the keys holding lookupd addresses.
+	LookupdPrefix string
+	// Prefix is prepended to the key of every ServiceDescriptor, which is
+	// otherwise the service's name and ID.
+	Prefix string
+	// TTL is how long a ServiceDescriptor is kept after it was last
+	// registered. 0 keeps it until it is deregistered. It should be longer
+	// than the interval between the service's heartbeats.
+	TTL time.Duration
+}
+
+// New returns a Discovery using client, finding lookupds under
+// /colony/lookupd/ and keeping descriptors under /colony/services/.
+func New(client *clientv3.Client) *Discovery {
+	return &Discovery{
+		client:        client,
+		LookupdPrefix: "/colony/lookupd/",
+		Prefix:        "/colony/services/",
+	}
+}
+
+// Lookupds returns the values of the keys under LookupdPrefix.
+func (d *Discovery) Lookupds() ([]string, error) {
+	resp, err := d.client.Get(context.Background(), d.LookupdPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		addrs = append(addrs, string(kv.Value))
+	}
+	return addrs, nil
+}
+
+// Register writes desc to the service's key, leased for TTL if it is set.
+func (d *Discovery) Register(desc colony.ServiceDescriptor) error {
+	b, err := json.Marshal(desc)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	var opts []clientv3.OpOption
+	if d.TTL > 0 {
+		lease, err := d.client.Grant(ctx, int64((d.TTL+time.Second-1)/time.Second))
+		if err != nil {
+			return err
+		}
+		opts = append(opts, clientv3.WithLease(lease.ID))
+	}
+	_, err = d.client.Put(ctx, d.key(desc.Name, desc.ID), string(b), opts...)
+	return err
+}
+
+// Deregister deletes the key of the service with name and id.
+func (d *Discovery) Deregister(name, id string) error {
+	_, err := d.client.Delete(context.Background(), d.key(name, id))
+	return err
+}
+
+// Services returns every ServiceDescriptor registered, in order of key.
+func (d *Discovery) Services() ([]colony.ServiceDescriptor, error) {
+	resp, err := d.client.Get(context.Background(), d.Prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	descs := make([]colony.ServiceDescriptor, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		var desc colony.ServiceDescriptor
+		if err := json.Unmarshal(kv.Value, &desc); err != nil {
+			continue
+		}
+		descs = append(descs, desc)
+	}
+	return descs, nil
+}
+
+func (d *Discovery) key(name, id string) string {
+	return d.Prefix + name + "/" + id
+}
@@ -0,0 +1,175 @@
+package colony
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrNoAggregate is returned by EventStore.Append for an event without an
+// aggregate ID.
+var ErrNoAggregate = errors.New("event has no aggregate ID")
+
+// An EventLog keeps the events of each aggregate in the order they were
+// appended.
+type EventLog interface {
+	Append(aggregateID string, event Message) error
+	Events(aggregateID string) ([]Message, error)
+}
+
+// An EventStore is a simple event-sourcing store on top of the colony. Each
+// event appended is kept in Log and emitted as a Message of ContentType whose
+// Key is the aggregate's ID, so consumers can partition by aggregate. The
+// state of an aggregate is rebuilt by folding its events with Reduce.
+type EventStore struct {
+	Service     *Service
+	ContentType string
+	Log         EventLog
+	Reduce      Reducer
+
+	announce sync.Once
+}
+
+// Append records payload as the next event of the aggregate with id, and
+// emits it.
+func (es *EventStore) Append(aggregateID string, payload []byte) (Message, error) {
+	if aggregateID == "" {
+		return Message{}, ErrNoAggregate
+	}
+	var err error
+	es.announce.Do(func() {
+		err = es.Service.Announce(es.ContentType)
+	})
+	if err != nil {
+		return Message{}, err
+	}
+	m := es.Service.NewMessage(es.ContentType, payload)
+	m.Key = aggregateID
+	if err := es.Log.Append(aggregateID, m); err != nil {
+		return m, err
+	}
+	return m, es.Service.Emit(m)
+}
+
+// Rebuild returns the state of the aggregate with id, folded from nil through
+// each of its events in turn.
+func (es *EventStore) Rebuild(ctx context.Context, aggregateID string) ([]byte, error) {
+	events, err := es.Log.Events(aggregateID)
+	if err != nil {
+		return nil, err
+	}
+	var state []byte
+	for _, e := range events {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		state = es.Reduce(state, e)
+	}
+	return state, nil
+}
+
+// Follow consumes the events appended to ContentType by other services and
+// keeps them in Log, so that this service can rebuild their aggregates too.
+// Like Consume, it blocks while it runs.
+func (es *EventStore) Follow() error {
+	return es.Service.Consume(es.ContentType, func(c <-chan Message) error {
+		for m := range c {
+			if m.Key == "" {
+				continue
+			}
+			if m.Topic.ServiceName == es.Service.Name && m.Topic.ServiceID == es.Service.ID {
+				// appended here, and so already in Log
+				continue
+			}
+			if err := es.Log.Append(m.Key, m); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MemoryEventLog is an EventLog kept in memory.
+type MemoryEventLog struct {
+	mu     sync.Mutex
+	events map[string][]Message
+}
+
+// Append adds event to the aggregate's events.
+func (l *MemoryEventLog) Append(aggregateID string, event Message) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.events == nil {
+		l.events = make(map[string][]Message)
+	}
+	l.events[aggregateID] = append(l.events[aggregateID], event)
+	return nil
+}
+
+// Events returns the aggregate's events.
+func (l *MemoryEventLog) Events(aggregateID string) ([]Message, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Message(nil), l.events[aggregateID]...), nil
+}
+
+// FileEventLog is an EventLog keeping the events of each aggregate as lines
+// of JSON in a file of its own in Dir.
+type FileEventLog struct {
+	Dir string
+
+	mu sync.Mutex
+}
+
+// Append adds event to the end of the aggregate's file.
+func (l *FileEventLog) Append(aggregateID string, event Message) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.OpenFile(l.path(aggregateID), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Events reads the aggregate's file.
+func (l *FileEventLog) Events(aggregateID string) ([]Message, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.Open(l.path(aggregateID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var events []Message
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<30)
+	for scanner.Scan() {
+		var m Message
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			return events, err
+		}
+		events = append(events, m)
+	}
+	return events, scanner.Err()
+}
+
+func (l *FileEventLog) path(aggregateID string) string {
+	return filepath.Join(l.Dir, url.PathEscape(aggregateID)+".jsonl")
+}
@@ -0,0 +1,70 @@
+package colony
+
+import (
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// WithTTL makes every Message of contentType the service creates with
+// NewMessage expire ttl after it is created.
+func WithTTL(contentType string, ttl time.Duration) Option {
+	return func(s *Service) {
+		if s.ttls == nil {
+			s.ttls = make(map[string]time.Duration)
+		}
+		s.ttls[contentType] = ttl
+	}
+}
+
+// ExpireAfter sets m to expire d from now. Consumers drop expired Messages
+// instead of handling them.
+func (m *Message) ExpireAfter(d time.Duration) {
+	expiry := time.Now().Add(d)
+	m.Expiry = &expiry
+}
+
+// Expired reports whether m has an Expiry or a Deadline that has passed, so
+// that handling it would be wasted.
+func (m Message) Expired() bool {
+	now := time.Now()
+	return m.Expiry != nil && now.After(*m.Expiry) || m.Deadline != nil && now.After(*m.Deadline)
+}
+
+// ExpiredCount returns how many expired Messages the service has dropped.
+func (s Service) ExpiredCount() int64 {
+	return atomic.LoadInt64(s.expired)
+}
+
+// errExpired is why expired Messages are dead-lettered.
+var errExpired = errors.New("message expired")
+
+// WithDeadLetterExpired has the service send the expired Messages it consumes
+// to the dead-letter topic of their content type, as with Retry, rather than
+// only dropping them, so that what was lost to a timeout storm can be looked
+// into or replayed.
+func WithDeadLetterExpired() Option {
+	return func(s *Service) {
+		s.deadLetterExpired = true
+	}
+}
+
+// dropExpired reports whether m, which a consumer received and accepted as
+// body, has expired, counting it and sending body to the dead-letter topic if
+// the service does that.
+func (s Service) dropExpired(m Message, body []byte) bool {
+	if !m.Expired() {
+		return false
+	}
+	atomic.AddInt64(s.expired, 1)
+	s.count("colony.expired", m.ContentType)
+	if s.deadLetterExpired {
+		s.count("colony.dead_lettered", m.ContentType)
+		s.notify(ServiceEvent{Kind: DeadLettered, ContentType: m.ContentType, MessageID: string(m.MessageID), Err: errExpired})
+		if err := s.transport.Publish(s.retryTopic(m.ContentType, 0), body); err != nil {
+			log.Println("COLONY\t could not dead-letter expired", m.ContentType, "message", m.MessageID+":", err)
+		}
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package colony
+
+import (
+	"log"
+	"sync"
+)
+
+// EmitAll emits payload as a new Message of each of contentTypes. It carries
+// on past failures, and returns the first error.
+func (s *Service) EmitAll(payload []byte, contentTypes ...string) error {
+	var first error
+	for _, contentType := range contentTypes {
+		if err := s.Emit(s.NewMessage(contentType, payload)); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// A Splitter republishes each Message of a content type as the content types
+// its Route function picks for it, such as to send orders to a queue per
+// region. The payload and CorrelationID are kept.
+type Splitter struct {
+	Service     *Service
+	ContentType string
+	// Route returns the content types to republish a Message as. Returning
+	// none drops it.
+	Route func(Message) []string
+}
+
+// Run consumes ContentType and republishes what it receives. Content types are
+// announced the first time they're routed to. Like Consume, it blocks while
+// the Splitter runs.
+func (sp *Splitter) Run() error {
+	var mu sync.Mutex // guards announced, should the handler run as several copies
+	announced := make(map[string]bool)
+	announce := func(contentType string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if announced[contentType] {
+			return nil
+		}
+		if err := sp.Service.Announce(contentType); err != nil {
+			return err
+		}
+		announced[contentType] = true
+		return nil
+	}
+	return sp.Service.Consume(sp.ContentType, func(c <-chan Message) error {
+		for m := range c {
+			for _, contentType := range sp.Route(m) {
+				if err := announce(contentType); err != nil {
+					log.Println("COLONY\t splitter could not announce", contentType+":", err)
+					continue
+				}
+				out := sp.Service.NewMessage(contentType, m.Payload)
+				out.CorrelationID = m.CorrelationID
+				if err := sp.Service.Emit(out); err != nil {
+					log.Println("COLONY\t splitter could not emit", contentType+":", err)
+				}
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,113 @@
+package colony
+
+import "log"
+
+// A Federation relays Messages of some content types both ways between two
+// colonies, such as those of two datacenters or teams, each with its own NSQ
+// cluster. Relayed Messages are emitted afresh by the Federation's service in
+// the other colony, carrying the name of their original sender as Origin, the
+// colonies they have been relayed from as Via, the datacenter they were first
+// emitted in as OriginDC and how many times they've been relayed as Hops. A
+// Message is never relayed into a colony it has already been through, so
+// colonies can be federated in any arrangement without loops. Responses are
+// not relayed.
+type Federation struct {
+	Local      *Service // a service in one colony
+	LocalName  string   // the name of Local's colony, such as its datacenter
+	Remote     *Service // a service in the other colony
+	RemoteName string   // the name of Remote's colony
+
+	ContentTypes []string
+
+	// Filter, if set, decides which Messages are replicated into which
+	// colony. See ReplicateTo.
+	Filter ReplicationFilter
+	// MaxHops, if set, stops Messages that have been relayed that many
+	// times from going any further, to keep echo storms in check.
+	MaxHops int
+}
+
+// A ReplicationFilter reports whether m should be replicated into the colony
+// named to.
+type ReplicationFilter func(m Message, to string) bool
+
+// ReplicateTo returns a ReplicationFilter that replicates Messages into each
+// colony named in rules only when their content type is listed for it.
+// Messages are replicated into colonies without rules regardless.
+func ReplicateTo(rules map[string][]string) ReplicationFilter {
+	return func(m Message, to string) bool {
+		contentTypes, ok := rules[to]
+		if !ok {
+			return true
+		}
+		for _, contentType := range contentTypes {
+			if contentType == m.ContentType {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Run relays Messages until an error stops one of the relays, and returns it.
+func (f *Federation) Run() error {
+	errs := make(chan error, 2*len(f.ContentTypes))
+	for _, contentType := range f.ContentTypes {
+		go func(contentType string) {
+			errs <- f.relay(f.Local, f.Remote, f.LocalName, f.RemoteName, contentType)
+		}(contentType)
+		go func(contentType string) {
+			errs <- f.relay(f.Remote, f.Local, f.RemoteName, f.LocalName, contentType)
+		}(contentType)
+	}
+	return <-errs
+}
+
+// relay emits each Message of contentType consumed by from in colony fromName
+// again from to in colony toName.
+func (f *Federation) relay(from, to *Service, fromName, toName, contentType string) error {
+	if err := to.Announce(contentType); err != nil {
+		return err
+	}
+	return from.Consume(contentType, func(msgs <-chan Message) error {
+		for m := range msgs {
+			if m.relayedFrom(toName) || (f.MaxHops > 0 && m.Hops >= f.MaxHops) {
+				continue
+			}
+			if f.Filter != nil && !f.Filter(m, toName) {
+				continue
+			}
+			payload, err := m.FetchPayload()
+			if err != nil {
+				log.Println("COLONY\t could not relay", contentType, "message", m.MessageID, "to", toName+":", err)
+				continue
+			}
+			out := to.NewMessage(m.ContentType, payload)
+			out.Format = m.Format
+			out.Origin = m.Origin
+			if out.Origin == "" {
+				out.Origin = m.FromName
+			}
+			out.Via = append(append([]string(nil), m.Via...), fromName)
+			out.OriginDC = m.OriginDC
+			if out.OriginDC == "" {
+				out.OriginDC = fromName
+			}
+			out.Hops = m.Hops + 1
+			if err := to.Emit(out); err != nil {
+				log.Println("COLONY\t could not relay", contentType, "message", m.MessageID, "to", toName+":", err)
+			}
+		}
+		return nil
+	})
+}
+
+// relayedFrom reports whether m has been relayed out of the colony named name.
+func (m Message) relayedFrom(name string) bool {
+	for _, via := range m.Via {
+		if via == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package colony
+
+import (
+	"errors"
+	"strconv"
+)
+
+// ErrNoCommonFormat is returned by Negotiate when the consumers of a content
+// type accept none of the Formats the service produces it in.
+var ErrNoCommonFormat = errors.New("no format accepted by every consumer")
+
+// A Format is a payload encoding at a schema version, such as json at version
+// 2. Producers and consumers declare the Formats they support for each content
+// type so that either side can upgrade without the other.
+type Format struct {
+	Encoding string
+	Version  int
+}
+
+// String returns the Format as encoding/version.
+func (f Format) String() string {
+	return f.Encoding + "/" + strconv.Itoa(f.Version)
+}
+
+// WithFormats declares the Formats the service can produce contentType in,
+// most preferred first. They are announced with the service's descriptor.
+func WithFormats(contentType string, formats ...Format) Option {
+	return func(s *Service) {
+		s.describer.formats[contentType] = formats
+	}
+}
+
+// WithAccepts declares the Formats the service can consume contentType in.
+// They are sent to the colony when the service starts consuming contentType,
+// and with its announcements and heartbeats.
+func WithAccepts(contentType string, formats ...Format) Option {
+	return func(s *Service) {
+		s.describer.accepts[contentType] = formats
+	}
+}
+
+// Negotiate picks the Format to produce contentType in: the first of the
+// service's Formats for it that every consumer in its Registry accepts.
+// Consumers that haven't declared Formats for contentType don't take part.
+// Set the Format of Messages encoded with it so consumers know what they have.
+func (s Service) Negotiate(contentType string) (Format, error) {
+	offered := s.Describe().Formats[contentType]
+	if len(offered) == 0 {
+		return Format{}, ErrNoCommonFormat
+	}
+	consumers := s.registry.Consumers(contentType)
+	for _, f := range offered {
+		ok := true
+		for _, c := range consumers {
+			accepts, declared := c.Accepts[contentType]
+			if declared && !containsFormat(accepts, f) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return f, nil
+		}
+	}
+	return Format{}, ErrNoCommonFormat
+}
+
+func containsFormat(list []Format, f Format) bool {
+	for _, l := range list {
+		if l == f {
+			return true
+		}
+	}
+	return false
+}
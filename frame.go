@@ -0,0 +1,153 @@
+package colony
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// A framed body starts with a header saying how the rest of it is encoded, so
+// that the wire format can change without breaking consumers: the two bytes
+// of frameMagic, the frame version, the codec of the Message and the frame's
+// flags. Unframed bodies, JSON or zero-copy, are still understood.
+const (
+	frameMagic   = "\xc0\x1d"
+	frameVersion = 1
+	frameHeader  = len(frameMagic) + 3
+)
+
+// Codecs a framed Message can be encoded with.
+const (
+	codecJSON     = 1 // the JSON envelope
+	codecZeroCopy = 2 // the zero-copy format, without its leading byte
+)
+
+// Frame flags.
+const (
+	flagGzip = 1 << iota // the encoded Message is gzipped
+
+	knownFlags = flagGzip
+)
+
+var (
+	// ErrNotColony is returned for a transport message that wasn't sent by
+	// a colony service.
+	ErrNotColony = errors.New("not a colony message")
+	// ErrUnsupportedFrame is returned for a Message framed by a newer
+	// version of colony than this one.
+	ErrUnsupportedFrame = errors.New("unsupported colony frame")
+	// ErrMalformed is returned for a body that looks like a colony Message
+	// but can't be decoded as one.
+	ErrMalformed = errors.New("malformed colony message")
+)
+
+// errBadFrame is returned for a framed or zero-copy body that is cut short.
+var errBadFrame = fmt.Errorf("%w: frame cut short", ErrMalformed)
+
+// maxInflated is the most a gzipped frame may decompress to, so that a small
+// body can't exhaust a consumer's memory.
+const maxInflated = 64 << 20
+
+// framing says how a service frames the Messages it sends.
+type framing struct {
+	compress bool
+	minSize  int // the smallest encoded Message worth compressing
+}
+
+// WithFrames makes the service send Messages in a versioned binary frame. Only
+// consumers that can read frames, as services of this version can, should
+// consume from the service.
+func WithFrames() Option {
+	return func(s *Service) {
+		if s.framing == nil {
+			s.framing = &framing{}
+		}
+	}
+}
+
+// WithCompression makes the service send framed Messages, gzipping those that
+// encode to minSize bytes or more.
+func WithCompression(minSize int) Option {
+	return func(s *Service) {
+		s.framing = &framing{compress: true, minSize: minSize}
+	}
+}
+
+// marshal encodes m into a pooled buffer in the service's wire format.
+func (s Service) marshal(m Message) (*bytes.Buffer, error) {
+	if s.framing == nil && !s.zeroCopy {
+		return marshalMessage(m)
+	}
+	b := bufferPool.Get().(*bytes.Buffer)
+	b.Reset()
+	codec := byte(codecJSON)
+	if s.zeroCopy {
+		codec = codecZeroCopy
+	}
+	if s.framing != nil {
+		b.WriteString(frameMagic)
+		b.Write([]byte{frameVersion, codec, 0})
+	} else {
+		b.WriteByte(frameZeroCopy)
+	}
+	var err error
+	if s.zeroCopy {
+		err = writeZeroCopy(b, m)
+	} else if err = encodeEnvelope(b, m); err == nil {
+		b.Truncate(b.Len() - 1) // the Encoder's newline
+	}
+	if err != nil {
+		releaseBuffer(b)
+		return nil, err
+	}
+	if s.framing == nil || !s.framing.compress || b.Len()-frameHeader < s.framing.minSize {
+		return b, nil
+	}
+	z := bufferPool.Get().(*bytes.Buffer)
+	z.Reset()
+	z.Write(b.Bytes()[:frameHeader])
+	z.Bytes()[frameHeader-1] |= flagGzip
+	zw := gzip.NewWriter(z)
+	zw.Write(b.Bytes()[frameHeader:])
+	err = zw.Close()
+	releaseBuffer(b)
+	if err != nil {
+		releaseBuffer(z)
+		return nil, err
+	}
+	return z, nil
+}
+
+// unmarshalFrame decodes a framed body into m.
+func unmarshalFrame(body []byte, m *Message) error {
+	if len(body) < frameHeader {
+		return errBadFrame
+	}
+	version, codec, flags := body[len(frameMagic)], body[len(frameMagic)+1], body[len(frameMagic)+2]
+	if version > frameVersion || flags&^knownFlags != 0 {
+		return fmt.Errorf("%w: version %d, flags %#x", ErrUnsupportedFrame, version, flags)
+	}
+	body = body[frameHeader:]
+	if flags&flagGzip != 0 {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+		if body, err = ioutil.ReadAll(io.LimitReader(zr, maxInflated+1)); err != nil {
+			return fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+		if len(body) > maxInflated {
+			return fmt.Errorf("%w: frame inflates to over %d bytes", ErrMalformed, maxInflated)
+		}
+	}
+	switch codec {
+	case codecJSON:
+		return decodeEnvelope(body, m)
+	case codecZeroCopy:
+		return unmarshalZeroCopy(body, m)
+	}
+	return fmt.Errorf("%w: codec %d", ErrUnsupportedFrame, codec)
+}
@@ -0,0 +1,39 @@
+package colony
+
+import (
+	"bytes"
+	"testing"
+)
+
+// FuzzUnmarshalMessage feeds transport message bodies to the decoding and
+// reassembly of Messages, none of which may panic.
+func FuzzUnmarshalMessage(f *testing.F) {
+	s := NewService("fuzz", "1", "", WithTransport(NewMemoryTransport()), WithChunkSize(16))
+	defer s.Close()
+	seeds := []Message{
+		s.NewMessage("ants", []byte("hello")),
+		s.NewMessage("ants@2", bytes.Repeat([]byte("chunked "), 8)),
+	}
+	for _, m := range seeds {
+		for _, part := range s.split(m) {
+			body, err := s.marshal(part)
+			if err != nil {
+				f.Fatal(err)
+			}
+			data := append([]byte(nil), body.Bytes()...)
+			releaseBuffer(body)
+			if _, err := UnmarshalMessage(data); err != nil {
+				f.Fatal(err)
+			}
+			f.Add(data)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, err := UnmarshalMessage(data)
+		if err != nil {
+			return
+		}
+		newReassembler(0).add(m)
+	})
+}
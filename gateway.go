@@ -0,0 +1,93 @@
+package colony
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// An HTTPRoute maps HTTP requests onto colony requests of a content type.
+type HTTPRoute struct {
+	Method      string // HTTP method to match, or "" for any
+	Path        string // URL path to match exactly
+	ContentType string // content type the request body is sent as
+}
+
+// HTTPGateway is an http.Handler that makes colony services reachable from
+// ordinary web clients. The body of each HTTP request matching one of its
+// routes is sent with Call, and the payload of the response Message is
+// written back. The response's content type and sender are returned in the
+// X-Colony-Content-Type and X-Colony-From headers. A W3C traceparent sent with
+// the HTTP request goes with the colony request.
+type HTTPGateway struct {
+	Service *Service
+	Routes  []HTTPRoute
+	Timeout time.Duration // how long to wait for a response
+}
+
+// NewHTTPGateway returns an HTTPGateway for the supplied routes and announces
+// their content types.
+func NewHTTPGateway(s *Service, timeout time.Duration, routes ...HTTPRoute) *HTTPGateway {
+	for _, route := range routes {
+		s.Announce(route.ContentType)
+	}
+	return &HTTPGateway{
+		Service: s,
+		Routes:  routes,
+		Timeout: timeout,
+	}
+}
+
+// ServeHTTP turns r into a colony request and writes the response to w.
+func (g *HTTPGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route, status := g.match(r)
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), g.Timeout)
+	defer cancel()
+	m := g.Service.NewMessage(route.ContentType, body)
+	m.SetTraceContext(r.Header.Get(TraceParentHeader), r.Header.Get(TraceStateHeader))
+	resp, err := g.Service.Call(ctx, m)
+	if err == context.DeadlineExceeded {
+		http.Error(w, "no response from colony", http.StatusGatewayTimeout)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	payload, err := resp.FetchPayload()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("X-Colony-Content-Type", resp.ContentType)
+	w.Header().Set("X-Colony-From", resp.FromName)
+	w.Write(payload)
+}
+
+// match finds the route for r, returning the HTTP status to respond with if
+// there is none.
+func (g *HTTPGateway) match(r *http.Request) (HTTPRoute, int) {
+	status := http.StatusNotFound
+	for _, route := range g.Routes {
+		if route.Path != r.URL.Path {
+			continue
+		}
+		if route.Method != "" && route.Method != r.Method {
+			status = http.StatusMethodNotAllowed
+			continue
+		}
+		return route, http.StatusOK
+	}
+	return HTTPRoute{}, status
+}
@@ -0,0 +1,54 @@
+// Package gcsblob provides a colony BlobStore backed by Google Cloud Storage.
+package gcsblob
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// Store keeps offloaded colony payloads as objects in a Cloud Storage bucket.
+type Store struct {
+	Client *storage.Client
+	Bucket string
+	Prefix string // prepended to every object name
+}
+
+// Put uploads data as the object for key.
+func (s Store) Put(key string, data []byte) error {
+	w := s.Client.Bucket(s.Bucket).Object(s.Prefix + key).NewWriter(context.Background())
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Get downloads the object for key.
+func (s Store) Get(key string) ([]byte, error) {
+	r, err := s.Client.Bucket(s.Bucket).Object(s.Prefix + key).NewReader(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// List returns the names of the objects starting with prefix, in order.
+func (s Store) List(prefix string) ([]string, error) {
+	var keys []string
+	it := s.Client.Bucket(s.Bucket).Objects(context.Background(), &storage.Query{Prefix: s.Prefix + prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return keys, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, strings.TrimPrefix(attrs.Name, s.Prefix))
+	}
+}
@@ -0,0 +1,151 @@
+// Package grpcgateway serves a gRPC API whose methods are backed by colony
+// requests, for interop with gRPC-native clients.
+//
+// The gateway does not decode messages: the bytes of a gRPC request become the
+// payload of a colony Message, and the payloads of its responses are sent back
+// as gRPC responses. Clients and colony services must agree on the encoding of
+// each method's messages, typically protobuf.
+package grpcgateway
+
+import (
+	"context"
+	"time"
+
+	"github.com/nytlabs/colony"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// A Method describes how a gRPC method maps onto a colony content type.
+type Method struct {
+	ContentType string // content type requests are sent as
+	Streaming   bool   // true for server-streaming methods
+}
+
+// A Gateway turns gRPC calls into colony requests. A unary method returns the
+// first response to its request. A server-streaming method sends every
+// response until the client cancels or no response arrives for Timeout. A W3C
+// traceparent sent in the call's metadata goes with the colony request.
+type Gateway struct {
+	Service *colony.Service
+	Methods map[string]Method // keyed by full method name, e.g. "/pkg.Service/Method"
+	Timeout time.Duration
+}
+
+// NewServer returns a gRPC server serving every method of the gateway, and
+// announces their content types. opts are passed on to grpc.NewServer.
+func (g *Gateway) NewServer(opts ...grpc.ServerOption) *grpc.Server {
+	for _, m := range g.Methods {
+		g.Service.Announce(m.ContentType)
+	}
+	opts = append(opts,
+		grpc.ForceServerCodec(rawCodec{}),
+		grpc.UnknownServiceHandler(g.handle),
+	)
+	return grpc.NewServer(opts...)
+}
+
+func (g *Gateway) handle(_ interface{}, stream grpc.ServerStream) error {
+	name, _ := grpc.MethodFromServerStream(stream)
+	method, ok := g.Methods[name]
+	if !ok {
+		return status.Errorf(codes.Unimplemented, "no colony content type for %s", name)
+	}
+	var in frame
+	if err := stream.RecvMsg(&in); err != nil {
+		return err
+	}
+	m := g.Service.NewMessage(method.ContentType, in)
+	if md, ok := metadata.FromIncomingContext(stream.Context()); ok {
+		m.SetTraceContext(first(md.Get(colony.TraceParentHeader)), first(md.Get(colony.TraceStateHeader)))
+	}
+	if method.Streaming {
+		return g.stream(stream, m)
+	}
+
+	ctx, cancel := context.WithTimeout(stream.Context(), g.Timeout)
+	defer cancel()
+	resp, err := g.Service.Call(ctx, m)
+	if err != nil {
+		return status.FromContextError(err).Err()
+	}
+	return g.send(stream, resp)
+}
+
+// stream sends every response to m until the client goes away or the
+// responses stop.
+func (g *Gateway) stream(stream grpc.ServerStream, m colony.Message) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	responses := make(chan colony.Message)
+	err := g.Service.Request(m, func(c <-chan colony.Message) error {
+		for {
+			select {
+			case r := <-c:
+				select {
+				case responses <- r:
+				case <-ctx.Done():
+					return nil
+				}
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	})
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	for {
+		select {
+		case r := <-responses:
+			if err := g.send(stream, r); err != nil {
+				return err
+			}
+		case <-time.After(g.Timeout):
+			return nil
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+func (g *Gateway) send(stream grpc.ServerStream, r colony.Message) error {
+	payload, err := r.FetchPayload()
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	stream.SetHeader(metadata.Pairs(
+		"x-colony-content-type", r.ContentType,
+		"x-colony-from", r.FromName,
+	))
+	return stream.SendMsg(frame(payload))
+}
+
+// frame is a gRPC message passed through without decoding.
+type frame []byte
+
+// rawCodec moves frames in and out of gRPC untouched.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	return v.(frame), nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	*(v.(*frame)) = append(frame(nil), data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// first returns the first of values, or "" if there are none.
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
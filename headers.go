@@ -0,0 +1,28 @@
+package colony
+
+// Header returns the value of m's header key, or "" if it has none.
+func (m Message) Header(key string) string {
+	return m.Headers[key]
+}
+
+// SetHeader sets m's header key to value. Headers are copied rather than
+// changed in place, so copies of m made earlier keep theirs.
+func (m *Message) SetHeader(key, value string) {
+	headers := make(map[string]string, len(m.Headers)+1)
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+	headers[key] = value
+	m.Headers = headers
+}
+
+// withoutHeader returns a copy of headers without key.
+func withoutHeader(headers map[string]string, key string) map[string]string {
+	out := make(map[string]string, len(headers))
+	for k, v := range headers {
+		if k != key {
+			out[k] = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,51 @@
+package colony
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// A CallOption changes how Call sends its Message.
+type CallOption func(*callOptions)
+
+type callOptions struct {
+	hedgeDelay   time.Duration
+	hedges       int
+	retries      int
+	retryTimeout time.Duration
+}
+
+// WithHedging makes Call send its Message again, up to hedges more times, each
+// time delay passes without a response, so that a slow instance of the
+// responding service doesn't hold up the call. The first response wins and
+// copies not yet sent are cancelled. Every copy has the same MessageID, and
+// each one reaches every consumer of the content type, so only hedge requests
+// that are safe to handle more than once.
+func WithHedging(delay time.Duration, hedges int) CallOption {
+	return func(o *callOptions) {
+		o.hedgeDelay = delay
+		o.hedges = hedges
+	}
+}
+
+// hedge sends copies of m until answered is closed, ctx is done or the copies
+// run out.
+func (s Service) hedge(ctx context.Context, m Message, o callOptions, answered <-chan struct{}) {
+	timer := time.NewTimer(o.hedgeDelay)
+	defer timer.Stop()
+	for i := 0; i < o.hedges; i++ {
+		select {
+		case <-timer.C:
+		case <-answered:
+			return
+		case <-ctx.Done():
+			return
+		}
+		if err := s.publish(m); err != nil {
+			log.Println("COLONY\t could not hedge", m.ContentType, "message", m.MessageID+":", err)
+			return
+		}
+		timer.Reset(o.hedgeDelay)
+	}
+}
@@ -0,0 +1,153 @@
+package colony
+
+import (
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader is the header carrying a request's idempotency key.
+// Every copy of a request sent by Call with WithRetries carries the same key,
+// which responses to it carry back.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// A DedupStore remembers the idempotency keys of the requests a service has
+// handled, and the responses it sent to them, so that a request sent again is
+// answered with the same responses rather than handled twice.
+type DedupStore interface {
+	// Claim records key as seen, reporting false if it already was.
+	Claim(key string) bool
+	// AddResponse records a response sent to the request with key.
+	AddResponse(key string, m Message)
+	// Responses returns the responses recorded for key, in order.
+	Responses(key string) []Message
+	// Forget lets the request with key be handled again.
+	Forget(key string)
+}
+
+// WithDedup has the service check the idempotency key of every Message it
+// consumes against store. A Message whose key was seen before isn't handled
+// again; the responses sent the first time are sent once more instead, or
+// nothing if there are none yet. Responses reporting a retryable RemoteError
+// aren't kept, and let the request be handled again.
+func WithDedup(store DedupStore) Option {
+	return func(s *Service) {
+		s.dedup = store
+	}
+}
+
+// duplicate reports whether m was handled before, resending the responses to
+// it if so.
+func (s Service) duplicate(m Message) bool {
+	key := m.Header(IdempotencyKeyHeader)
+	if s.dedup == nil || key == "" || s.dedup.Claim(key) {
+		return false
+	}
+	s.count("colony.duplicate", m.ContentType)
+	for _, r := range s.dedup.Responses(key) {
+		r.Topic = m.ResponseTopic
+		// sent without its key, so that it isn't recorded again
+		r.Headers = withoutHeader(r.Headers, IdempotencyKeyHeader)
+		if err := s.publish(r); err != nil {
+			log.Println("COLONY\t could not resend response to", m.ContentType, "message", m.MessageID+":", err)
+		}
+	}
+	return true
+}
+
+// responded records r, a response the service is sending, in its DedupStore.
+func (s Service) responded(r Message) {
+	key := r.Header(IdempotencyKeyHeader)
+	if s.dedup == nil || key == "" {
+		return
+	}
+	if r.Error != nil && r.Error.Retryable {
+		s.dedup.Forget(key)
+		return
+	}
+	s.dedup.AddResponse(key, r)
+}
+
+// WithRetries makes Call send its Message again, up to retries more times,
+// when timeout passes without a response or the response is a retryable
+// RemoteError. Every copy carries the same idempotency key, so a responding
+// service with WithDedup handles it only once.
+func WithRetries(retries int, timeout time.Duration) CallOption {
+	return func(o *callOptions) {
+		o.retries = retries
+		o.retryTimeout = timeout
+	}
+}
+
+// newIdempotencyKey returns a random idempotency key.
+func (s Service) newIdempotencyKey() string {
+	var b [16]byte
+	s.randRead(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// MemoryDedupStore is a DedupStore held in memory, forgetting keys ttl after
+// they were claimed.
+type MemoryDedupStore struct {
+	ttl   time.Duration
+	mu    sync.Mutex
+	keys  map[string]*dedupEntry
+	swept time.Time // when expired keys were last deleted
+}
+
+type dedupEntry struct {
+	claimed   time.Time
+	responses []Message
+}
+
+// NewMemoryDedupStore returns a MemoryDedupStore keeping keys for ttl.
+func NewMemoryDedupStore(ttl time.Duration) *MemoryDedupStore {
+	return &MemoryDedupStore{ttl: ttl, keys: make(map[string]*dedupEntry)}
+}
+
+// Claim records key as seen, reporting false if it already was.
+func (d *MemoryDedupStore) Claim(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	if now.Sub(d.swept) > d.ttl {
+		for k, e := range d.keys {
+			if now.Sub(e.claimed) > d.ttl {
+				delete(d.keys, k)
+			}
+		}
+		d.swept = now
+	}
+	if e, ok := d.keys[key]; ok && now.Sub(e.claimed) <= d.ttl {
+		return false
+	}
+	d.keys[key] = &dedupEntry{claimed: now}
+	return true
+}
+
+// AddResponse records a response sent to the request with key.
+func (d *MemoryDedupStore) AddResponse(key string, m Message) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e, ok := d.keys[key]; ok {
+		e.responses = append(e.responses, m)
+	}
+}
+
+// Responses returns the responses recorded for key, in order.
+func (d *MemoryDedupStore) Responses(key string) []Message {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e, ok := d.keys[key]; ok {
+		return append([]Message(nil), e.responses...)
+	}
+	return nil
+}
+
+// Forget lets the request with key be handled again.
+func (d *MemoryDedupStore) Forget(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.keys, key)
+}
@@ -0,0 +1,129 @@
+package colony
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/url"
+)
+
+var (
+	// ErrNoIdentity is returned when a Message carries no sender certificate.
+	ErrNoIdentity = errors.New("message carries no identity")
+	// ErrIdentityMismatch is returned when a Message's sender certificate does
+	// not belong to the service the Message claims to come from.
+	ErrIdentityMismatch = errors.New("message identity does not match its sender")
+)
+
+// IdentityURI returns the URI a service's certificate must carry as a subject
+// alternative name to prove it is the service with the given name and ID.
+func IdentityURI(name, id string) *url.URL {
+	return &url.URL{Scheme: "colony", Host: name, Path: "/" + id}
+}
+
+// WithIdentity makes the service prove its Name and ID to the colony with the
+// supplied certificate. The certificate should carry IdentityURI(name, id) as
+// a URI SAN. Every Message the service emits or announces is signed with the
+// certificate's key and carries the certificate, and the service's
+// connections to nsqd use mutual TLS. An Identity takes the place of a Signer.
+func WithIdentity(cert tls.Certificate) Option {
+	return func(s *Service) {
+		s.identity = &cert
+	}
+}
+
+// WithIdentityRoots requires every Message the service receives to carry a
+// certificate issued by one of roots which identifies the sending service.
+// Messages that don't are sent to the service's RejectHandler. The roots are
+// also used to verify nsqd's certificate when the service has an Identity.
+func WithIdentityRoots(roots *x509.CertPool) Option {
+	return func(s *Service) {
+		s.identityRoots = roots
+	}
+}
+
+// signIdentity attaches the service's certificate to m and signs it with the
+// certificate's private key.
+func (s Service) signIdentity(m Message) (Message, error) {
+	if len(s.identity.Certificate) == 0 {
+		return m, ErrNoIdentity
+	}
+	key, ok := s.identity.PrivateKey.(crypto.Signer)
+	if !ok {
+		return m, errors.New("identity key cannot sign")
+	}
+	m.Identity = s.identity.Certificate[0]
+	m.Signature = nil
+	data := m.signingBytes()
+	var err error
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		m.Signature, err = key.Sign(rand.Reader, data, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(data)
+		m.Signature, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	return m, err
+}
+
+// verifyIdentity checks that m carries a certificate issued by roots, that the
+// certificate names the service m claims to come from, that m's topics belong
+// to that service and that m was signed with the certificate's key.
+func verifyIdentity(m Message, roots *x509.CertPool) error {
+	if len(m.Identity) == 0 {
+		return ErrNoIdentity
+	}
+	cert, err := x509.ParseCertificate(m.Identity)
+	if err != nil {
+		return err
+	}
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return err
+	}
+
+	// the certificate must name the sender, and the sender may only use its
+	// own topics. A response's Topic belongs to the requester, so only its
+	// ResponseTopic is checked.
+	owns := func(t topic) bool {
+		want := IdentityURI(t.ServiceName, t.ServiceID).String()
+		for _, u := range cert.URIs {
+			if u.String() == want {
+				return true
+			}
+		}
+		return false
+	}
+	if m.Topic.ContentType != "responses" && (m.Topic.ServiceName != m.FromName || !owns(m.Topic)) {
+		return ErrIdentityMismatch
+	}
+	if m.ResponseTopic.ServiceName != "" && (m.ResponseTopic.ServiceName != m.FromName || !owns(m.ResponseTopic)) {
+		return ErrIdentityMismatch
+	}
+
+	var algo x509.SignatureAlgorithm
+	switch cert.PublicKey.(type) {
+	case ed25519.PublicKey:
+		algo = x509.PureEd25519
+	case *ecdsa.PublicKey:
+		algo = x509.ECDSAWithSHA256
+	case *rsa.PublicKey:
+		algo = x509.SHA256WithRSA
+	default:
+		return errors.New("unsupported identity key type")
+	}
+	sig := m.Signature
+	m.Signature = nil
+	if err := cert.CheckSignature(algo, m.signingBytes(), sig); err != nil {
+		return ErrBadSignature
+	}
+	return nil
+}
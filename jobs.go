@@ -0,0 +1,178 @@
+package colony
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrLeaseExpired is returned by a Job whose lease ran out before it was done.
+// The Job is delivered again, to this worker or another, through the next of
+// its retry tiers if the service has any for its content type.
+var ErrLeaseExpired = errors.New("job lease expired")
+
+// errReleased is how a released Job is handed back to the transport.
+var errReleased = errors.New("job released")
+
+// A Job is a Message claimed by a worker under a lease. Unless the worker
+// calls Done before the lease runs out, the Job is delivered again, so a
+// worker that crashes or hangs doesn't lose it. Touch renews the lease for
+// work that takes longer.
+type Job struct {
+	Message
+
+	lease    time.Duration
+	mu       sync.Mutex
+	deadline time.Time
+	timer    *time.Timer
+	finished bool
+	result   chan error
+}
+
+// newJob returns m as a Job leased for lease, or for as long as it takes if
+// lease is 0.
+func newJob(m Message, lease time.Duration) *Job {
+	j := &Job{
+		Message:  m,
+		lease:    lease,
+		deadline: time.Now().Add(lease),
+		result:   make(chan error, 1),
+	}
+	if lease > 0 {
+		j.timer = time.AfterFunc(lease, j.expire)
+	}
+	return j
+}
+
+func (j *Job) expire() {
+	j.mu.Lock()
+	if wait := time.Until(j.deadline); wait > 0 {
+		// touched as the timer fired
+		j.timer.Reset(wait)
+		j.mu.Unlock()
+		return
+	}
+	j.mu.Unlock()
+	j.finish(ErrLeaseExpired)
+}
+
+// finish ends the Job with err, or nil if it is done. It reports false if the
+// Job had already ended.
+func (j *Job) finish(err error) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.finished {
+		return false
+	}
+	j.finished = true
+	if j.timer != nil {
+		j.timer.Stop()
+	}
+	j.result <- err
+	return true
+}
+
+// expired reports whether the Job ended before it was claimed.
+func (j *Job) expired() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.finished
+}
+
+// Touch renews the Job's lease, giving the worker as long again to finish it.
+func (j *Job) Touch() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.finished {
+		return ErrLeaseExpired
+	}
+	if j.timer != nil {
+		j.deadline = time.Now().Add(j.lease)
+		j.timer.Reset(j.lease)
+	}
+	return nil
+}
+
+// Done marks the Job finished, so that it isn't delivered again. It returns
+// ErrLeaseExpired if it is too late.
+func (j *Job) Done() error {
+	if !j.finish(nil) {
+		return ErrLeaseExpired
+	}
+	return nil
+}
+
+// Release gives the Job up, to be delivered again straight away.
+func (j *Job) Release() {
+	j.finish(errReleased)
+}
+
+// ConsumeJobs is Consume for work queues: each Message of contentType is
+// delivered to h as a Job leased for lease, and delivered again unless it is
+// Done in time. The lease starts when the Job reaches the service; a lease of
+// 0 lasts for as long as the worker takes. Over NSQ a lease can't outlast the
+// msg_timeout of the service's nsq.Config.
+func (s Service) ConsumeJobs(contentType string, lease time.Duration, h func(<-chan *Job) error) error {
+	return s.consume(contentType, consumeOptions{jobs: true, lease: lease}, func(c <-chan Message) error {
+		jobs := make(chan *Job)
+		done := make(chan struct{})
+		go func() {
+			for {
+				var m Message
+				var ok bool
+				select {
+				case m, ok = <-c:
+				case <-done:
+					return
+				}
+				if !ok {
+					// retired as the consumer's concurrency fell
+					close(jobs)
+					return
+				}
+				if m.job.expired() {
+					// left waiting too long to be claimed
+					continue
+				}
+				select {
+				case jobs <- m.job:
+				case <-done:
+					m.job.Release()
+					return
+				}
+			}
+		}()
+		err := h(jobs)
+		close(done)
+		return err
+	})
+}
+
+// handleJob delivers m as a Job, and holds on to it until the Job is done or
+// its lease runs out.
+func (c queueConsumer) handleJob(m Message) error {
+	job := newJob(m, c.opts.lease)
+	m.job = job
+	var err error
+	select {
+	case c.C <- m:
+		err = <-job.result
+	case err = <-job.result:
+	}
+	return c.retry(m, err)
+}
+
+// retry sends m, handed back with err by a Nack or an expired lease, to its
+// next retry tier if its content type has any, rather than straight back to
+// the topic. It returns err for the transport to deliver m again otherwise.
+func (c queueConsumer) retry(m Message, err error) error {
+	if err != errNacked && err != ErrLeaseExpired || c.s.tiers(m.sentAs()) == nil {
+		return err
+	}
+	if rerr := c.s.Retry(m, err); rerr != nil {
+		log.Println("COLONY\t could not retry", m.ContentType, "message", m.MessageID+":", rerr)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package colony
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// A Join pairs up Messages of two content types that share a key and were
+// sent within Window of each other, as an enrichment service matching orders
+// to customers might. Every Left Message is paired with every matching Right
+// Message, so a key seen several times on one side is paired several times.
+type Join struct {
+	Service *Service
+	Left    string
+	Right   string
+	// Key returns the key a Message is matched on. Messages with an empty
+	// key are dropped.
+	Key    func(Message) string
+	Window time.Duration
+	// Unmatched, if set, is called with each Message that leaves the window
+	// without having been paired.
+	Unmatched func(Message)
+}
+
+type joinEntry struct {
+	m       Message
+	matched bool
+}
+
+type joinSide struct {
+	left bool
+	m    Message
+}
+
+// Run consumes Left and Right and calls h with each matched pair until the
+// service is closed.
+func (j *Join) Run(h func(left, right Message)) error {
+	if j.Window <= 0 {
+		return errors.New("join window must be positive")
+	}
+	in := make(chan joinSide)
+	for _, side := range []string{j.Left, j.Right} {
+		side := side
+		go func() {
+			err := j.Service.Consume(side, func(c <-chan Message) error {
+				for m := range c {
+					select {
+					case in <- joinSide{left: side == j.Left, m: m}:
+					case <-j.Service.lifecycle.done:
+						return nil
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				log.Println("COLONY\t join could not consume", side+":", err)
+			}
+		}()
+	}
+
+	left := make(map[string][]*joinEntry)
+	right := make(map[string][]*joinEntry)
+	ticker := time.NewTicker(j.Window / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case in := <-in:
+			key := j.Key(in.m)
+			if key == "" {
+				continue
+			}
+			mine, theirs := left, right
+			if !in.left {
+				mine, theirs = right, left
+			}
+			e := &joinEntry{m: in.m}
+			for _, other := range theirs[key] {
+				if !j.within(in.m, other.m) {
+					continue
+				}
+				e.matched, other.matched = true, true
+				if in.left {
+					h(in.m, other.m)
+				} else {
+					h(other.m, in.m)
+				}
+			}
+			mine[key] = append(mine[key], e)
+		case now := <-ticker.C:
+			j.expire(left, now)
+			j.expire(right, now)
+		case <-j.Service.lifecycle.done:
+			return nil
+		}
+	}
+}
+
+func (j *Join) within(a, b Message) bool {
+	d := a.Time.Sub(b.Time)
+	if d < 0 {
+		d = -d
+	}
+	return d <= j.Window
+}
+
+// expire drops the entries of buffer sent longer than the window before now.
+func (j *Join) expire(buffer map[string][]*joinEntry, now time.Time) {
+	for key, entries := range buffer {
+		kept := entries[:0]
+		for _, e := range entries {
+			if now.Sub(e.m.Time) <= j.Window {
+				kept = append(kept, e)
+				continue
+			}
+			if !e.matched && j.Unmatched != nil {
+				j.Unmatched(e.m)
+			}
+		}
+		if len(kept) == 0 {
+			delete(buffer, key)
+		} else {
+			buffer[key] = kept
+		}
+	}
+}
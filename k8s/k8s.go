@@ -0,0 +1,168 @@
+// Package k8s sets up colony services running in Kubernetes pods.
+//
+// A service's name and ID come from the pod's metadata, exposed through the
+// downward API: its ID is the pod's name, from the POD_NAME environment
+// variable or the host name, and its name the pod's app label, from a
+// downward API volume, unless COLONY_NAME is set. For example:
+//
+//	env:
+//	- name: POD_NAME
+//	  valueFrom: {fieldRef: {fieldPath: metadata.name}}
+//	- name: POD_NAMESPACE
+//	  valueFrom: {fieldRef: {fieldPath: metadata.namespace}}
+//	volumeMounts:
+//	- {name: podinfo, mountPath: /etc/podinfo}
+//	volumes:
+//	- name: podinfo
+//	  downwardAPI:
+//	    items:
+//	    - {path: labels, fieldRef: {fieldPath: metadata.labels}}
+//
+// Lookupds are found through a headless Service, whose DNS name resolves to
+// every lookupd pod.
+package k8s
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nytlabs/colony"
+)
+
+// Metadata keys under which services describe where they run.
+const (
+	NamespaceKey = "k8s.namespace"
+	PodKey       = "k8s.pod"
+)
+
+// serviceAccountNamespace is where Kubernetes mounts the pod's namespace.
+const serviceAccountNamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// Config says how to find what a service needs from its pod.
+type Config struct {
+	// PodInfo is where the downward API volume holding the pod's labels is
+	// mounted.
+	PodInfo string
+	// NameLabel is the pod label naming the service.
+	NameLabel string
+	// LookupdService is the name of the headless Service in front of the
+	// lookupds, in the pod's namespace unless qualified with another.
+	LookupdService string
+	// LookupdPort is the lookupds' HTTP port.
+	LookupdPort int
+	// Resolve is how often the lookupd Service's name is resolved again.
+	Resolve time.Duration
+}
+
+// DefaultConfig is the Config used by NewService.
+var DefaultConfig = Config{
+	PodInfo:        "/etc/podinfo",
+	NameLabel:      "app",
+	LookupdService: "nsqlookupd",
+	LookupdPort:    4161,
+	Resolve:        time.Minute,
+}
+
+// NewService returns a colony service named for the pod it runs in, using the
+// lookupds behind the headless Service of DefaultConfig. Options are applied
+// after those derived from the pod, so they can override them.
+func NewService(opts ...colony.Option) (*colony.Service, error) {
+	return DefaultConfig.NewService(opts...)
+}
+
+// NewService returns a colony service named for the pod it runs in, using the
+// lookupds behind c's headless Service, and describing itself with the pod's
+// namespace and name.
+func (c Config) NewService(opts ...colony.Option) (*colony.Service, error) {
+	name, id, err := c.Identity()
+	if err != nil {
+		return nil, err
+	}
+	namespace := Namespace()
+	configured := []colony.Option{
+		colony.WithLookupdDNS(c.Resolve),
+		colony.WithMetadata(PodKey, id),
+	}
+	if namespace != "" {
+		configured = append(configured, colony.WithMetadata(NamespaceKey, namespace))
+	}
+	lookupd := c.LookupdAddr(namespace)
+	return colony.NewService(name, id, lookupd, append(configured, opts...)...), nil
+}
+
+// Identity returns the service's name and ID as derived from its pod.
+func (c Config) Identity() (name, id string, err error) {
+	id = os.Getenv("POD_NAME")
+	if id == "" {
+		if id, err = os.Hostname(); err != nil {
+			return "", "", err
+		}
+	}
+	name = os.Getenv("COLONY_NAME")
+	if name == "" {
+		labels, err := readLabels(filepath.Join(c.PodInfo, "labels"))
+		if err != nil {
+			return "", "", err
+		}
+		name = labels[c.NameLabel]
+	}
+	if name == "" {
+		return "", "", errors.New("pod has no " + c.NameLabel + " label and COLONY_NAME is not set")
+	}
+	return name, id, nil
+}
+
+// LookupdAddr returns the HTTP address of the lookupd Service as seen from
+// namespace.
+func (c Config) LookupdAddr(namespace string) string {
+	host := c.LookupdService
+	if !strings.Contains(host, ".") && namespace != "" {
+		host += "." + namespace + ".svc"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(c.LookupdPort))
+}
+
+// Namespace returns the namespace of the pod, from the POD_NAMESPACE
+// environment variable or the service account mounted in it, or "" if
+// neither says.
+func Namespace() string {
+	if ns := os.Getenv("POD_NAMESPACE"); ns != "" {
+		return ns
+	}
+	b, err := ioutil.ReadFile(serviceAccountNamespace)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// readLabels parses a downward API labels file, with a key="value" line for
+// each label.
+func readLabels(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	labels := make(map[string]string)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		kv := strings.SplitN(sc.Text(), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		k, v := kv[0], kv[1]
+		if unquoted, err := strconv.Unquote(v); err == nil {
+			v = unquoted
+		}
+		labels[k] = v
+	}
+	return labels, sc.Err()
+}
@@ -0,0 +1,351 @@
+package colony
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryTransport is a Transport that keeps every topic in process, so a
+// colony of services can run inside a single binary or test without NSQ.
+// Services share a MemoryTransport by passing the same one to WithTransport.
+// Like NSQ, messages published to a topic before it has any channels are
+// held until the first channel subscribes, and channels whose names end in
+// "#ephemeral" are deleted once their last subscription stops. Topic and
+// channel names are held to NSQ's rules, so that a name NSQ would refuse
+// fails here too.
+type MemoryTransport struct {
+	mu     sync.Mutex
+	topics map[string]*memoryTopic
+
+	// RequeueDelay is how long a message is held before redelivery after
+	// its handler returns an error, unless the error is a RequeueError.
+	RequeueDelay time.Duration
+}
+
+type memoryTopic struct {
+	held     [][]byte // until the topic has a channel and isn't paused
+	channels map[string]*memoryChannel
+	count    int64
+	paused   bool
+}
+
+type memoryChannel struct {
+	queue    [][]byte
+	ready    *sync.Cond
+	subs     int
+	inFlight int64
+	count    int64
+	requeues int64
+	deleted  bool
+}
+
+type memorySubscription struct {
+	t       *MemoryTransport
+	topic   *memoryTopic
+	name    string
+	ch      *memoryChannel
+	stopped bool
+}
+
+// NewMemoryTransport returns an empty MemoryTransport.
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{
+		topics:       make(map[string]*memoryTopic),
+		RequeueDelay: time.Second,
+	}
+}
+
+// topic returns the named topic, creating it if need be. The caller must hold
+// the transport's lock.
+func (t *MemoryTransport) topic(name string) *memoryTopic {
+	tp, ok := t.topics[name]
+	if !ok {
+		tp = &memoryTopic{channels: make(map[string]*memoryChannel)}
+		t.topics[name] = tp
+	}
+	return tp
+}
+
+// CreateTopic makes sure topic exists.
+func (t *MemoryTransport) CreateTopic(topic string) error {
+	t.mu.Lock()
+	t.topic(topic)
+	t.mu.Unlock()
+	return nil
+}
+
+// Publish delivers a copy of body to every channel of topic.
+func (t *MemoryTransport) Publish(topic string, body []byte) error {
+	if !validName(topic) {
+		return fmt.Errorf("invalid topic name %q", topic)
+	}
+	body = append([]byte(nil), body...)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tp := t.topic(topic)
+	tp.count++
+	if len(tp.channels) == 0 || tp.paused {
+		tp.held = append(tp.held, body)
+		return nil
+	}
+	tp.deliver(body)
+	return nil
+}
+
+// deliver adds body to every channel of the topic. The caller must hold the
+// transport's lock.
+func (tp *memoryTopic) deliver(body []byte) {
+	for _, ch := range tp.channels {
+		ch.queue = append(ch.queue, body)
+		ch.count++
+		ch.ready.Broadcast()
+	}
+}
+
+// PauseTopic holds messages published to topic back from its channels.
+func (t *MemoryTransport) PauseTopic(topic string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.topic(topic).paused = true
+	return nil
+}
+
+// UnpauseTopic passes the messages held back in topic to its channels, and
+// those published after them.
+func (t *MemoryTransport) UnpauseTopic(topic string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tp := t.topic(topic)
+	tp.paused = false
+	if len(tp.channels) == 0 {
+		return nil
+	}
+	for _, body := range tp.held {
+		tp.deliver(body)
+	}
+	tp.held = nil
+	return nil
+}
+
+// MultiPublish publishes each of bodies to topic in turn.
+func (t *MemoryTransport) MultiPublish(topic string, bodies [][]byte) error {
+	for _, body := range bodies {
+		if err := t.Publish(topic, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeferredPublish publishes body to topic once delay has passed. Deferred
+// messages are lost if the process stops first.
+func (t *MemoryTransport) DeferredPublish(topic string, delay time.Duration, body []byte) error {
+	body = append([]byte(nil), body...)
+	time.AfterFunc(delay, func() {
+		t.Publish(topic, body)
+	})
+	return nil
+}
+
+// Subscribe calls h with each message on topic's channel. Subscriptions to the
+// same channel take turns with its messages.
+func (t *MemoryTransport) Subscribe(topic, channel string, h func(body []byte) error) (Subscription, error) {
+	return t.SubscribeConcurrent(topic, channel, 1, h)
+}
+
+// SubscribeConcurrent is Subscribe with h called from n goroutines.
+func (t *MemoryTransport) SubscribeConcurrent(topic, channel string, n int, h func(body []byte) error) (Subscription, error) {
+	if !validName(topic) {
+		return nil, fmt.Errorf("invalid topic name %q", topic)
+	}
+	if !validName(channel) {
+		return nil, fmt.Errorf("invalid channel name %q", channel)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tp := t.topic(topic)
+	ch, ok := tp.channels[channel]
+	if !ok {
+		ch = &memoryChannel{ready: sync.NewCond(&t.mu)}
+		if len(tp.channels) == 0 && !tp.paused {
+			ch.queue, tp.held = tp.held, nil
+			ch.count = int64(len(ch.queue))
+		}
+		tp.channels[channel] = ch
+	}
+	ch.subs++
+	sub := &memorySubscription{t: t, topic: tp, name: channel, ch: ch}
+	for i := 0; i < n; i++ {
+		go sub.run(h)
+	}
+	return sub, nil
+}
+
+func (sub *memorySubscription) run(h func(body []byte) error) {
+	t := sub.t
+	for {
+		t.mu.Lock()
+		for len(sub.ch.queue) == 0 && !sub.stopped && !sub.ch.deleted {
+			sub.ch.ready.Wait()
+		}
+		if sub.stopped || sub.ch.deleted {
+			t.mu.Unlock()
+			return
+		}
+		body := sub.ch.queue[0]
+		sub.ch.queue = sub.ch.queue[1:]
+		sub.ch.inFlight++
+		t.mu.Unlock()
+
+		err := h(body)
+		t.mu.Lock()
+		sub.ch.inFlight--
+		if err != nil {
+			sub.ch.requeues++
+		}
+		t.mu.Unlock()
+		if err != nil {
+			time.AfterFunc(requeueDelay(err, t.RequeueDelay), func() {
+				t.mu.Lock()
+				sub.ch.queue = append(sub.ch.queue, body)
+				sub.ch.ready.Broadcast()
+				t.mu.Unlock()
+			})
+		}
+	}
+}
+
+// Stop ends delivery to the subscription. Unless it is ephemeral, its channel
+// keeps collecting messages for other and future subscriptions.
+func (sub *memorySubscription) Stop() {
+	sub.t.mu.Lock()
+	defer sub.t.mu.Unlock()
+	if sub.stopped {
+		return
+	}
+	sub.stopped = true
+	sub.ch.ready.Broadcast()
+	sub.ch.subs--
+	if sub.ch.subs == 0 && strings.HasSuffix(sub.name, "#ephemeral") && sub.topic.channels[sub.name] == sub.ch {
+		delete(sub.topic.channels, sub.name)
+	}
+}
+
+// DeleteTopic deletes topic, its channels and the messages in them. Any
+// subscriptions to it stop receiving messages.
+func (t *MemoryTransport) DeleteTopic(topic string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tp, ok := t.topics[topic]
+	if !ok {
+		return nil
+	}
+	for _, ch := range tp.channels {
+		ch.deleted = true
+		ch.ready.Broadcast()
+	}
+	delete(t.topics, topic)
+	return nil
+}
+
+// DeleteChannel deletes a channel of topic and the messages in it. Any
+// subscriptions to it stop receiving messages.
+func (t *MemoryTransport) DeleteChannel(topic, channel string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tp, ok := t.topics[topic]
+	if !ok {
+		return nil
+	}
+	if ch, ok := tp.channels[channel]; ok {
+		ch.deleted = true
+		ch.ready.Broadcast()
+		delete(tp.channels, channel)
+	}
+	return nil
+}
+
+// EmptyTopic throws away the messages held in topic.
+func (t *MemoryTransport) EmptyTopic(topic string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tp, ok := t.topics[topic]; ok {
+		tp.held = nil
+	}
+	return nil
+}
+
+// EmptyChannel throws away the messages waiting in a channel of topic.
+// Messages waiting to be requeued come back to it regardless.
+func (t *MemoryTransport) EmptyChannel(topic, channel string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tp, ok := t.topics[topic]; ok {
+		if ch, ok := tp.channels[channel]; ok {
+			ch.queue = nil
+		}
+	}
+	return nil
+}
+
+// Discover returns the names of all topics, in order.
+func (t *MemoryTransport) Discover() ([]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	topics := make([]string, 0, len(t.topics))
+	for name := range t.topics {
+		topics = append(topics, name)
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+// TopicStats returns the state of every topic, in order of name. Messages
+// waiting to be requeued are counted in neither Depth nor InFlight.
+func (t *MemoryTransport) TopicStats() ([]TopicStats, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]TopicStats, 0, len(t.topics))
+	for name, tp := range t.topics {
+		ts := TopicStats{
+			Name:         name,
+			Depth:        int64(len(tp.held)),
+			MessageCount: tp.count,
+			Paused:       tp.paused,
+		}
+		for cname, ch := range tp.channels {
+			ts.Channels = append(ts.Channels, ChannelStats{
+				Name:         cname,
+				Depth:        int64(len(ch.queue)),
+				InFlight:     ch.inFlight,
+				MessageCount: ch.count,
+				RequeueCount: ch.requeues,
+				Clients:      ch.subs,
+			})
+		}
+		sort.Slice(ts.Channels, func(i, j int) bool { return ts.Channels[i].Name < ts.Channels[j].Name })
+		out = append(out, ts)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// validName reports whether NSQ accepts name for a topic or channel: 1 to 64
+// of the characters [.a-zA-Z0-9_-], optionally followed by #ephemeral.
+func validName(name string) bool {
+	name = strings.TrimSuffix(name, "#ephemeral")
+	if len(name) == 0 || len(name) > 64 {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
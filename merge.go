@@ -0,0 +1,42 @@
+package colony
+
+import "log"
+
+// Merge consumes each of contentTypes and passes their Messages to h over one
+// channel, in the order they arrive, so that a service aggregating several
+// content types can handle them in one place. Each Message keeps its own
+// ContentType. Like Consume, Merge blocks until h returns, after which the
+// service no longer receives any of contentTypes.
+func (s Service) Merge(h Handler, contentTypes ...string) error {
+	merged := make(chan Message)
+	done := make(chan struct{})
+	for _, contentType := range contentTypes {
+		contentType := contentType
+		go func() {
+			err := s.Consume(contentType, func(c <-chan Message) error {
+				for {
+					select {
+					case m, ok := <-c:
+						if !ok {
+							// retired as the consumer's concurrency fell
+							return nil
+						}
+						select {
+						case merged <- m:
+						case <-done:
+							return nil
+						}
+					case <-done:
+						return nil
+					}
+				}
+			})
+			if err != nil {
+				log.Println("COLONY\t could not merge", contentType+":", err)
+			}
+		}()
+	}
+	err := h(merged)
+	close(done)
+	return err
+}
@@ -0,0 +1,45 @@
+package colony
+
+// Metrics receives measurements of what a service does, for a monitoring
+// system such as StatsD or Prometheus. The statsdmetrics and prommetrics
+// packages provide Metrics. Names are dotted, as in colony.emitted, and tags
+// qualify a measurement, as content_type does. Metrics must be safe for
+// concurrent use.
+type Metrics interface {
+	// Counter adds delta to the count name.
+	Counter(name string, delta int64, tags map[string]string)
+	// Histogram records value among the distribution name.
+	Histogram(name string, value float64, tags map[string]string)
+	// Gauge sets name to value.
+	Gauge(name string, value float64, tags map[string]string)
+}
+
+// WithMetrics has the service report what it does to m, rather than to
+// nothing: by content type, how many Messages it emits, fails to emit,
+// spools, consumes, rejects, drops as expired or duplicate, retries and
+// dead-letters, and how large their payloads are; how long Calls take and how
+// often they are retried; and how many response Handlers are running.
+func WithMetrics(m Metrics) Option {
+	return func(s *Service) {
+		if m != nil {
+			s.metrics = m
+		}
+	}
+}
+
+// noMetrics is the Metrics of a service without WithMetrics.
+type noMetrics struct{}
+
+func (noMetrics) Counter(string, int64, map[string]string)     {}
+func (noMetrics) Histogram(string, float64, map[string]string) {}
+func (noMetrics) Gauge(string, float64, map[string]string)     {}
+
+// count adds one to the service's count name for contentType.
+func (s Service) count(name, contentType string) {
+	s.metrics.Counter(name, 1, map[string]string{"content_type": contentType})
+}
+
+// observe records value in the service's histogram name for contentType.
+func (s Service) observe(name, contentType string, value float64) {
+	s.metrics.Histogram(name, value, map[string]string{"content_type": contentType})
+}
@@ -0,0 +1,56 @@
+package colony
+
+import "fmt"
+
+// A Migration upgrades a payload from one schema version to the next.
+type Migration func(payload []byte) ([]byte, error)
+
+// WithMigrations makes the service upgrade incoming Messages of contentType to
+// its latest schema version before delivering them, so handlers only see the
+// latest shape while old producers keep working. migrations[0] upgrades
+// version 1 to 2, migrations[1] version 2 to 3, and so on. The version of a
+// Message is that of its Format; Messages without one are taken as version 1.
+func WithMigrations(contentType string, migrations ...Migration) Option {
+	return func(s *Service) {
+		if s.migrations == nil {
+			s.migrations = make(map[string][]Migration)
+		}
+		s.migrations[contentType] = migrations
+	}
+}
+
+// migrate runs m's payload through the migrations of its content type, from
+// its version to the latest.
+func (s Service) migrate(m Message) (Message, error) {
+	migrations := s.migrations[m.ContentType]
+	if len(migrations) == 0 {
+		return m, nil
+	}
+	f := Format{Version: 1}
+	if m.Format != nil {
+		f = *m.Format
+	}
+	latest := len(migrations) + 1
+	if f.Version >= latest {
+		return m, nil
+	}
+	if f.Version < 1 {
+		return m, fmt.Errorf("cannot migrate %s from version %d", m.ContentType, f.Version)
+	}
+
+	payload, err := m.FetchPayload()
+	if err != nil {
+		return m, err
+	}
+	for v := f.Version; v < latest; v++ {
+		payload, err = migrations[v-1](payload)
+		if err != nil {
+			return m, fmt.Errorf("migrating %s from version %d: %v", m.ContentType, v, err)
+		}
+	}
+	m.Payload = payload
+	m.PayloadRef = ""
+	f.Version = latest
+	m.Format = &f
+	return m, nil
+}
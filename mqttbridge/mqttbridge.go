@@ -0,0 +1,69 @@
+// Package mqttbridge connects MQTT brokers to a colony, so device fleets can
+// feed the colony directly and colony content types can be pushed out to
+// devices.
+package mqttbridge
+
+import (
+	"log"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/nytlabs/colony"
+)
+
+// A Gateway republishes MQTT messages as colony Messages and colony Messages
+// as MQTT messages. MQTT messages carry no sender, so the topics in FromMQTT
+// and ToMQTT must not overlap or messages will loop.
+type Gateway struct {
+	Service *colony.Service
+	Client  mqtt.Client
+	QoS     byte // quality of service used to subscribe and publish
+
+	// FromMQTT maps MQTT topic filters to the colony content type their
+	// messages are emitted as.
+	FromMQTT map[string]string
+	// ToMQTT maps colony content types to the MQTT topic they are published
+	// on.
+	ToMQTT map[string]string
+}
+
+// Run subscribes to every MQTT topic filter and starts consuming every colony
+// content type. The Client must already be connected.
+func (g *Gateway) Run() error {
+	for filter, contentType := range g.FromMQTT {
+		if err := g.Service.Announce(contentType); err != nil {
+			return err
+		}
+		contentType := contentType
+		token := g.Client.Subscribe(filter, g.QoS, func(_ mqtt.Client, msg mqtt.Message) {
+			m := g.Service.NewMessage(contentType, msg.Payload())
+			if err := g.Service.Emit(m); err != nil {
+				log.Println("COLONY\t could not relay MQTT message from", msg.Topic(), "as", contentType+":", err)
+			}
+		})
+		if token.Wait() && token.Error() != nil {
+			return token.Error()
+		}
+	}
+	for contentType, topic := range g.ToMQTT {
+		go g.toMQTT(contentType, topic)
+	}
+	return nil
+}
+
+func (g *Gateway) toMQTT(contentType, topic string) {
+	err := g.Service.Consume(contentType, func(msgs <-chan colony.Message) error {
+		for m := range msgs {
+			if m.FromName == g.Service.Name {
+				continue
+			}
+			token := g.Client.Publish(topic, g.QoS, false, m.Payload)
+			if token.Wait() && token.Error() != nil {
+				log.Println("COLONY\t could not relay", contentType, "to MQTT:", token.Error())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("COLONY\t could not consume", contentType, "for MQTT:", err)
+	}
+}
@@ -0,0 +1,155 @@
+package colony
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// WithNamespace keeps the service among the services of namespace ns, so that
+// several colonies can share one NSQ cluster: the service only sees the topics
+// of its namespace, and only its namespace sees the service's.
+func WithNamespace(ns string) Option {
+	return func(s *Service) {
+		s.namespace = ns
+	}
+}
+
+// NamespaceTransport returns a Transport that keeps to the topics of t in
+// namespace ns. Topics are stored in t with the namespace and a dot in front
+// of their names.
+func NamespaceTransport(t Transport, ns string) Transport {
+	return namespaceTransport{t, ns + "."}
+}
+
+type namespaceTransport struct {
+	t      Transport
+	prefix string
+}
+
+func (n namespaceTransport) CreateTopic(topic string) error {
+	return n.t.CreateTopic(n.prefix + topic)
+}
+
+func (n namespaceTransport) Publish(topic string, body []byte) error {
+	return n.t.Publish(n.prefix+topic, body)
+}
+
+func (n namespaceTransport) Subscribe(topic, channel string, h func(body []byte) error) (Subscription, error) {
+	return n.t.Subscribe(n.prefix+topic, channel, h)
+}
+
+// SubscribeConcurrent subscribes with concurrent handlers if the underlying
+// transport is a ConcurrentTransport, and with one otherwise.
+func (n namespaceTransport) SubscribeConcurrent(topic, channel string, c int, h func(body []byte) error) (Subscription, error) {
+	if ct, ok := n.t.(ConcurrentTransport); ok {
+		return ct.SubscribeConcurrent(n.prefix+topic, channel, c, h)
+	}
+	return n.Subscribe(topic, channel, h)
+}
+
+func (n namespaceTransport) Discover() ([]string, error) {
+	topics, err := n.t.Discover()
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, topic := range topics {
+		if strings.HasPrefix(topic, n.prefix) {
+			out = append(out, strings.TrimPrefix(topic, n.prefix))
+		}
+	}
+	return out, nil
+}
+
+// MultiPublish publishes bodies at once if the underlying transport is a
+// BatchTransport, and one at a time otherwise.
+func (n namespaceTransport) MultiPublish(topic string, bodies [][]byte) error {
+	if bt, ok := n.t.(BatchTransport); ok {
+		return bt.MultiPublish(n.prefix+topic, bodies)
+	}
+	for _, body := range bodies {
+		if err := n.Publish(topic, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeferredPublish holds body back for delay in the underlying transport if it
+// is a DeferredTransport, and in this process otherwise.
+func (n namespaceTransport) DeferredPublish(topic string, delay time.Duration, body []byte) error {
+	if dt, ok := n.t.(DeferredTransport); ok {
+		return dt.DeferredPublish(n.prefix+topic, delay, body)
+	}
+	body = append([]byte(nil), body...)
+	time.AfterFunc(delay, func() { n.Publish(topic, body) })
+	return nil
+}
+
+func (n namespaceTransport) PauseTopic(topic string) error {
+	ct, ok := n.t.(ControlTransport)
+	if !ok {
+		return errNoControl
+	}
+	return ct.PauseTopic(n.prefix + topic)
+}
+
+func (n namespaceTransport) UnpauseTopic(topic string) error {
+	ct, ok := n.t.(ControlTransport)
+	if !ok {
+		return errNoControl
+	}
+	return ct.UnpauseTopic(n.prefix + topic)
+}
+
+func (n namespaceTransport) DeleteTopic(topic string) error {
+	ct, ok := n.t.(ControlTransport)
+	if !ok {
+		return errNoControl
+	}
+	return ct.DeleteTopic(n.prefix + topic)
+}
+
+func (n namespaceTransport) DeleteChannel(topic, channel string) error {
+	ct, ok := n.t.(ControlTransport)
+	if !ok {
+		return errNoControl
+	}
+	return ct.DeleteChannel(n.prefix+topic, channel)
+}
+
+func (n namespaceTransport) EmptyTopic(topic string) error {
+	ct, ok := n.t.(ControlTransport)
+	if !ok {
+		return errNoControl
+	}
+	return ct.EmptyTopic(n.prefix + topic)
+}
+
+func (n namespaceTransport) EmptyChannel(topic, channel string) error {
+	ct, ok := n.t.(ControlTransport)
+	if !ok {
+		return errNoControl
+	}
+	return ct.EmptyChannel(n.prefix+topic, channel)
+}
+
+func (n namespaceTransport) TopicStats() ([]TopicStats, error) {
+	st, ok := n.t.(StatsTransport)
+	if !ok {
+		return nil, errors.New("transport does not report stats")
+	}
+	stats, err := st.TopicStats()
+	if err != nil {
+		return nil, err
+	}
+	var out []TopicStats
+	for _, ts := range stats {
+		if strings.HasPrefix(ts.Name, n.prefix) {
+			ts.Name = strings.TrimPrefix(ts.Name, n.prefix)
+			out = append(out, ts)
+		}
+	}
+	return out, nil
+}
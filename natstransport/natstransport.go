@@ -0,0 +1,142 @@
+// Package natstransport provides a colony Transport backed by NATS, so teams
+// already running NATS can use colony without operating NSQ as well.
+//
+// Core NATS does not store messages: a message published on a topic reaches
+// only the subscriptions that exist at the time, and a message whose handler
+// fails is not redelivered. Colony channels map onto NATS queue groups.
+package natstransport
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nytlabs/colony"
+)
+
+// discoverSubject is where every Transport answers requests for its topics.
+const discoverSubject = "_colony.discover"
+
+// Transport is a colony Transport over a NATS connection. NATS has no list of
+// subjects, so each Transport remembers the topics it has created or published
+// to and shares them with other Transports on request.
+type Transport struct {
+	conn   *nats.Conn
+	mu     sync.Mutex
+	topics map[string]bool
+
+	// DiscoverTimeout is how long Discover waits for other Transports to
+	// report their topics.
+	DiscoverTimeout time.Duration
+}
+
+// New returns a Transport using conn.
+func New(conn *nats.Conn) (*Transport, error) {
+	t := &Transport{
+		conn:            conn,
+		topics:          make(map[string]bool),
+		DiscoverTimeout: 250 * time.Millisecond,
+	}
+	_, err := conn.Subscribe(discoverSubject, func(m *nats.Msg) {
+		out, err := json.Marshal(t.known())
+		if err != nil {
+			return
+		}
+		m.Respond(out)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Transport) known() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	topics := make([]string, 0, len(t.topics))
+	for topic := range t.topics {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// CreateTopic records topic so that other Transports can discover it.
+func (t *Transport) CreateTopic(topic string) error {
+	t.mu.Lock()
+	t.topics[topic] = true
+	t.mu.Unlock()
+	return nil
+}
+
+// Publish sends body on the NATS subject named topic.
+func (t *Transport) Publish(topic string, body []byte) error {
+	t.CreateTopic(topic)
+	return t.conn.Publish(topic, body)
+}
+
+type subscription struct {
+	sub *nats.Subscription
+}
+
+func (s subscription) Stop() {
+	s.sub.Unsubscribe()
+}
+
+// Subscribe joins the queue group named channel on the subject named topic.
+func (t *Transport) Subscribe(topic, channel string, h func(body []byte) error) (colony.Subscription, error) {
+	sub, err := t.conn.QueueSubscribe(topic, channel, func(m *nats.Msg) {
+		if err := h(m.Data); err != nil {
+			log.Println("COLONY\t dropping message on", topic, "after handler error:", err)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return subscription{sub}, nil
+}
+
+// Discover asks every Transport on the NATS cluster for its topics and returns
+// them together with its own.
+func (t *Transport) Discover() ([]string, error) {
+	inbox := nats.NewInbox()
+	sub, err := t.conn.SubscribeSync(inbox)
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsubscribe()
+	if err := t.conn.PublishRequest(discoverSubject, inbox, nil); err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool)
+	for _, topic := range t.known() {
+		found[topic] = true
+	}
+	deadline := time.Now().Add(t.DiscoverTimeout)
+	for {
+		m, err := sub.NextMsg(deadline.Sub(time.Now()))
+		if err == nats.ErrTimeout {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var topics []string
+		if json.Unmarshal(m.Data, &topics) != nil {
+			continue
+		}
+		for _, topic := range topics {
+			found[topic] = true
+		}
+	}
+
+	topics := make([]string, 0, len(found))
+	for topic := range found {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
@@ -0,0 +1,111 @@
+package colony
+
+import (
+	"sync"
+	"time"
+)
+
+// A ServiceEventKind says what happened inside a service.
+type ServiceEventKind string
+
+// The things a service reports through WatchEvents.
+const (
+	// TopicAttached is a consumer of ContentType subscribing to Topic,
+	// which it found when it started or has since been announced or
+	// discovered.
+	TopicAttached ServiceEventKind = "topic-attached"
+	// TopicSearchFailed is a consumer of ContentType failing to look for
+	// new topics, for Err.
+	TopicSearchFailed ServiceEventKind = "topic-search-failed"
+	// HandlerExited is the response Handler for the request MessageID
+	// returning.
+	HandlerExited ServiceEventKind = "handler-exited"
+	// Rejected is a Message refused delivery, for Err.
+	Rejected ServiceEventKind = "rejected"
+	// DeadLettered is a Message of ContentType sent to the dead-letter
+	// topic, for Err.
+	DeadLettered ServiceEventKind = "dead-lettered"
+	// Reconfigured is a new RuntimeConfig being applied.
+	Reconfigured ServiceEventKind = "reconfigured"
+	// Closed is the service being closed. It is the last event sent.
+	Closed ServiceEventKind = "closed"
+)
+
+// A ServiceEvent is something that happened inside a service, which
+// applications may want to count, log or react to. Fields that don't apply to
+// its Kind are empty.
+type ServiceEvent struct {
+	Kind        ServiceEventKind
+	Time        time.Time
+	ContentType string
+	Topic       string
+	MessageID   string
+	Err         error
+}
+
+// WatchEvents returns the ServiceEvents of the service from now on, until the
+// returned function is called or the service is closed, when the channel is
+// closed. Events are dropped rather than holding up the service when more
+// than buffer are waiting to be received.
+func (s Service) WatchEvents(buffer int) (<-chan ServiceEvent, func()) {
+	return s.events.watch(buffer)
+}
+
+// notify sends e to whoever is watching the service's events.
+func (s Service) notify(e ServiceEvent) {
+	e.Time = time.Now()
+	s.events.send(e)
+}
+
+// eventBus hands a service's ServiceEvents to its watchers.
+type eventBus struct {
+	mu       sync.Mutex
+	watchers map[chan ServiceEvent]bool
+	closed   bool
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{watchers: make(map[chan ServiceEvent]bool)}
+}
+
+func (b *eventBus) watch(buffer int) (<-chan ServiceEvent, func()) {
+	c := make(chan ServiceEvent, buffer)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(c)
+		return c, func() {}
+	}
+	b.watchers[c] = true
+	return c, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.watchers[c] {
+			delete(b.watchers, c)
+			close(c)
+		}
+	}
+}
+
+func (b *eventBus) send(e ServiceEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.watchers {
+		select {
+		case c <- e:
+		default:
+		}
+	}
+}
+
+// close sends e, which ends the events, and closes every watcher's channel.
+func (b *eventBus) close(e ServiceEvent) {
+	b.send(e)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for c := range b.watchers {
+		close(c)
+	}
+	b.watchers = nil
+}
@@ -0,0 +1,316 @@
+package colony
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"math/rand"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bitly/go-nsq"
+)
+
+type producers struct {
+	Producers []producer
+}
+type producer struct {
+	Topics            []string
+	Tombstones        []string
+	Version           string
+	Http_port         int
+	Tcp_port          int
+	Broadcast_address string
+	Hostname          string
+	Remote_address    string
+}
+
+type lookupdTopics struct {
+	Topics []string
+}
+
+// NSQTransport is the Transport colony services use by default. Messages are
+// published to a single nsqd picked at random from those registered with
+// lookupd, falling over to the others should it fail, and consumed from every
+// nsqd that lookupd knows holds the topic.
+type NSQTransport struct {
+	config             *nsq.Config
+	mu                 sync.RWMutex
+	producers          []*nsq.Producer
+	failover           []*nsq.Producer // one to each other nsqd, tried in turn
+	known              map[string]bool // the TCP addresses of nsqds with producers
+	next               uint32          // the producer to publish through next
+	direct             bool            // consumers connect to every nsqd at once
+	nsqLookupdHTTPAddr string
+	lookupds           []string               // nsqLookupdHTTPAddr resolved, if it has been
+	consumers          map[*nsq.Consumer]bool // told of lookupds as they are resolved
+	nsqdAddr           string
+	nsqdHTTPAddr       string
+}
+
+// NewNSQTransport returns a Transport for the NSQ cluster registered with the
+// lookupd at nsqLookupd. Producers and consumers are created with config.
+func NewNSQTransport(nsqLookupd string, config *nsq.Config) (*NSQTransport, error) {
+	return newNSQTransport(nsqLookupd, config, rand.Intn)
+}
+
+// newNSQTransport is NewNSQTransport publishing to the nsqd at index pick(n)
+// of the n registered, in order of address.
+func newNSQTransport(nsqLookupd string, config *nsq.Config, pick func(n int) int) (*NSQTransport, error) {
+	nodes, err := lookupNodes(nsqLookupd)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) <= 0 {
+		return nil, errors.New("found no NSQ daemons")
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].tcpAddr() < nodes[j].tcpAddr() })
+	productionNSQD := nodes[pick(len(nodes))]
+	nsqdAddr := productionNSQD.tcpAddr()
+	nsqdHTTPAddr := productionNSQD.Broadcast_address + ":" + strconv.Itoa(productionNSQD.Http_port)
+
+	producer, err := nsq.NewProducer(nsqdAddr, config)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("COLONY\t Using NSQD TCP:", nsqdAddr)
+	log.Println("COLONY\t Using NSQD HTTP:", nsqdHTTPAddr)
+	t := &NSQTransport{
+		config:             config,
+		producers:          []*nsq.Producer{producer},
+		known:              map[string]bool{nsqdAddr: true},
+		consumers:          make(map[*nsq.Consumer]bool),
+		nsqLookupdHTTPAddr: nsqLookupd,
+		nsqdAddr:           nsqdAddr,
+		nsqdHTTPAddr:       nsqdHTTPAddr,
+	}
+	if err := t.addNodes(nodes); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// lookupNodes returns the nsqds registered with the lookupd at nsqLookupd.
+func lookupNodes(nsqLookupd string) ([]producer, error) {
+	var n producers
+	if err := nsqCall("GET", nsqLookupd, "/nodes", nil, &n); err != nil {
+		return nil, errors.New("could not get list of nsqd nodes: " + err.Error())
+	}
+	return n.Producers, nil
+}
+
+func (p producer) tcpAddr() string {
+	return p.Broadcast_address + ":" + strconv.Itoa(p.Tcp_port)
+}
+
+// CreateTopic creates topic on the transport's nsqd.
+func (t *NSQTransport) CreateTopic(topic string) error {
+	query := url.Values{"topic": {topic}}
+	err := nsqCall("POST", t.nsqdHTTPAddr, "/topic/create", query, nil)
+	if unknownEndpoint(err) {
+		// nsqd from before 1.0 may only know the old name
+		err = nsqCall("GET", t.nsqdHTTPAddr, "/create_topic", query, nil)
+	}
+	if err != nil {
+		return errors.New("could not create topic " + topic + ": " + err.Error())
+	}
+	return nil
+}
+
+// SetProducers has the transport publish through n connections to its nsqd
+// rather than one, taking each in turn, so that a busy service isn't held up
+// by a single connection. It must be called before the transport is used.
+func (t *NSQTransport) SetProducers(n int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if n < 1 {
+		n = 1
+	}
+	for len(t.producers) < n {
+		p, err := nsq.NewProducer(t.nsqdAddr, t.config)
+		if err != nil {
+			return err
+		}
+		t.producers = append(t.producers, p)
+	}
+	for _, p := range t.producers[n:] {
+		p.Stop()
+	}
+	t.producers = t.producers[:n]
+	return nil
+}
+
+// publish calls f with the connection to publish through next and, should it
+// fail, with the connection to each other nsqd in turn until one succeeds.
+func (t *NSQTransport) publish(f func(*nsq.Producer) error) error {
+	t.mu.RLock()
+	i := atomic.AddUint32(&t.next, 1)
+	p := t.producers[i%uint32(len(t.producers))]
+	failover := t.failover
+	t.mu.RUnlock()
+	err := f(p)
+	for _, p := range failover {
+		if err == nil {
+			break
+		}
+		err = f(p)
+	}
+	return err
+}
+
+// Publish sends body on topic through the transport's nsqd.
+func (t *NSQTransport) Publish(topic string, body []byte) error {
+	return t.publish(func(p *nsq.Producer) error {
+		return p.Publish(topic, body)
+	})
+}
+
+// MultiPublish sends bodies on topic through the transport's nsqd at once.
+func (t *NSQTransport) MultiPublish(topic string, bodies [][]byte) error {
+	return t.publish(func(p *nsq.Producer) error {
+		return p.MultiPublish(topic, bodies)
+	})
+}
+
+// DeferredPublish sends body on topic through the transport's nsqd, which
+// holds it back for delay.
+func (t *NSQTransport) DeferredPublish(topic string, delay time.Duration, body []byte) error {
+	return t.publish(func(p *nsq.Producer) error {
+		return p.DeferredPublish(topic, delay, body)
+	})
+}
+
+// Subscribe consumes topic on channel from every nsqd lookupd knows about.
+func (t *NSQTransport) Subscribe(topic, channel string, h func(body []byte) error) (Subscription, error) {
+	return t.SubscribeConcurrent(topic, channel, 1, h)
+}
+
+// SubscribeConcurrent is Subscribe with h called from n goroutines.
+func (t *NSQTransport) SubscribeConcurrent(topic, channel string, n int, h func(body []byte) error) (Subscription, error) {
+	c, err := nsq.NewConsumer(topic, channel, t.config)
+	if err != nil {
+		return nil, err
+	}
+	c.AddConcurrentHandlers(nsq.HandlerFunc(func(m *nsq.Message) error {
+		err := h(m.Body)
+		var re *RequeueError
+		if errors.As(err, &re) {
+			m.DisableAutoResponse()
+			m.Requeue(re.Delay)
+			return nil
+		}
+		return err
+	}), n)
+	if t.direct {
+		if err := c.ConnectToNSQDs(t.nodeAddrs()); err != nil {
+			c.Stop()
+			return nil, err
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := c.ConnectToNSQLookupds(t.lookupdAddrs()); err != nil {
+		c.Stop()
+		return nil, err
+	}
+	t.consumers[c] = true
+	return nsqSubscription{c, t}, nil
+}
+
+// An nsqSubscription is a consumer the transport stops telling of lookupds
+// once it is stopped.
+type nsqSubscription struct {
+	c *nsq.Consumer
+	t *NSQTransport
+}
+
+func (s nsqSubscription) Stop() {
+	s.t.mu.Lock()
+	delete(s.t.consumers, s.c)
+	s.t.mu.Unlock()
+	s.c.Stop()
+}
+
+// SetDirect has the transport's consumers connect straight to every nsqd it
+// knows of, as well as to those lookupd finds holding the topic, so that they
+// receive messages on a new topic without waiting for lookupd to be polled.
+// It must be called before the transport is used.
+func (t *NSQTransport) SetDirect(direct bool) {
+	t.direct = direct
+}
+
+// nodeAddrs returns the TCP addresses of the nsqds the transport knows of.
+func (t *NSQTransport) nodeAddrs() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	addrs := make([]string, 0, len(t.known))
+	for addr := range t.known {
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// Discover returns every topic registered with lookupd.
+func (t *NSQTransport) Discover() ([]string, error) {
+	var topics lookupdTopics
+	if err := nsqCall("GET", t.lookupd(), "/topics", nil, &topics); err != nil {
+		return nil, err
+	}
+	return topics.Topics, nil
+}
+
+// WithProducers has a service using NSQ publish through n connections to its
+// nsqd rather than one.
+func WithProducers(n int) Option {
+	return func(s *Service) {
+		s.producers = n
+	}
+}
+
+// WithDirectConsumers has a service using NSQ consume from every nsqd at once,
+// rather than only those lookupd has been polled for, so that it receives
+// messages on new topics sooner.
+func WithDirectConsumers() Option {
+	return func(s *Service) {
+		s.directConsumers = true
+	}
+}
+
+// dialNSQ returns an NSQTransport for the lookupd at nsqLookupd, with the
+// service's producers, consumers and lookupds.
+func (s Service) dialNSQ(nsqLookupd string, conf *nsq.Config) (*NSQTransport, error) {
+	t, err := newNSQTransport(nsqLookupd, conf, s.randIntn)
+	if err != nil {
+		return nil, err
+	}
+	if s.producers > 1 {
+		if err := t.SetProducers(s.producers); err != nil {
+			return nil, err
+		}
+	}
+	t.SetDirect(s.directConsumers)
+	if s.lookupdDNS > 0 {
+		if err := t.ResolveLookupd(); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// nsqConfig returns a fresh NSQ configuration for the service's producers and
+// consumers. If the service has an Identity its connections to nsqd use mutual TLS.
+func (s Service) nsqConfig() *nsq.Config {
+	conf := nsq.NewConfig()
+	if s.identity != nil {
+		conf.TlsV1 = true
+		conf.TlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{*s.identity},
+			RootCAs:      s.identityRoots,
+		}
+	}
+	return conf
+}
@@ -0,0 +1,63 @@
+package colony
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// PauseTopic stops every nsqd holding topic from delivering its messages to
+// channels. Messages published meanwhile wait in the topic.
+func (t *NSQTransport) PauseTopic(topic string) error {
+	return t.everyNode("/topic/pause", "/pause_topic", url.Values{"topic": {topic}})
+}
+
+// UnpauseTopic has every nsqd holding topic deliver its messages again.
+func (t *NSQTransport) UnpauseTopic(topic string) error {
+	return t.everyNode("/topic/unpause", "/unpause_topic", url.Values{"topic": {topic}})
+}
+
+// DeleteTopic deletes topic and its channels from every nsqd holding it.
+func (t *NSQTransport) DeleteTopic(topic string) error {
+	return t.everyNode("/topic/delete", "/delete_topic", url.Values{"topic": {topic}})
+}
+
+// DeleteChannel deletes a channel of topic from every nsqd holding it.
+func (t *NSQTransport) DeleteChannel(topic, channel string) error {
+	return t.everyNode("/channel/delete", "/delete_channel", url.Values{"topic": {topic}, "channel": {channel}})
+}
+
+// EmptyTopic throws away the messages waiting in topic on every nsqd holding
+// it.
+func (t *NSQTransport) EmptyTopic(topic string) error {
+	return t.everyNode("/topic/empty", "/empty_topic", url.Values{"topic": {topic}})
+}
+
+// EmptyChannel throws away the messages waiting in a channel of topic on
+// every nsqd holding it.
+func (t *NSQTransport) EmptyChannel(topic, channel string) error {
+	return t.everyNode("/channel/empty", "/empty_channel", url.Values{"topic": {topic}, "channel": {channel}})
+}
+
+// everyNode posts query to path on every nsqd registered with lookupd, or to
+// legacy, the endpoint's name before NSQ 1.0, on nsqds that don't serve path.
+// Nodes that don't have the topic or channel named in the query are skipped.
+func (t *NSQTransport) everyNode(path, legacy string, query url.Values) error {
+	addrs, err := t.nodes()
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		err := nsqCall("POST", addr, path, query, nil)
+		if unknownEndpoint(err) {
+			err = nsqCall("POST", addr, legacy, query, nil)
+		}
+		if e, ok := err.(*nsqError); ok && e.Status == http.StatusNotFound && !unknownEndpoint(err) {
+			continue
+		}
+		if err != nil {
+			return errors.New("nsqd " + addr + path + ": " + err.Error())
+		}
+	}
+	return nil
+}
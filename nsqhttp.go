@@ -0,0 +1,89 @@
+package colony
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// nsqAccept asks nsqd and lookupd for version 1 of their HTTP API, which NSQ
+// 1.0 and later always answer in: the data alone, with errors carried by the
+// HTTP status. Daemons from before 1.0 that don't know it wrap every response
+// in a status_code, status_txt and data envelope.
+const nsqAccept = "application/vnd.nsq; version=1.0"
+
+// An nsqError is an error response from nsqd or lookupd.
+type nsqError struct {
+	Status  int
+	Message string
+}
+
+func (e *nsqError) Error() string {
+	return fmt.Sprintf("nsq: %d %s", e.Status, e.Message)
+}
+
+// unknownEndpoint reports whether err is the answer of a daemon that doesn't
+// serve the endpoint asked for, rather than one saying that the topic or
+// channel named doesn't exist.
+func unknownEndpoint(err error) bool {
+	e, ok := err.(*nsqError)
+	if !ok {
+		return false
+	}
+	return e.Status == http.StatusMethodNotAllowed ||
+		e.Status == http.StatusNotFound && !strings.HasSuffix(e.Message, "_NOT_FOUND")
+}
+
+// nsqCall sends a request for path, with query, to the nsqd or lookupd at
+// addr and decodes the data of its response into v, unless v is nil.
+func nsqCall(method, addr, path string, query url.Values, v interface{}) error {
+	u := "http://" + addr + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", nsqAccept)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return nsqDecode(resp.StatusCode, body, v)
+}
+
+// nsqDecode decodes the data of a response from nsqd or lookupd with status
+// and body into v, unless v is nil, whichever format the response is in.
+func nsqDecode(status int, body []byte, v interface{}) error {
+	var wrapped struct {
+		Status_code int
+		Status_txt  string
+		Data        json.RawMessage
+	}
+	if json.Unmarshal(body, &wrapped) == nil && wrapped.Status_code != 0 {
+		if wrapped.Status_code != http.StatusOK {
+			return &nsqError{Status: wrapped.Status_code, Message: wrapped.Status_txt}
+		}
+		status, body = wrapped.Status_code, wrapped.Data
+	}
+	if status != http.StatusOK {
+		var e struct{ Message string }
+		if json.Unmarshal(body, &e) != nil {
+			e.Message = strings.TrimSpace(string(body))
+		}
+		return &nsqError{Status: status, Message: e.Message}
+	}
+	if v == nil || len(body) == 0 {
+		return nil
+	}
+	return json.Unmarshal(body, v)
+}
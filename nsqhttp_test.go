@@ -0,0 +1,68 @@
+package colony
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNSQResponseFormats(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{"v1", http.StatusOK, `{"producers":[{"broadcast_address":"10.0.0.1","tcp_port":4150,"http_port":4151}]}`},
+		{"legacy", http.StatusOK, `{"status_code":200,"status_txt":"OK","data":{"producers":[{"broadcast_address":"10.0.0.1","tcp_port":4150,"http_port":4151}]}}`},
+	} {
+		var n producers
+		if err := nsqDecode(tc.status, []byte(tc.body), &n); err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if len(n.Producers) != 1 || n.Producers[0].tcpAddr() != "10.0.0.1:4150" {
+			t.Fatalf("%s: got %+v", tc.name, n.Producers)
+		}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{"v1", http.StatusNotFound, `{"message":"TOPIC_NOT_FOUND"}`},
+		{"legacy", http.StatusNotFound, `{"status_code":404,"status_txt":"TOPIC_NOT_FOUND","data":null}`},
+	} {
+		err := nsqDecode(tc.status, []byte(tc.body), nil)
+		if e, ok := err.(*nsqError); !ok || e.Status != http.StatusNotFound || unknownEndpoint(err) {
+			t.Fatalf("%s: got %v, want a missing topic", tc.name, err)
+		}
+	}
+}
+
+func TestNSQCreateTopic(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		var created string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.URL.Path == "/topic/create" && !legacy:
+				created = r.URL.Query().Get("topic")
+			case r.URL.Path == "/create_topic" && legacy:
+				created = r.URL.Query().Get("topic")
+				w.Write([]byte(`{"status_code":200,"status_txt":"OK","data":""}`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"message":"NOT_FOUND"}`))
+			}
+		}))
+		tr := &NSQTransport{nsqdHTTPAddr: strings.TrimPrefix(srv.URL, "http://")}
+		err := tr.CreateTopic("anteater-1-ants")
+		srv.Close()
+		if err != nil {
+			t.Fatalf("legacy %v: %s", legacy, err)
+		}
+		if created != "anteater-1-ants" {
+			t.Fatalf("legacy %v: created %q", legacy, created)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package colony
+
+import (
+	"log"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// ResolveLookupd looks up the lookupd address the transport was created with
+// as a DNS name resolving to every lookupd in the cluster, such as a
+// Kubernetes headless Service, so that the transport spreads its queries
+// across them and consumers poll every one. Lookupds that appear since it was
+// last called are added to running consumers, and those that have gone are
+// removed from them.
+func (t *NSQTransport) ResolveLookupd() error {
+	host, port, err := net.SplitHostPort(t.nsqLookupdHTTPAddr)
+	if err != nil {
+		return err
+	}
+	ips, err := net.LookupHost(host)
+	if err != nil {
+		return err
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip, port)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	old := t.lookupdAddrs()
+	// connect before disconnecting, as consumers refuse to drop their last
+	// lookupd
+	for _, addr := range addrs {
+		if contains(old, addr) {
+			continue
+		}
+		for c := range t.consumers {
+			if err := c.ConnectToNSQLookupd(addr); err != nil {
+				log.Println("COLONY\t could not consume from lookupd", addr+":", err)
+			}
+		}
+	}
+	for _, addr := range old {
+		if contains(addrs, addr) {
+			continue
+		}
+		for c := range t.consumers {
+			if err := c.DisconnectFromNSQLookupd(addr); err != nil {
+				log.Println("COLONY\t could not stop consuming from lookupd", addr+":", err)
+			}
+		}
+	}
+	t.lookupds = addrs
+	return nil
+}
+
+// lookupd returns the address of a lookupd to query.
+func (t *NSQTransport) lookupd() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if len(t.lookupds) == 0 {
+		return t.nsqLookupdHTTPAddr
+	}
+	return t.lookupds[rand.Intn(len(t.lookupds))]
+}
+
+// lookupdAddrs returns the address of every lookupd known to the transport.
+// t.mu must be held.
+func (t *NSQTransport) lookupdAddrs() []string {
+	if len(t.lookupds) == 0 {
+		return []string{t.nsqLookupdHTTPAddr}
+	}
+	return t.lookupds
+}
+
+// WithLookupdDNS has a service using NSQ treat the lookupd address given to
+// NewService as a DNS name resolving to every lookupd, and resolve it again
+// every interval so that lookupds can come and go without the service being
+// told their addresses.
+func WithLookupdDNS(interval time.Duration) Option {
+	return func(s *Service) {
+		s.lookupdDNS = interval
+	}
+}
+
+// lookupdResolver resolves t's lookupds until the service is closed.
+func (s Service) lookupdResolver(t *NSQTransport, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.lifecycle.done:
+			return
+		}
+		if err := t.ResolveLookupd(); err != nil {
+			log.Println("COLONY\t", s.Name, "could not resolve lookupds:", err)
+		}
+	}
+}
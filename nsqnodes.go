@@ -0,0 +1,65 @@
+package colony
+
+import (
+	"log"
+	"time"
+
+	"github.com/bitly/go-nsq"
+)
+
+// RefreshNodes asks lookupd for the nsqds registered with it, so that the
+// transport can fall over to those that joined the cluster since it was
+// created.
+func (t *NSQTransport) RefreshNodes() error {
+	nodes, err := lookupNodes(t.lookupd())
+	if err != nil {
+		return err
+	}
+	return t.addNodes(nodes)
+}
+
+// addNodes adds a failover connection to each of nodes the transport doesn't
+// already publish to.
+func (t *NSQTransport) addNodes(nodes []producer) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, node := range nodes {
+		addr := node.tcpAddr()
+		if t.known[addr] {
+			continue
+		}
+		p, err := nsq.NewProducer(addr, t.config)
+		if err != nil {
+			return err
+		}
+		t.known[addr] = true
+		// copied rather than appended to, as publish ranges over it unlocked
+		t.failover = append(t.failover[:len(t.failover):len(t.failover)], p)
+		log.Println("COLONY\t Using NSQD TCP:", addr, "for failover")
+	}
+	return nil
+}
+
+// WithNodePolling makes a service using NSQ ask lookupd for new nsqds every
+// interval, rather than only knowing those registered when it started.
+func WithNodePolling(interval time.Duration) Option {
+	return func(s *Service) {
+		s.nodePoll = interval
+	}
+}
+
+// nodePoller refreshes t's nsqds until the service is closed.
+func (s Service) nodePoller(t *NSQTransport, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.lifecycle.done:
+			return
+		}
+		if err := t.RefreshNodes(); err != nil {
+			log.Println("COLONY\t", s.Name, "could not refresh nsqd nodes:", err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package colony
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// TopicStats describes the state of a topic across every nsqd holding it.
+type TopicStats struct {
+	Name         string
+	Depth        int64 // messages waiting in the topic itself
+	MessageCount int64 // messages ever published to the topic
+	Paused       bool
+	Channels     []ChannelStats
+}
+
+// ChannelStats describes the state of one channel of a topic across every
+// nsqd holding it.
+type ChannelStats struct {
+	Name         string
+	Depth        int64 // messages waiting to be delivered
+	InFlight     int64 // messages delivered but not yet finished
+	Deferred     int64
+	MessageCount int64
+	RequeueCount int64
+	TimeoutCount int64
+	Clients      int
+	Paused       bool
+}
+
+type nsqdStats struct {
+	Topics []nsqdTopicStats
+}
+
+type nsqdTopicStats struct {
+	Topic_name    string
+	Depth         int64
+	Message_count int64
+	Paused        bool
+	Channels      []nsqdChannelStats
+}
+
+type nsqdChannelStats struct {
+	Channel_name    string
+	Depth           int64
+	In_flight_count int64
+	Deferred_count  int64
+	Message_count   int64
+	Requeue_count   int64
+	Timeout_count   int64
+	Clients         []json.RawMessage
+	Paused          bool
+}
+
+// nodes returns the HTTP addresses of every nsqd registered with lookupd.
+func (t *NSQTransport) nodes() ([]string, error) {
+	var n producers
+	if err := nsqCall("GET", t.lookupd(), "/nodes", nil, &n); err != nil {
+		return nil, errors.New("could not get list of nsqd nodes: " + err.Error())
+	}
+	var addrs []string
+	for _, p := range n.Producers {
+		addrs = append(addrs, p.Broadcast_address+":"+strconv.Itoa(p.Http_port))
+	}
+	return addrs, nil
+}
+
+// TopicStats returns the state of every topic, summed across all the nsqd
+// nodes registered with lookupd, in order of name.
+func (t *NSQTransport) TopicStats() ([]TopicStats, error) {
+	addrs, err := t.nodes()
+	if err != nil {
+		return nil, err
+	}
+	topics := make(map[string]*TopicStats)
+	for _, addr := range addrs {
+		var r nsqdStats
+		if err := nsqCall("GET", addr, "/stats", url.Values{"format": {"json"}}, &r); err != nil {
+			return nil, errors.New("could not get stats from nsqd " + addr + ": " + err.Error())
+		}
+		for _, nt := range r.Topics {
+			ts, ok := topics[nt.Topic_name]
+			if !ok {
+				ts = &TopicStats{Name: nt.Topic_name}
+				topics[nt.Topic_name] = ts
+			}
+			ts.Depth += nt.Depth
+			ts.MessageCount += nt.Message_count
+			ts.Paused = ts.Paused || nt.Paused
+			for _, nc := range nt.Channels {
+				ts.addChannel(nc)
+			}
+		}
+	}
+
+	out := make([]TopicStats, 0, len(topics))
+	for _, ts := range topics {
+		sort.Slice(ts.Channels, func(i, j int) bool { return ts.Channels[i].Name < ts.Channels[j].Name })
+		out = append(out, *ts)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+func (ts *TopicStats) addChannel(nc nsqdChannelStats) {
+	var cs *ChannelStats
+	for i := range ts.Channels {
+		if ts.Channels[i].Name == nc.Channel_name {
+			cs = &ts.Channels[i]
+		}
+	}
+	if cs == nil {
+		ts.Channels = append(ts.Channels, ChannelStats{Name: nc.Channel_name})
+		cs = &ts.Channels[len(ts.Channels)-1]
+	}
+	cs.Depth += nc.Depth
+	cs.InFlight += nc.In_flight_count
+	cs.Deferred += nc.Deferred_count
+	cs.MessageCount += nc.Message_count
+	cs.RequeueCount += nc.Requeue_count
+	cs.TimeoutCount += nc.Timeout_count
+	cs.Clients += len(nc.Clients)
+	cs.Paused = cs.Paused || nc.Paused
+}
@@ -0,0 +1,110 @@
+package colony
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+)
+
+// processedWindow is how many of the Messages of each content type marked
+// processed a service remembers.
+const processedWindow = 1000
+
+// processedSet is the most recent Messages of a content type marked
+// processed, by processedKey.
+type processedSet struct {
+	keys  map[string]bool
+	order []string // oldest first
+}
+
+// offsets keeps the Messages a service's consumers have processed, by content
+// type, and saves them in a Checkpointer.
+type offsets struct {
+	store Checkpointer
+	mu    sync.Mutex
+	byCT  map[string]*processedSet
+}
+
+// WithOffsets keeps track of the Messages the service has marked processed
+// with MarkProcessed, saving them in store. When the service restarts,
+// Messages among them that are delivered again are skipped. The last 1000
+// Messages of each content type are remembered. Since a producer's
+// MessageIDs start again when it restarts, a Message is known by its
+// producer, MessageID and Time together.
+func WithOffsets(store Checkpointer) Option {
+	return func(s *Service) {
+		s.offsets = &offsets{store: store, byCT: make(map[string]*processedSet)}
+	}
+}
+
+// processedKey identifies m among the Messages of its content type.
+func processedKey(m Message) string {
+	return m.Topic.ServiceName + "-" + m.Topic.ServiceID + "/" + string(m.MessageID) + "@" + strconv.FormatInt(m.Time.UnixNano(), 10)
+}
+
+// MarkProcessed records that m has been handled, so that it is skipped if it
+// is delivered again. It does nothing unless the service has WithOffsets.
+func (s Service) MarkProcessed(m Message) error {
+	o := s.offsets
+	if o == nil {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	set := o.load(s, m.ContentType)
+	key := processedKey(m)
+	if set.keys[key] {
+		return nil
+	}
+	set.keys[key] = true
+	set.order = append(set.order, key)
+	if len(set.order) > processedWindow {
+		delete(set.keys, set.order[0])
+		set.order = set.order[1:]
+	}
+	state, err := json.Marshal(set.order)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	return o.store.SaveCheckpoint(offsetsName(s, m.ContentType), state)
+}
+
+// processed reports whether m was marked processed before.
+func (s Service) processed(m Message) bool {
+	o := s.offsets
+	if o == nil {
+		return false
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.load(s, m.ContentType).keys[processedKey(m)]
+}
+
+// load returns the Messages of contentType marked processed, reading them from
+// the store the first time. o.mu must be held.
+func (o *offsets) load(s Service, contentType string) *processedSet {
+	set, ok := o.byCT[contentType]
+	if ok {
+		return set
+	}
+	set = &processedSet{keys: make(map[string]bool)}
+	state, err := o.store.LoadCheckpoint(offsetsName(s, contentType))
+	if err != nil {
+		log.Println("COLONY\t could not load the offsets of", contentType+":", err)
+	} else if state != nil {
+		if err := json.Unmarshal(state, &set.order); err != nil {
+			log.Println("COLONY\t could not read the offsets of", contentType+":", err)
+			set.order = nil
+		}
+	}
+	for _, key := range set.order {
+		set.keys[key] = true
+	}
+	o.byCT[contentType] = set
+	return set
+}
+
+func offsetsName(s Service, contentType string) string {
+	return "offsets-" + s.Name + "-" + topicContentType(contentType)
+}
@@ -0,0 +1,57 @@
+package colony
+
+// An Option configures a Service. Options are passed to NewService and are
+// applied before the service starts.
+type Option func(*Service)
+
+// WithSigner signs every Message the service emits or announces with the
+// supplied Signer.
+func WithSigner(signer Signer) Option {
+	return func(s *Service) {
+		s.signer = signer
+	}
+}
+
+// WithVerifier checks every Message the service receives with the supplied
+// Verifier. Messages that fail verification are sent to the service's
+// RejectHandler instead of its Handlers.
+func WithVerifier(verifier Verifier) Option {
+	return func(s *Service) {
+		s.verifier = verifier
+	}
+}
+
+// WithRejectHandler sets the function called with each Message the service
+// refuses to deliver. By default rejected messages are logged.
+func WithRejectHandler(h RejectHandler) Option {
+	return func(s *Service) {
+		s.rejectHandler = h
+	}
+}
+
+// WithDatacenter stamps every Message the service creates with the datacenter
+// it runs in, which Federations keep as the Message's OriginDC wherever it is
+// relayed.
+func WithDatacenter(dc string) Option {
+	return func(s *Service) {
+		s.datacenter = dc
+	}
+}
+
+// WithQuiet has NewService skip its banner, for programs whose standard
+// output is their result.
+func WithQuiet() Option {
+	return func(s *Service) {
+		s.quiet = true
+	}
+}
+
+// WithEphemeralResponses has the service's responses leave nothing behind
+// when it goes: its response channel is ephemeral, and its response topic is
+// deleted when it is closed if the transport is a ControlTransport. It suits
+// short-lived services such as command-line tools.
+func WithEphemeralResponses() Option {
+	return func(s *Service) {
+		s.ephemeral = true
+	}
+}
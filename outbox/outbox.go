@@ -0,0 +1,191 @@
+// Package outbox implements the transactional outbox pattern for colony
+// services. Messages are written to a SQL table in the same transaction as
+// the business data they describe, and a relay publishes them afterwards, so
+// a Message is emitted if and only if its transaction commits.
+//
+// The table needs an auto-incrementing integer id, a message column holding
+// JSON text and a nullable published_at timestamp. For PostgreSQL:
+//
+//	CREATE TABLE colony_outbox (
+//		id           BIGSERIAL PRIMARY KEY,
+//		message      TEXT NOT NULL,
+//		published_at TIMESTAMP NULL
+//	);
+//
+// A row whose message can't be decoded is moved to the Outbox's DeadTable,
+// which is made the same way, or if it has none is marked as published, so
+// that it doesn't hold up the rows after it.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/nytlabs/colony"
+)
+
+// DefaultTable is the table an Outbox uses unless told otherwise.
+const DefaultTable = "colony_outbox"
+
+// batchSize is how many Messages the relay publishes per query.
+const batchSize = 100
+
+// An Outbox stores Messages in a SQL table and relays them to the colony
+// through its Service. Run a single relay per table: concurrent relays would
+// publish the same Messages.
+type Outbox struct {
+	DB      *sql.DB
+	Service *colony.Service
+	// Table is the outbox table. It defaults to DefaultTable.
+	Table string
+	// Interval is how often the relay looks for new Messages. It defaults
+	// to a second.
+	Interval time.Duration
+	// Placeholder returns the SQL placeholder for the nth argument of a
+	// query, counting from 1. It defaults to ? as used by MySQL and SQLite;
+	// use Dollar for PostgreSQL.
+	Placeholder func(n int) string
+	// DeadTable is where rows whose message can't be decoded are moved. If
+	// it is empty they are left in Table, marked as published.
+	DeadTable string
+}
+
+// Dollar is a Placeholder for databases numbering their arguments, like
+// PostgreSQL.
+func Dollar(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (o *Outbox) table() string {
+	if o.Table == "" {
+		return DefaultTable
+	}
+	return o.Table
+}
+
+func (o *Outbox) arg(n int) string {
+	if o.Placeholder == nil {
+		return "?"
+	}
+	return o.Placeholder(n)
+}
+
+// Add writes m to the outbox as part of tx. It is emitted once tx commits
+// and the relay gets to it, and never if tx is rolled back.
+func (o *Outbox) Add(tx *sql.Tx, m colony.Message) error {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO "+o.table()+" (message) VALUES ("+o.arg(1)+")", string(body))
+	return err
+}
+
+// Run relays Messages from the outbox to the colony, in the order they were
+// added, until ctx is done. A Message is marked as published once it has been
+// emitted, so one emitted just before a crash may be emitted again.
+func (o *Outbox) Run(ctx context.Context) error {
+	interval := o.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := o.relay(ctx)
+			if err != nil {
+				log.Println("COLONY\t could not relay outbox:", err)
+			}
+			if err != nil || n < batchSize {
+				break
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// relay publishes a batch of unpublished Messages and returns how many rows
+// it got through, counting those it couldn't decode.
+func (o *Outbox) relay(ctx context.Context) (int, error) {
+	rows, err := o.DB.QueryContext(ctx, "SELECT id, message FROM "+o.table()+
+		" WHERE published_at IS NULL ORDER BY id LIMIT "+strconv.Itoa(batchSize))
+	if err != nil {
+		return 0, err
+	}
+	type pending struct {
+		id   int64
+		body string
+	}
+	var batch []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.body); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	published := 0
+	for _, p := range batch {
+		var m colony.Message
+		if err := json.Unmarshal([]byte(p.body), &m); err != nil {
+			log.Println("COLONY\t outbox row", p.id, "holds a message that can't be decoded:", err)
+			if err := o.bury(ctx, p.id, p.body); err != nil {
+				return published, err
+			}
+			published++
+			continue
+		}
+		if err := o.Service.Emit(m); err != nil {
+			return published, err
+		}
+		if err := o.markPublished(ctx, p.id); err != nil {
+			return published, err
+		}
+		published++
+	}
+	return published, nil
+}
+
+// markPublished marks row id as published.
+func (o *Outbox) markPublished(ctx context.Context, id int64) error {
+	_, err := o.DB.ExecContext(ctx, "UPDATE "+o.table()+" SET published_at = "+o.arg(1)+
+		" WHERE id = "+o.arg(2), time.Now().UTC(), id)
+	return err
+}
+
+// bury takes row id, holding body that can't be decoded, out of the relay's
+// way: moving it to the DeadTable if there is one, and marking it as
+// published otherwise.
+func (o *Outbox) bury(ctx context.Context, id int64, body string) error {
+	if o.DeadTable == "" {
+		return o.markPublished(ctx, id)
+	}
+	tx, err := o.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO "+o.DeadTable+" (message) VALUES ("+o.arg(1)+")", body)
+	if err == nil {
+		_, err = tx.ExecContext(ctx, "DELETE FROM "+o.table()+" WHERE id = "+o.arg(1), id)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
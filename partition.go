@@ -0,0 +1,50 @@
+package colony
+
+import (
+	"hash/fnv"
+	"strconv"
+)
+
+// WithPartitions splits the Messages of contentType the service emits across
+// n topics, by a hash of each Message's Key, so that several instances of a
+// consumer can each take some of the partitions with ConsumePartitions. All
+// the Messages with one Key go to the same partition, and arrive there in
+// order unless they are sent with different priorities. Consumers that don't
+// ask for partitions see none of them.
+func WithPartitions(contentType string, n int) Option {
+	return func(s *Service) {
+		if s.partitions == nil {
+			s.partitions = make(map[string]int)
+		}
+		s.partitions[contentType] = n
+	}
+}
+
+// Partition returns which of n partitions Messages with key are sent to.
+func Partition(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+// ConsumePartitions is Consume for a content type emitted WithPartitions,
+// taking only the given partitions of it.
+func (s Service) ConsumePartitions(contentType string, partitions []int, h Handler) error {
+	if partitions == nil {
+		partitions = []int{}
+	}
+	return s.consume(contentType, consumeOptions{partitions: partitions}, h)
+}
+
+// partitionTopic returns the topic partition of topic is sent on.
+func partitionTopic(topic string, partition int) string {
+	return topic + ".p" + strconv.Itoa(partition)
+}
+
+func partitionTopics(topic string, partitions []int) []string {
+	out := make([]string, len(partitions))
+	for i, p := range partitions {
+		out[i] = partitionTopic(topic, p)
+	}
+	return out
+}
@@ -0,0 +1,103 @@
+package colony
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// maxPooledBuffer is the largest buffer kept for reuse, so that one huge
+// Message doesn't pin its memory for good.
+const maxPooledBuffer = 1 << 20
+
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// marshalMessage encodes m as JSON into a pooled buffer, which the caller
+// hands back with releaseBuffer once it is done with the bytes.
+func marshalMessage(m Message) (*bytes.Buffer, error) {
+	b := bufferPool.Get().(*bytes.Buffer)
+	b.Reset()
+	if err := encodeEnvelope(b, m); err != nil {
+		releaseBuffer(b)
+		return nil, err
+	}
+	b.Truncate(b.Len() - 1) // the Encoder's newline
+	return b, nil
+}
+
+func releaseBuffer(b *bytes.Buffer) {
+	if b.Cap() <= maxPooledBuffer {
+		bufferPool.Put(b)
+	}
+}
+
+// scratch is the space a Message is decoded into by a consumer that reuses
+// payloads.
+type scratch struct {
+	raw     json.RawMessage // the payload as it is in the envelope
+	payload []byte
+}
+
+var scratchPool = sync.Pool{
+	New: func() interface{} { return new(scratch) },
+}
+
+// envelope decodes a Message while leaving its payload encoded.
+type envelope struct {
+	Message
+	Payload json.RawMessage
+}
+
+// decodeReusing decodes body into a Message whose Payload is held in pooled
+// scratch space, which the caller hands back with release once the Payload
+// is no longer used.
+func decodeReusing(body []byte) (Message, *scratch, error) {
+	sc := scratchPool.Get().(*scratch)
+	e := envelope{Payload: sc.raw[:0]}
+	if err := checkDecoded(&e.Message, decodeEnvelope(body, &e)); err != nil {
+		sc.release()
+		return Message{}, nil, err
+	}
+	sc.raw = e.Payload
+	m := e.Message
+	if len(e.Payload) >= 2 && e.Payload[0] == '"' {
+		src := e.Payload[1 : len(e.Payload)-1]
+		n := base64.StdEncoding.DecodedLen(len(src))
+		if cap(sc.payload) < n {
+			sc.payload = make([]byte, n)
+		}
+		n, err := base64.StdEncoding.Decode(sc.payload[:n], src)
+		if err != nil {
+			sc.release()
+			return Message{}, nil, fmt.Errorf("%w: bad payload: %v", ErrMalformed, err)
+		}
+		m.Payload = sc.payload[:n]
+	}
+	return m, sc, nil
+}
+
+func (sc *scratch) release() {
+	if cap(sc.raw) <= maxPooledBuffer && cap(sc.payload) <= maxPooledBuffer {
+		scratchPool.Put(sc)
+	}
+}
+
+// decode unmarshals body, into scratch space if the consumer reuses payloads.
+// The scratch space, if any, is to be released once the Message is acked.
+func (c queueConsumer) decode(body []byte) (Message, *scratch, error) {
+	if !c.opts.ReusePayloads || c.opts.Ack != AckManual || !isJSONBody(body) {
+		var m Message
+		err := unmarshalMessage(body, &m)
+		return m, nil, err
+	}
+	m, sc, err := decodeReusing(body)
+	if err == nil && m.Chunk != nil {
+		// the reassembler holds on to the parts
+		m.Payload = append([]byte(nil), m.Payload...)
+	}
+	return m, sc, err
+}
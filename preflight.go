@@ -0,0 +1,184 @@
+package colony
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// preflightTopic is created, and deleted again where the transport allows,
+// to check that the service may create topics.
+const preflightTopic = "colony-preflight"
+
+// maxClockSkew is how far the service's clock may be from nsqd's before
+// Preflight fails. nsqd reports its time to the second.
+const maxClockSkew = 2 * time.Second
+
+// envelopeOverhead is roughly how many bytes a Message's JSON envelope adds
+// to its base64 encoded payload.
+const envelopeOverhead = 4 * 1024
+
+// A PreflightReport is the outcome of a service's Preflight checks.
+type PreflightReport struct {
+	Checks []PreflightCheck
+	// ClockSkew is how far the service's clock is ahead of nsqd's.
+	ClockSkew time.Duration `json:",omitempty"`
+	// MaxMessageSize is the largest message nsqd accepts, in bytes.
+	MaxMessageSize int64 `json:",omitempty"`
+}
+
+// A PreflightCheck is one of the checks made by Preflight.
+type PreflightCheck struct {
+	Name   string
+	OK     bool
+	Detail string // what was found, or why the check failed
+}
+
+// OK reports whether every check passed.
+func (r PreflightReport) OK() bool {
+	for _, c := range r.Checks {
+		if !c.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// Err returns an error describing the checks that failed, or nil if every
+// check passed.
+func (r PreflightReport) Err() error {
+	var failed []string
+	for _, c := range r.Checks {
+		if !c.OK {
+			failed = append(failed, c.Name+": "+c.Detail)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return errors.New("preflight failed: " + strings.Join(failed, "; "))
+}
+
+func (r *PreflightReport) add(name string, err error, detail string) {
+	c := PreflightCheck{Name: name, OK: err == nil, Detail: detail}
+	if err != nil {
+		c.Detail = err.Error()
+	}
+	r.Checks = append(r.Checks, c)
+}
+
+// Preflight checks that the service can work with its colony, so that a
+// misconfigured deployment fails when it starts rather than when it first
+// emits or consumes: that lookupd answers, that the service may create
+// topics, that its clock agrees with nsqd's, and that nsqd accepts messages
+// as large as the service's chunk size. Checks other than topic creation are
+// only made on NSQTransports. Preflight stops early if ctx is done.
+func (s Service) Preflight(ctx context.Context) PreflightReport {
+	var r PreflightReport
+	t := unwrapNSQ(s.transport)
+	if t != nil {
+		_, err := t.get(ctx, t.lookupd(), "/ping")
+		r.add("lookupd", err, "lookupd "+t.lookupd()+" answers")
+	}
+	if ctx.Err() != nil {
+		r.add("canceled", ctx.Err(), "")
+		return r
+	}
+
+	err := s.transport.CreateTopic(preflightTopic)
+	r.add("create topic", err, "may create topics")
+	if ct, ok := s.transport.(ControlTransport); ok && err == nil {
+		ct.DeleteTopic(preflightTopic)
+	}
+	if t == nil {
+		return r
+	}
+
+	sent := time.Now()
+	resp, err := t.get(ctx, t.nsqdHTTPAddr, "/ping")
+	if err == nil {
+		var date time.Time
+		date, err = http.ParseTime(resp.Header.Get("Date"))
+		if err == nil {
+			// nsqd's time is truncated to the second, so compare from
+			// halfway through it
+			r.ClockSkew = sent.Add(time.Since(sent) / 2).Sub(date.Add(time.Second / 2))
+			if r.ClockSkew > maxClockSkew || r.ClockSkew < -maxClockSkew {
+				err = fmt.Errorf("clock is %v from nsqd's", r.ClockSkew)
+			}
+		}
+	}
+	r.add("clock skew", err, fmt.Sprintf("clock is within %v of nsqd's", maxClockSkew))
+
+	resp, err = t.get(ctx, t.nsqdHTTPAddr, "/config/max_msg_size")
+	var detail string
+	if err == nil {
+		var body []byte
+		body, err = ioutil.ReadAll(resp.Body)
+		if err == nil {
+			err = nsqDecode(resp.StatusCode, body, &r.MaxMessageSize)
+		}
+	}
+	if err == nil {
+		need := int64(s.chunkSize)*4/3 + envelopeOverhead
+		switch {
+		case s.chunkSize <= 0:
+			detail = fmt.Sprintf("chunking is off; payloads over about %d bytes will be refused", (r.MaxMessageSize-envelopeOverhead)*3/4)
+		case need > r.MaxMessageSize:
+			err = fmt.Errorf("chunks of %d bytes need messages of about %d bytes but nsqd accepts %d", s.chunkSize, need, r.MaxMessageSize)
+		default:
+			detail = fmt.Sprintf("nsqd accepts messages of %d bytes", r.MaxMessageSize)
+		}
+	}
+	r.add("message size", err, detail)
+	return r
+}
+
+// Preflight makes the checks of Service.Preflight for the service NewService
+// would create with nsqLookupd and opts, without creating it, so that a
+// deployment can be checked before it starts rather than failing on log.Fatal.
+// It returns an error if the colony can't be reached or a check fails.
+func Preflight(ctx context.Context, nsqLookupd string, opts ...Option) error {
+	s := newService("preflight", "0", opts)
+	if err := s.connect(nsqLookupd); err != nil {
+		return err
+	}
+	return s.Preflight(ctx).Err()
+}
+
+// get sends a GET for path to the HTTP server at addr, returning the response
+// with its body unread but buffered.
+func (t *NSQTransport) get(ctx context.Context, addr, path string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", "http://"+addr+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", nsqAccept)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s%s: %s", addr, path, resp.Status)
+	}
+	resp.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+	return resp, nil
+}
+
+// unwrapNSQ returns the NSQTransport under t, or nil if it isn't one.
+func unwrapNSQ(t Transport) *NSQTransport {
+	if n, ok := t.(namespaceTransport); ok {
+		t = n.t
+	}
+	nt, _ := t.(*NSQTransport)
+	return nt
+}
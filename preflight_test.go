@@ -0,0 +1,16 @@
+package colony
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPreflight(t *testing.T) {
+	if err := Preflight(context.Background(), "", WithTransport(NewMemoryTransport())); err != nil {
+		t.Fatal(err)
+	}
+	// nothing listens on port 1
+	if err := Preflight(context.Background(), "127.0.0.1:1"); err == nil {
+		t.Fatal("no error for an unreachable lookupd")
+	}
+}
@@ -0,0 +1,79 @@
+package colony
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// presenceTopic carries the heartbeats of every service that sends them, and
+// the goodbyes of services being closed.
+const presenceTopic = "colony-presence"
+
+// heartbeatTTL is how many heartbeat intervals a service stays live for
+// without a heartbeat, so that one lost heartbeat doesn't count it as gone.
+const heartbeatTTL = 3
+
+// WithHeartbeat makes the service send a heartbeat carrying its
+// ServiceDescriptor every interval, so that Registries can tell which instances
+// are alive. A service counts as live until three intervals pass without a
+// heartbeat.
+func WithHeartbeat(interval time.Duration) Option {
+	return func(s *Service) {
+		s.describer.interval = interval
+	}
+}
+
+// heartbeat sends the service's heartbeats until it is closed.
+func (s Service) heartbeat(interval time.Duration) {
+	s.transport.CreateTopic(presenceTopic)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.presence("heartbeat", s.Describe()); err != nil {
+			log.Println("COLONY\t", s.Name, "could not send heartbeat:", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-s.lifecycle.done:
+			return
+		}
+	}
+}
+
+// goodbye tells the colony that the service is leaving.
+func (s Service) goodbye() error {
+	desc := s.Describe()
+	desc.Leaving = true
+	desc.TTL = 0
+	return s.presence("leaving", desc)
+}
+
+// presence sends desc on the presence topic as contentType.
+func (s Service) presence(contentType string, desc ServiceDescriptor) error {
+	s.register(desc)
+	payload, err := json.Marshal(desc)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	m := Message{
+		FromName:    s.Name,
+		Payload:     payload,
+		Time:        time.Now(),
+		ContentType: contentType,
+		Topic: topic{
+			ServiceName: s.Name,
+			ServiceID:   s.ID,
+			ContentType: contentType,
+		},
+	}
+	m, err = s.sign(m)
+	if err != nil {
+		return err
+	}
+	out, err := json.Marshal(m)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	return s.transport.Publish(presenceTopic, out)
+}
@@ -0,0 +1,65 @@
+package colony
+
+// The priorities a Message can be sent with. Each priority of a content type
+// is sent on a lane of its own, a topic named after the content type's topic,
+// and consumers take Messages waiting in higher priority lanes first.
+const (
+	PriorityLow    = -1
+	PriorityNormal = 0
+	PriorityHigh   = 1
+)
+
+// laneTopic returns the topic Messages of topic with priority are sent on.
+func laneTopic(topic string, priority int) string {
+	switch {
+	case priority > PriorityNormal:
+		return topic + ".high"
+	case priority < PriorityNormal:
+		return topic + ".low"
+	}
+	return topic
+}
+
+// normalLane is the index of the normal priority lane in laneTopics.
+const normalLane = 1
+
+// laneTopics returns the topics of every priority lane of topic, highest
+// first.
+func laneTopics(topic string) []string {
+	return []string{
+		laneTopic(topic, PriorityHigh),
+		topic,
+		laneTopic(topic, PriorityLow),
+	}
+}
+
+// drainLanes passes the Messages from lanes, which are in order of priority,
+// to out until done is closed. A Message waiting in a lane is passed on before
+// any waiting in the lanes after it.
+func drainLanes(lanes []chan Message, out chan<- Message, done <-chan struct{}) {
+	high, normal, low := lanes[0], lanes[1], lanes[2]
+	for {
+		var m Message
+		select {
+		case m = <-high:
+		default:
+			select {
+			case m = <-high:
+			case m = <-normal:
+			default:
+				select {
+				case m = <-high:
+				case m = <-normal:
+				case m = <-low:
+				case <-done:
+					return
+				}
+			}
+		}
+		select {
+		case out <- m:
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Package prommetrics provides colony Metrics exported to Prometheus.
+package prommetrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics registers a Prometheus collector for each name the first time it is
+// measured, labelled by the keys of the tags it was measured with. A name must
+// always be measured with the same tag keys.
+type Metrics struct {
+	Registerer prometheus.Registerer
+
+	mu         sync.Mutex
+	counters   map[string]*prometheus.CounterVec
+	histograms map[string]*prometheus.HistogramVec
+	gauges     map[string]*prometheus.GaugeVec
+}
+
+// New returns Metrics registering its collectors with r, or with the default
+// registry if r is nil.
+func New(r prometheus.Registerer) *Metrics {
+	if r == nil {
+		r = prometheus.DefaultRegisterer
+	}
+	return &Metrics{
+		Registerer: r,
+		counters:   make(map[string]*prometheus.CounterVec),
+		histograms: make(map[string]*prometheus.HistogramVec),
+		gauges:     make(map[string]*prometheus.GaugeVec),
+	}
+}
+
+// Counter adds delta to the counter for name.
+func (m *Metrics) Counter(name string, delta int64, tags map[string]string) {
+	m.mu.Lock()
+	c, ok := m.counters[name]
+	if !ok {
+		c = prometheus.NewCounterVec(prometheus.CounterOpts{Name: promName(name) + "_total", Help: name}, labelNames(tags))
+		m.register(c)
+		m.counters[name] = c
+	}
+	m.mu.Unlock()
+	c.With(tags).Add(float64(delta))
+}
+
+// byteBuckets are the buckets of histograms of sizes, from 64 bytes to 16MB.
+var byteBuckets = prometheus.ExponentialBuckets(64, 4, 10)
+
+// Histogram observes value in the histogram for name. Names ending in _bytes,
+// such as colony.payload_bytes, have buckets suited to sizes; others have
+// Prometheus' default buckets, which suit durations in seconds.
+func (m *Metrics) Histogram(name string, value float64, tags map[string]string) {
+	m.mu.Lock()
+	h, ok := m.histograms[name]
+	if !ok {
+		opts := prometheus.HistogramOpts{Name: promName(name), Help: name}
+		if strings.HasSuffix(name, "_bytes") {
+			opts.Buckets = byteBuckets
+		}
+		h = prometheus.NewHistogramVec(opts, labelNames(tags))
+		m.register(h)
+		m.histograms[name] = h
+	}
+	m.mu.Unlock()
+	h.With(tags).Observe(value)
+}
+
+// Gauge sets the gauge for name to value.
+func (m *Metrics) Gauge(name string, value float64, tags map[string]string) {
+	m.mu.Lock()
+	g, ok := m.gauges[name]
+	if !ok {
+		g = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: promName(name), Help: name}, labelNames(tags))
+		m.register(g)
+		m.gauges[name] = g
+	}
+	m.mu.Unlock()
+	g.With(tags).Set(value)
+}
+
+// register registers c. Should another collector already have its name, c
+// is left unregistered, and so unexported, rather than failing measurements.
+func (m *Metrics) register(c prometheus.Collector) {
+	m.Registerer.Register(c)
+}
+
+// promName turns a dotted colony name into a Prometheus one.
+func promName(name string) string {
+	return strings.NewReplacer(".", "_", "-", "_").Replace(name)
+}
+
+func labelNames(tags map[string]string) []string {
+	names := make([]string, 0, len(tags))
+	for k := range tags {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
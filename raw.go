@@ -0,0 +1,39 @@
+package colony
+
+// ConsumeRaw calls h with the body of every message on channel of topic, a
+// plain NSQ topic rather than a colony content type, so that a service can
+// take in what producers outside the colony publish. Bodies reach h as they
+// were published, without a colony envelope; a message for which h returns an
+// error is delivered again later. The topic is outside the service's
+// namespace, if it has one. Delivery stops when the returned Subscription is
+// stopped or the service is closed.
+func (s Service) ConsumeRaw(topic, channel string, h func(body []byte) error) (Subscription, error) {
+	t := s.rawTransport()
+	h = s.chaotic(topic, h)
+	if ct, ok := t.(ConcurrentTransport); ok && s.handlerConcurrency > 1 {
+		return s.track(ct.SubscribeConcurrent(topic, channel, s.handlerConcurrency, h))
+	}
+	return s.track(t.Subscribe(topic, channel, h))
+}
+
+// EmitRaw publishes body on topic, a plain NSQ topic rather than a colony
+// content type, for consumers outside the colony such as nsq_to_file. The body
+// is sent as it is, without a colony envelope, through the service's
+// transport, and is retried according to its RetryPolicy; a RetryPolicy's
+// OnGiveUp is passed a Message with topic as its ContentType and body as its
+// Payload. Raw messages are neither coalesced nor spooled. The topic is
+// outside the service's namespace, if it has one.
+func (s Service) EmitRaw(topic string, body []byte) error {
+	if s.lifecycle.isClosed() {
+		return ErrClosed
+	}
+	return s.retryingOn(s.rawTransport(), topic, Message{ContentType: topic, Payload: body}, body)
+}
+
+// rawTransport returns the service's transport without its namespace.
+func (s Service) rawTransport() Transport {
+	if n, ok := s.transport.(namespaceTransport); ok {
+		return n.t
+	}
+	return s.transport
+}
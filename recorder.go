@@ -0,0 +1,119 @@
+package colony
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// A Recording is a Message as it was received by a Recorder.
+type Recording struct {
+	Received time.Time
+	Message  Message
+}
+
+// A Recorder writes every Message of some content types to a stream of JSON
+// Recordings, one per line, so traffic can be replayed later with a Replayer.
+type Recorder struct {
+	Service *Service
+
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewRecorder returns a Recorder writing to w.
+func NewRecorder(s *Service, w io.Writer) *Recorder {
+	return &Recorder{
+		Service: s,
+		enc:     json.NewEncoder(w),
+	}
+}
+
+// Record starts recording Messages of contentTypes. Offloaded payloads are
+// fetched so that recordings are self-contained.
+func (r *Recorder) Record(contentTypes ...string) {
+	for _, contentType := range contentTypes {
+		go r.record(contentType)
+	}
+}
+
+func (r *Recorder) record(contentType string) {
+	err := r.Service.Consume(contentType, func(msgs <-chan Message) error {
+		for m := range msgs {
+			payload, err := m.FetchPayload()
+			if err != nil {
+				log.Println("COLONY\t could not record", contentType, "message", m.MessageID+":", err)
+				continue
+			}
+			m.Payload = payload
+			m.PayloadRef = ""
+			r.mu.Lock()
+			err = r.enc.Encode(Recording{Received: time.Now(), Message: m})
+			r.mu.Unlock()
+			if err != nil {
+				log.Println("COLONY\t could not record", contentType, "message", m.MessageID+":", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("COLONY\t could not record", contentType+":", err)
+	}
+}
+
+// A Replayer re-emits recorded Messages from its service.
+type Replayer struct {
+	Service *Service
+	// Speed scales the gaps between Messages: 1 replays at the original
+	// speed, 2 twice as fast. 0 replays as fast as possible.
+	Speed float64
+}
+
+// Replay emits the Recordings read from r in order, keeping their original
+// spacing adjusted by the Replayer's Speed. Each Message is emitted as a new
+// Message from the Replayer's service with the recorded content type and
+// payload, and each content type is announced before its first Message.
+func (p *Replayer) Replay(ctx context.Context, r io.Reader) error {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	announced := make(map[string]bool)
+	var last time.Time
+	for {
+		var rec Recording
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !last.IsZero() && p.Speed > 0 {
+			gap := time.Duration(float64(rec.Received.Sub(last)) / p.Speed)
+			select {
+			case <-time.After(gap):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		last = rec.Received
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		contentType := rec.Message.ContentType
+		if !announced[contentType] {
+			if err := p.Service.Announce(contentType); err != nil {
+				return err
+			}
+			announced[contentType] = true
+		}
+		m := p.Service.NewMessage(contentType, rec.Message.Payload)
+		if err := p.Service.Emit(m); err != nil {
+			return err
+		}
+	}
+}
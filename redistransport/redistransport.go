@@ -0,0 +1,150 @@
+// Package redistransport provides a colony Transport backed by Redis Streams,
+// for small deployments that would rather not run NSQ.
+//
+// Each topic is a stream and each colony channel a consumer group on it.
+// Messages are acknowledged with XACK once their handler succeeds; messages
+// whose handler fails stay pending and are claimed again once they have been
+// idle for ClaimIdle.
+package redistransport
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nytlabs/colony"
+	"github.com/redis/go-redis/v9"
+)
+
+// topicsKey is the set holding the name of every colony topic.
+const topicsKey = "colony:topics"
+
+// Transport is a colony Transport over Redis Streams.
+type Transport struct {
+	client redis.UniversalClient
+
+	// MaxLen caps the length of each stream, approximately. 0 leaves streams
+	// untrimmed.
+	MaxLen int64
+	// ClaimIdle is how long a message may stay unacknowledged before it is
+	// delivered again.
+	ClaimIdle time.Duration
+}
+
+// New returns a Transport using client.
+func New(client redis.UniversalClient) *Transport {
+	return &Transport{
+		client:    client,
+		ClaimIdle: 30 * time.Second,
+	}
+}
+
+// CreateTopic records topic in the set of colony topics.
+func (t *Transport) CreateTopic(topic string) error {
+	return t.client.SAdd(context.Background(), topicsKey, topic).Err()
+}
+
+// Publish appends body to the stream named topic.
+func (t *Transport) Publish(topic string, body []byte) error {
+	if err := t.CreateTopic(topic); err != nil {
+		return err
+	}
+	return t.client.XAdd(context.Background(), &redis.XAddArgs{
+		Stream: topic,
+		MaxLen: t.MaxLen,
+		Approx: t.MaxLen > 0,
+		Values: map[string]interface{}{"body": body},
+	}).Err()
+}
+
+type subscription struct {
+	cancel context.CancelFunc
+}
+
+func (s subscription) Stop() {
+	s.cancel()
+}
+
+// Subscribe reads the stream named topic as a member of the consumer group
+// named channel. Like an NSQ channel, the first group on a stream receives the
+// messages already in it and later groups only receive new ones.
+func (t *Transport) Subscribe(topic, channel string, h func(body []byte) error) (colony.Subscription, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	start := "$"
+	groups, err := t.client.XInfoGroups(ctx, topic).Result()
+	if err != nil || len(groups) == 0 {
+		start = "0"
+	}
+	err = t.client.XGroupCreateMkStream(ctx, topic, channel, start).Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		cancel()
+		return nil, err
+	}
+	consumer := channel + "-" + strconv.FormatInt(rand.Int63(), 36)
+	go t.consume(ctx, topic, channel, consumer, h)
+	return subscription{cancel}, nil
+}
+
+func (t *Transport) consume(ctx context.Context, topic, group, consumer string, h func(body []byte) error) {
+	lastClaim := time.Now()
+	for ctx.Err() == nil {
+		var msgs []redis.XMessage
+		if time.Since(lastClaim) > t.ClaimIdle {
+			claimed, _, err := t.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+				Stream:   topic,
+				Group:    group,
+				Consumer: consumer,
+				MinIdle:  t.ClaimIdle,
+				Start:    "0-0",
+				Count:    16,
+			}).Result()
+			if err == nil {
+				msgs = claimed
+			}
+			lastClaim = time.Now()
+		}
+		if len(msgs) == 0 {
+			streams, err := t.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+				Group:    group,
+				Consumer: consumer,
+				Streams:  []string{topic, ">"},
+				Count:    16,
+				Block:    time.Second,
+			}).Result()
+			if err == redis.Nil {
+				continue
+			}
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Println("COLONY\t reading", topic, "failed:", err)
+					time.Sleep(time.Second)
+				}
+				continue
+			}
+			for _, stream := range streams {
+				msgs = append(msgs, stream.Messages...)
+			}
+		}
+		for _, m := range msgs {
+			body, _ := m.Values["body"].(string)
+			if err := h([]byte(body)); err != nil {
+				continue
+			}
+			t.client.XAck(ctx, topic, group, m.ID)
+		}
+	}
+}
+
+// Discover returns the set of colony topics.
+func (t *Transport) Discover() ([]string, error) {
+	topics, err := t.client.SMembers(context.Background(), topicsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
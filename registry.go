@@ -0,0 +1,414 @@
+package colony
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A ServiceDescriptor describes a running service. One is carried as the
+// payload of every announcement, so a Registry can assemble a picture of the
+// whole colony.
+type ServiceDescriptor struct {
+	Name     string
+	ID       string
+	Version  string              `json:",omitempty"`
+	Produces []string            // content types announced, in order
+	Consumes []string            // content types being consumed, in order
+	Metadata map[string]string   `json:",omitempty"`
+	Docs     map[string]string   `json:",omitempty"` // what the content types produced mean
+	Formats  map[string][]Format `json:",omitempty"` // Formats produced, by content type
+	Accepts  map[string][]Format `json:",omitempty"` // Formats consumed, by content type
+	Time     time.Time           // when the service last announced or sent a heartbeat
+	// TTL is how long the service counts as live after Time. It is only
+	// set by services sending heartbeats.
+	TTL time.Duration `json:",omitempty"`
+	// Leaving is set when the service has been closed. Services that stop
+	// without it being set have crashed or lost touch with the colony.
+	Leaving bool `json:",omitempty"`
+}
+
+// describer keeps track of what goes into a service's ServiceDescriptor.
+type describer struct {
+	mu       sync.Mutex
+	version  string
+	metadata map[string]string
+	docs     map[string]string
+	produces map[string]bool
+	consumes map[string]int
+	formats  map[string][]Format
+	accepts  map[string][]Format
+	interval time.Duration // between heartbeats, if any
+}
+
+func newDescriber() *describer {
+	return &describer{
+		metadata: make(map[string]string),
+		docs:     make(map[string]string),
+		produces: make(map[string]bool),
+		consumes: make(map[string]int),
+		formats:  make(map[string][]Format),
+		accepts:  make(map[string][]Format),
+	}
+}
+
+// WithVersion sets the version the service describes itself as running.
+func WithVersion(version string) Option {
+	return func(s *Service) {
+		s.describer.version = version
+	}
+}
+
+// WithMetadata adds key and value to the instance metadata the service
+// describes itself with, such as its host or region.
+func WithMetadata(key, value string) Option {
+	return func(s *Service) {
+		s.describer.metadata[key] = value
+	}
+}
+
+func (d *describer) produce(contentType string) {
+	d.mu.Lock()
+	d.produces[contentType] = true
+	d.mu.Unlock()
+}
+
+// consume counts a consumer of contentType starting, or stopping when delta
+// is negative.
+func (d *describer) consume(contentType string, delta int) {
+	d.mu.Lock()
+	d.consumes[contentType] += delta
+	if d.consumes[contentType] <= 0 {
+		delete(d.consumes, contentType)
+	}
+	d.mu.Unlock()
+}
+
+// Describe returns the service's current ServiceDescriptor.
+func (s Service) Describe() ServiceDescriptor {
+	d := s.describer
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	desc := ServiceDescriptor{
+		Name:     s.Name,
+		ID:       s.ID,
+		Version:  d.version,
+		Produces: make([]string, 0, len(d.produces)),
+		Consumes: make([]string, 0, len(d.consumes)),
+		Time:     time.Now(),
+		TTL:      heartbeatTTL * d.interval,
+	}
+	for contentType := range d.produces {
+		desc.Produces = append(desc.Produces, contentType)
+	}
+	for contentType := range d.consumes {
+		desc.Consumes = append(desc.Consumes, contentType)
+	}
+	sort.Strings(desc.Produces)
+	sort.Strings(desc.Consumes)
+	for contentType, doc := range d.docs {
+		if d.produces[contentType] {
+			if desc.Docs == nil {
+				desc.Docs = make(map[string]string)
+			}
+			desc.Docs[contentType] = doc
+		}
+	}
+	desc.Formats = copyFormats(d.formats)
+	desc.Accepts = copyFormats(d.accepts)
+	if len(d.metadata) > 0 {
+		desc.Metadata = make(map[string]string, len(d.metadata))
+		for k, v := range d.metadata {
+			desc.Metadata[k] = v
+		}
+	}
+	return desc
+}
+
+func copyFormats(m map[string][]Format) map[string][]Format {
+	if len(m) == 0 {
+		return nil
+	}
+	out := make(map[string][]Format, len(m))
+	for contentType, formats := range m {
+		out[contentType] = append([]Format(nil), formats...)
+	}
+	return out
+}
+
+// A Registry assembles the ServiceDescriptors announced in a colony. Each
+// service's entry is replaced by its latest announcement or heartbeat, so what
+// it consumes is as of the last time it announced or sent a heartbeat.
+type Registry struct {
+	mu       sync.Mutex
+	services map[string]ServiceDescriptor
+	updated  chan struct{} // closed and replaced whenever services changes
+}
+
+// NewRegistry returns a Registry that follows announcements and heartbeats
+// using s. Announcements made before it starts are not seen.
+func NewRegistry(s *Service) *Registry {
+	r := &Registry{
+		services: make(map[string]ServiceDescriptor),
+		updated:  make(chan struct{}),
+	}
+	channel := fmt.Sprintf("%s-%s-registry-%x#ephemeral", s.Name, s.ID, s.randInt63())
+	accept := s.accept
+	follow := func(body []byte) error {
+		var m Message
+		if err := json.Unmarshal(body, &m); err != nil {
+			return nil
+		}
+		if !accept(m) {
+			return nil
+		}
+		var desc ServiceDescriptor
+		if err := json.Unmarshal(m.Payload, &desc); err != nil {
+			// announced by a service too old to describe itself
+			desc = ServiceDescriptor{
+				Name:     m.Topic.ServiceName,
+				ID:       m.Topic.ServiceID,
+				Produces: []string{m.ContentType},
+				Time:     m.Time,
+			}
+		}
+		r.add(desc)
+		s.topics.announced(desc)
+		return nil
+	}
+	for _, topic := range []string{"colony-announce", presenceTopic} {
+		if _, err := s.subscribe(topic, channel, follow); err != nil {
+			log.Println("COLONY\t registry could not follow", topic+":", err)
+		}
+	}
+	return r
+}
+
+func (r *Registry) add(desc ServiceDescriptor) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := desc.Name + "-" + desc.ID
+	if old, ok := r.services[key]; ok && old.Time.After(desc.Time) {
+		return
+	}
+	r.services[key] = desc
+	close(r.updated)
+	r.updated = make(chan struct{})
+}
+
+// changed returns a channel that is closed the next time the registry changes.
+func (r *Registry) changed() <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.updated
+}
+
+// Services returns every service in the registry, in order of name and ID.
+func (r *Registry) Services() []ServiceDescriptor {
+	return r.filter(func(ServiceDescriptor) bool { return true })
+}
+
+// Live returns the services that have sent a heartbeat within their TTL.
+// Services that don't send heartbeats are never included.
+func (r *Registry) Live() []ServiceDescriptor {
+	now := time.Now()
+	return r.filter(func(d ServiceDescriptor) bool { return d.TTL > 0 && now.Sub(d.Time) < d.TTL })
+}
+
+// Departed returns the services that have said goodbye.
+func (r *Registry) Departed() []ServiceDescriptor {
+	return r.filter(func(d ServiceDescriptor) bool { return d.Leaving })
+}
+
+// Lookup returns the descriptor of the service with name and id.
+func (r *Registry) Lookup(name, id string) (ServiceDescriptor, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	desc, ok := r.services[name+"-"+id]
+	return desc, ok
+}
+
+// Producers returns the services producing contentType that haven't departed.
+// A versioned contentType can have a constraint, as with Consume.
+func (r *Registry) Producers(contentType string) []ServiceDescriptor {
+	return r.filter(func(d ServiceDescriptor) bool {
+		if d.Leaving {
+			return false
+		}
+		for _, produced := range d.Produces {
+			if matchContentType(contentType, produced) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Consumers returns the services consuming contentType that haven't departed,
+// including those consuming it through a version constraint.
+func (r *Registry) Consumers(contentType string) []ServiceDescriptor {
+	return r.filter(func(d ServiceDescriptor) bool {
+		if d.Leaving {
+			return false
+		}
+		for _, consumed := range d.Consumes {
+			if matchContentType(consumed, contentType) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// topics returns the topics of contentType produced by services in the
+// registry.
+func (r *Registry) topics(contentType string) []string {
+	var out []string
+	for _, desc := range r.Producers(contentType) {
+		for _, produced := range desc.Produces {
+			if matchContentType(contentType, produced) {
+				out = append(out, topic{desc.Name, desc.ID, produced}.getName())
+			}
+		}
+	}
+	return out
+}
+
+func (r *Registry) filter(keep func(ServiceDescriptor) bool) []ServiceDescriptor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []ServiceDescriptor
+	for _, desc := range r.services {
+		if keep(desc) {
+			out = append(out, desc)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// Registry returns the service's Registry, which has followed announcements
+// since the service started.
+func (s Service) Registry() *Registry {
+	return s.registry
+}
+
+// ProducerInfo describes a service producing a content type on a topic.
+type ProducerInfo struct {
+	Name  string
+	ID    string
+	Topic string
+	// Descriptor is what the service last announced about itself, or nil
+	// if it hasn't announced since this service started.
+	Descriptor *ServiceDescriptor
+}
+
+// Discover returns the services producing contentType, found from the topics
+// known to the transport and from announcements, in order of name and ID. A
+// service producing several versions of contentType is listed once for each.
+// Services that have said goodbye are left out. An empty result means that
+// nothing is producing contentType yet.
+func (s Service) Discover(contentType string) ([]ProducerInfo, error) {
+	topics, err := s.discoverTopics(contentType)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]*ProducerInfo)
+	for _, topic := range topics {
+		name, id, ct, ok := SplitTopic(topic)
+		if !ok || !matchContentType(contentType, ct) {
+			continue
+		}
+		if desc, ok := s.registry.Lookup(name, id); ok && desc.Leaving {
+			continue
+		}
+		found[topic] = &ProducerInfo{Name: name, ID: id, Topic: topic}
+	}
+	// lookupd can lag behind announcements
+	for _, desc := range s.registry.Producers(contentType) {
+		desc := desc
+		for _, produced := range desc.Produces {
+			if !matchContentType(contentType, produced) {
+				continue
+			}
+			name := topic{desc.Name, desc.ID, produced}.getName()
+			p, ok := found[name]
+			if !ok {
+				p = &ProducerInfo{Name: desc.Name, ID: desc.ID, Topic: name}
+				found[name] = p
+			}
+			p.Descriptor = &desc
+		}
+	}
+	for _, p := range found {
+		if p.Descriptor == nil {
+			if desc, ok := s.registry.Lookup(p.Name, p.ID); ok {
+				p.Descriptor = &desc
+			}
+		}
+	}
+
+	out := make([]ProducerInfo, 0, len(found))
+	for _, p := range found {
+		out = append(out, *p)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		if out[i].ID != out[j].ID {
+			return out[i].ID < out[j].ID
+		}
+		return out[i].Topic < out[j].Topic
+	})
+	return out, nil
+}
+
+// discoverInterval is how often WaitFor looks for producers between
+// announcements.
+const discoverInterval = time.Second
+
+// WaitFor blocks until something produces each of contentTypes, so that a
+// service with hard dependencies can hold off consuming and emitting until
+// they are up. It returns ctx's error if ctx is done first.
+func (s Service) WaitFor(ctx context.Context, contentTypes ...string) error {
+	ticker := time.NewTicker(discoverInterval)
+	defer ticker.Stop()
+	logged := make(map[string]bool)
+	for {
+		changed := s.registry.changed()
+		ready := true
+		for _, contentType := range contentTypes {
+			producers, err := s.Discover(contentType)
+			if err != nil {
+				return err
+			}
+			if len(producers) == 0 {
+				if !logged[contentType] {
+					log.Println("COLONY\t", s.Name, "is waiting for a producer of", contentType)
+					logged[contentType] = true
+				}
+				ready = false
+				break
+			}
+		}
+		if ready {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package colony
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrorContentType is the content type of responses built by
+// NewErrorResponse.
+const ErrorContentType = "error"
+
+// Error codes shared across the colony, so that requesters can react to
+// failures uniformly whichever service reported them. Services may use codes
+// of their own as well.
+const (
+	CodeInternal    = "internal"    // the service failed in a way it didn't expect
+	CodeInvalid     = "invalid"     // the request can't be handled as it is
+	CodeNotFound    = "not_found"   // something the request names doesn't exist
+	CodeUnavailable = "unavailable" // the service can't handle requests for now
+	CodeTimeout     = "timeout"     // the service gave up waiting on something
+)
+
+// A RemoteError is a failure reported by the service that responded to a
+// Message, in place of a payload. Call returns it as its error; Request
+// handlers get it from the response's Err.
+//
+// A service passing on a RemoteError it got from another, by giving it to
+// NewErrorResponse, keeps its Code, Retryable and Origin, so requesters see
+// where a failure began however many services it went through.
+type RemoteError struct {
+	Service   string // name of the service that responded with the error
+	Code      string `json:",omitempty"` // one of the Code constants, or a service's own
+	Message   string
+	Retryable bool   `json:",omitempty"` // whether the same request may succeed if sent again
+	Origin    string `json:",omitempty"` // name of the service the error began in, if not Service
+}
+
+func (e *RemoteError) Error() string {
+	origin := e.Service
+	if e.Origin != "" && e.Origin != e.Service {
+		origin = e.Origin + " via " + e.Service
+	}
+	msg := e.Message
+	if e.Code != "" {
+		msg = e.Code + ": " + msg
+	}
+	if origin == "" {
+		return "colony: " + msg
+	}
+	return fmt.Sprintf("colony: %s: %s", origin, msg)
+}
+
+// NewError returns an error for a handler to give NewErrorResponse, reporting
+// failure with code and message, and whether it is worth retrying.
+func NewError(code, message string, retryable bool) error {
+	return &RemoteError{Code: code, Message: message, Retryable: retryable}
+}
+
+// IsRetryable reports whether err is a RemoteError, or a timeout, that the
+// same request may not meet if sent again.
+func IsRetryable(err error) bool {
+	var re *RemoteError
+	if errors.As(err, &re) {
+		return re.Retryable
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// ErrorCode returns the code of err if it is a RemoteError, CodeTimeout if it
+// is a timeout, and "" otherwise.
+func ErrorCode(err error) string {
+	var re *RemoteError
+	if errors.As(err, &re) {
+		return re.Code
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return CodeTimeout
+	}
+	return ""
+}
+
+// NewErrorResponse builds a response to m reporting that the service failed
+// to handle it with err, rather than answering with a payload. If err is, or
+// wraps, a RemoteError its code, retryability and origin are kept; timeouts
+// are reported as CodeTimeout and anything else as CodeInternal. Use Emit to
+// send it to the originating service.
+func (s *Service) NewErrorResponse(m Message, err error) Message {
+	r := s.NewResponse(m, ErrorContentType, nil)
+	e := &RemoteError{Service: s.Name, Code: CodeInternal, Message: err.Error()}
+	var re *RemoteError
+	switch {
+	case errors.As(err, &re):
+		e.Code, e.Message, e.Retryable, e.Origin = re.Code, re.Message, re.Retryable, re.Origin
+		if e.Origin == "" {
+			e.Origin = re.Service
+		}
+		if e.Origin == s.Name {
+			e.Origin = ""
+		}
+	case errors.Is(err, context.DeadlineExceeded):
+		e.Code, e.Retryable = CodeTimeout, true
+	}
+	r.Error = e
+	return r
+}
+
+// Err returns the RemoteError carried by a response built with
+// NewErrorResponse, or nil if the Message doesn't carry one.
+func (m Message) Err() error {
+	if m.Error == nil {
+		return nil
+	}
+	return m.Error
+}
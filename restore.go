@@ -0,0 +1,137 @@
+package colony
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReplaySuffix is added to the content type of each Message a Restore emits
+// when it replays rather than restores, ahead of any version, as in
+// Order.replay@2. Like the suffixes of retry tiers, lanes and partitions it
+// has no hyphen, which would end the service ID in a topic name.
+const ReplaySuffix = ".replay"
+
+// A Restore emits Messages kept by an Archiver back into the colony, for
+// backfills and recovering from lost data. Restored Messages are new Messages
+// from Service with the payload, Format, Key and CorrelationID of the
+// originals, whose senders they carry as their Origin.
+type Restore struct {
+	Service      *Service
+	Store        BlobLister
+	Prefix       string   // as given to the Archiver
+	ContentTypes []string // to restore; every archived content type if empty
+	// From and To bound the Time of the Messages restored, From included
+	// and To not. Either can be left zero.
+	From time.Time
+	To   time.Time
+	// Replay emits each Message as its content type with ReplaySuffix added,
+	// so that only consumers asking for replays see them.
+	Replay bool
+}
+
+// Run emits the archived Messages in range, segment by segment in the order
+// their first Messages were sent, and returns how many it emitted. It stops
+// early if ctx is done.
+func (r *Restore) Run(ctx context.Context) (int, error) {
+	segments, err := r.segments()
+	if err != nil {
+		return 0, err
+	}
+	announced := make(map[string]bool)
+	n := 0
+	for _, seg := range segments {
+		msgs, err := ReadArchiveSegment(r.Store, seg.Key)
+		if err != nil {
+			return n, err
+		}
+		sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].Time.Before(msgs[j].Time) })
+		for _, m := range msgs {
+			if !r.inRange(m.Time) {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return n, err
+			}
+			contentType := m.ContentType
+			if r.Replay {
+				contentType = replayContentType(contentType)
+			}
+			if !announced[contentType] {
+				if err := r.Service.Announce(contentType); err != nil {
+					return n, err
+				}
+				announced[contentType] = true
+			}
+			out := r.Service.NewMessage(contentType, m.Payload)
+			out.PayloadRef = m.PayloadRef
+			out.Format = m.Format
+			out.Key = m.Key
+			out.CorrelationID = m.CorrelationID
+			out.Origin = m.Origin
+			if out.Origin == "" {
+				out.Origin = m.FromName
+			}
+			if err := r.Service.Emit(out); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// segments returns the archived segments that can hold Messages to restore,
+// in order of their first Message.
+func (r *Restore) segments() ([]ArchiveSegment, error) {
+	keys, err := r.Store.List(r.Prefix + "index/")
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool)
+	for _, contentType := range r.ContentTypes {
+		wanted[contentType] = true
+	}
+	var out []ArchiveSegment
+	for _, key := range keys {
+		if !strings.HasSuffix(key, ".json") {
+			continue
+		}
+		index, err := ReadArchiveIndex(r.Store, key)
+		if err != nil {
+			return nil, err
+		}
+		for _, seg := range index {
+			if len(wanted) > 0 && !wanted[seg.ContentType] {
+				continue
+			}
+			if !r.To.IsZero() && !seg.First.Before(r.To) {
+				continue
+			}
+			if !r.From.IsZero() && seg.Last.Before(r.From) {
+				continue
+			}
+			out = append(out, seg)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].First.Before(out[j].First) })
+	return out, nil
+}
+
+func (r *Restore) inRange(t time.Time) bool {
+	if !r.From.IsZero() && t.Before(r.From) {
+		return false
+	}
+	return r.To.IsZero() || t.Before(r.To)
+}
+
+// replayContentType returns the content type contentType is replayed as.
+func replayContentType(contentType string) string {
+	base, version := SplitVersion(contentType)
+	if version == 0 {
+		return contentType + ReplaySuffix
+	}
+	return base + ReplaySuffix + "@" + strconv.Itoa(version)
+}
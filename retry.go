@@ -0,0 +1,73 @@
+package colony
+
+import (
+	"log"
+	"math/rand"
+	"time"
+)
+
+// A RetryPolicy says how a service retries publishing a Message when its
+// transport fails to take it.
+type RetryPolicy struct {
+	// Attempts is how many times publishing is tried in all, including the
+	// first.
+	Attempts int
+	// Backoff is the wait before the first retry. It doubles for each
+	// retry after that, up to MaxBackoff, and is jittered so that services
+	// don't retry in lockstep.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// OnGiveUp, if set, is called with each Message that couldn't be
+	// published after every attempt, and the last error.
+	OnGiveUp func(m Message, err error)
+}
+
+// WithRetry makes the service retry failed publishes according to p. Without
+// it a publish is tried once and its error returned.
+func WithRetry(p RetryPolicy) Option {
+	return func(s *Service) {
+		s.retry = &p
+	}
+}
+
+// backoff returns how long to wait before retry number n, counting from 0.
+func (p RetryPolicy) backoff(n int) time.Duration {
+	d := p.Backoff
+	for i := 0; i < n && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	// somewhere between half and all of d
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retrying publishes body, the encoding of m, on topic, retrying according to
+// the service's RetryPolicy.
+func (s Service) retrying(topic string, m Message, body []byte) error {
+	return s.retryingOn(s.transport, topic, m, body)
+}
+
+// retryingOn is retrying through t.
+func (s Service) retryingOn(t Transport, topic string, m Message, body []byte) error {
+	err := t.Publish(topic, body)
+	if err == nil || s.retry == nil {
+		return err
+	}
+	p := s.retry
+	for n := 0; n < p.Attempts-1; n++ {
+		log.Println("COLONY\t could not publish", m.ContentType, "message", m.MessageID+", retrying:", err)
+		time.Sleep(p.backoff(n))
+		if err = t.Publish(topic, body); err == nil {
+			return nil
+		}
+	}
+	if p.OnGiveUp != nil {
+		p.OnGiveUp(m, err)
+	}
+	return err
+}
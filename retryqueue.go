@@ -0,0 +1,165 @@
+package colony
+
+import (
+	"log"
+	"strconv"
+	"time"
+)
+
+// DefaultRetryTiers are the delays of the retry tiers WithRetryTiers sets up
+// when it isn't given any.
+var DefaultRetryTiers = []time.Duration{5 * time.Second, time.Minute, 10 * time.Minute}
+
+// WithRetryTiers lets the service send Messages of contentType it fails to
+// handle back to itself with Retry, to be delivered again after each of the
+// delays in tiers in turn. A Message that fails every tier is sent to the
+// dead-letter topic for contentType, named as one of the service's own topics
+// with a .dead suffix, where nothing consumes it. contentType is as given to
+// Consume; under a version constraint each version has tiers of its own.
+func WithRetryTiers(contentType string, tiers ...time.Duration) Option {
+	return func(s *Service) {
+		if len(tiers) == 0 {
+			tiers = DefaultRetryTiers
+		}
+		if s.retryTiers == nil {
+			s.retryTiers = make(map[string][]time.Duration)
+		}
+		s.retryTiers[contentType] = tiers
+	}
+}
+
+// Retry sends m, which the service consumed but failed to handle for cause,
+// to its next retry tier, or to the dead-letter topic if it has been through
+// every tier. Only the service that calls Retry sees m again. Retry tiers are
+// kept by the transport if it is a DeferredTransport, and are lost if the
+// service stops otherwise.
+func (s Service) Retry(m Message, cause error) error {
+	contentType := m.sentAs()
+	tiers := s.tiers(contentType)
+	m.Attempt++
+	bodies, err := s.resend(m)
+	if err != nil {
+		return err
+	}
+	if m.Attempt > len(tiers) {
+		s.count("colony.dead_lettered", contentType)
+		s.notify(ServiceEvent{Kind: DeadLettered, ContentType: contentType, MessageID: string(m.MessageID), Err: cause})
+		log.Println("COLONY\t", s.Name, "gave up on", contentType, "message", m.MessageID, "from", m.FromName+":", cause)
+		return s.publishAll(s.retryTopic(contentType, 0), bodies)
+	}
+	s.count("colony.retried", contentType)
+	topic := s.retryTopic(contentType, m.Attempt)
+	delay := tiers[m.Attempt-1]
+	if dt, ok := s.transport.(DeferredTransport); ok {
+		for _, body := range bodies {
+			if err := dt.DeferredPublish(topic, delay, body); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	time.AfterFunc(delay, func() {
+		if err := s.publishAll(topic, bodies); err != nil {
+			log.Println("COLONY\t could not retry", contentType, "message", m.MessageID+":", err)
+		}
+	})
+	return nil
+}
+
+// sentAs returns the content type m was sent as, before any migration.
+func (m Message) sentAs() string {
+	if len(m.received) > 0 {
+		return m.received[0].ContentType
+	}
+	return m.ContentType
+}
+
+// resend returns the bodies that send m again with its Attempt: the envelopes
+// it arrived in, still signed by its sender as Attempt isn't signed, or, if it
+// didn't arrive at the service, m itself as the service would publish it.
+func (s Service) resend(m Message) ([][]byte, error) {
+	parts := make([]Message, len(m.received))
+	for i, part := range m.received {
+		part.Attempt = m.Attempt
+		parts[i] = part
+	}
+	if len(parts) == 0 {
+		for _, part := range s.split(m) {
+			part, err := s.sign(part)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, part)
+		}
+	}
+	bodies := make([][]byte, len(parts))
+	for i, part := range parts {
+		out, err := s.marshal(part)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		bodies[i] = append([]byte(nil), out.Bytes()...)
+		releaseBuffer(out)
+	}
+	return bodies, nil
+}
+
+// publishAll publishes each of bodies on topic in turn.
+func (s Service) publishAll(topic string, bodies [][]byte) error {
+	for _, body := range bodies {
+		if err := s.transport.Publish(topic, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tiers returns the retry tiers of contentType: those given for it, or for a
+// version constraint it satisfies.
+func (s Service) tiers(contentType string) []time.Duration {
+	if tiers, ok := s.retryTiers[contentType]; ok {
+		return tiers
+	}
+	for pattern, tiers := range s.retryTiers {
+		if matchContentType(pattern, contentType) {
+			return tiers
+		}
+	}
+	return nil
+}
+
+// retryTopic returns the topic of retry tier attempt of contentType, or the
+// dead-letter topic if attempt is 0.
+func (s Service) retryTopic(contentType string, attempt int) string {
+	name := topic{s.Name, s.ID, contentType}.getName()
+	if attempt == 0 {
+		return name + ".dead"
+	}
+	return name + ".retry" + strconv.Itoa(attempt)
+}
+
+// retryTopics returns the topics of every retry tier of contentType.
+func (s Service) retryTopics(contentType string) []string {
+	var out []string
+	for i := range s.tiers(contentType) {
+		out = append(out, s.retryTopic(contentType, i+1))
+	}
+	return out
+}
+
+// poison sends body, a message of contentType the service couldn't decode
+// because of cause, to the dead-letter topic of contentType as it is, so that
+// it neither stops the consumer nor comes back. Messages that aren't of a
+// content type, such as announcements, are only logged.
+func (s Service) poison(contentType string, body []byte, cause error) {
+	if contentType == "" {
+		log.Println("COLONY\t", s.Name, "dropping a message it could not decode:", cause)
+		return
+	}
+	log.Println("COLONY\t", s.Name, "dead-lettering a", contentType, "message it could not decode:", cause)
+	s.count("colony.dead_lettered", contentType)
+	s.notify(ServiceEvent{Kind: DeadLettered, ContentType: contentType, Err: cause})
+	if err := s.transport.Publish(s.retryTopic(contentType, 0), body); err != nil {
+		log.Println("COLONY\t could not dead-letter", contentType, "message:", err)
+	}
+}
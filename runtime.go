@@ -0,0 +1,393 @@
+package colony
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// ConsumerSettings tune a service's consumers of a content type while it
+// runs.
+type ConsumerSettings struct {
+	RateLimit   float64  `json:",omitempty" yaml:"rate_limit" toml:"rate_limit"`       // Messages per second handed to the handler; 0 is unlimited
+	MaxInFlight int      `json:",omitempty" yaml:"max_in_flight" toml:"max_in_flight"` // Messages the transport may have outstanding; 0 leaves its default
+	Concurrency int      `json:",omitempty" yaml:"concurrency" toml:"concurrency"`     // copies of the handler run at once, if it is Concurrent; 0 is one
+	Sources     []string `json:",omitempty" yaml:"sources" toml:"sources"`             // if set, the only services whose Messages are delivered
+}
+
+// A RuntimeConfig holds the settings of a service that can change without
+// restarting it. Consumers are keyed by the content type given to Consume,
+// with "*" for the settings of any not listed.
+type RuntimeConfig struct {
+	Service   string                      `json:",omitempty" yaml:"service" toml:"service"` // when sent as a Message, the service it is for; empty for all
+	Consumers map[string]ConsumerSettings `json:",omitempty" yaml:"consumers" toml:"consumers"`
+}
+
+func (c RuntimeConfig) consumer(contentType string) ConsumerSettings {
+	if cs, ok := c.Consumers[contentType]; ok {
+		return cs
+	}
+	return c.Consumers["*"]
+}
+
+// runtime holds a service's RuntimeConfig and the consumers it applies to.
+type runtime struct {
+	mu        sync.Mutex
+	config    RuntimeConfig
+	consumers map[*consumerControl]bool
+}
+
+func newRuntime() *runtime {
+	return &runtime{consumers: make(map[*consumerControl]bool)}
+}
+
+// Reconfigure applies cfg to the service and its running consumers.
+func (s Service) Reconfigure(cfg RuntimeConfig) {
+	r := s.runtime
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.config = cfg
+	for ctl := range r.consumers {
+		ctl.apply(cfg.consumer(ctl.contentType))
+	}
+	log.Println("COLONY\t", s.Name, "reconfigured")
+	s.notify(ServiceEvent{Kind: Reconfigured})
+}
+
+// RuntimeConfig returns the configuration the service is running with.
+func (s Service) RuntimeConfig() RuntimeConfig {
+	s.runtime.mu.Lock()
+	defer s.runtime.mu.Unlock()
+	return s.runtime.config
+}
+
+// WatchConfigFile loads a RuntimeConfig from path, in the formats LoadConfig
+// reads, and loads it again whenever the file changes, checking every
+// interval until the service is closed. It returns an error only if interval
+// isn't positive or the first load fails.
+func (s Service) WatchConfigFile(path string, interval time.Duration) error {
+	if interval <= 0 {
+		return errors.New("config file interval must be positive")
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := s.loadConfigFile(path); err != nil {
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		modified := fi.ModTime()
+		for {
+			select {
+			case <-ticker.C:
+			case <-s.lifecycle.done:
+				return
+			}
+			fi, err := os.Stat(path)
+			if err != nil || !fi.ModTime().After(modified) {
+				continue
+			}
+			modified = fi.ModTime()
+			if err := s.loadConfigFile(path); err != nil {
+				log.Println("COLONY\t", s.Name, "could not reload", path+":", err)
+			}
+		}
+	}()
+	return nil
+}
+
+func (s Service) loadConfigFile(path string) error {
+	var cfg RuntimeConfig
+	if err := decodeConfigFile(path, &cfg); err != nil {
+		return err
+	}
+	s.Reconfigure(cfg)
+	return nil
+}
+
+// WatchConfig consumes RuntimeConfigs sent as Messages of contentType, such as
+// "config", and applies those for this service or for every service. Like
+// Consume it only returns once the service is closed. The RuntimeConfig
+// doesn't apply to this consumer, so that a bad one can always be replaced.
+func (s Service) WatchConfig(contentType string) error {
+	return s.consume(contentType, consumeOptions{fixed: true}, func(c <-chan Message) error {
+		for {
+			var m Message
+			var ok bool
+			select {
+			case m, ok = <-c:
+			case <-s.lifecycle.done:
+				return nil
+			}
+			if !ok {
+				return nil
+			}
+			var cfg RuntimeConfig
+			if err := json.Unmarshal(m.Payload, &cfg); err != nil {
+				log.Println("COLONY\t", s.Name, "ignored bad config from", m.FromName+":", err)
+				continue
+			}
+			if cfg.Service == "" || cfg.Service == s.Name {
+				s.Reconfigure(cfg)
+			}
+		}
+	})
+}
+
+// consumerControl applies the ConsumerSettings of a content type to one call
+// of Consume.
+type consumerControl struct {
+	contentType string
+	resized     chan struct{} // signalled when Concurrency may have changed
+
+	mu        sync.Mutex
+	overrides ConsumeOptions
+	settings  ConsumerSettings
+	next      time.Time // earliest a rate limited Message may be delivered
+	subs      []Subscription
+}
+
+func newConsumerControl(contentType string, overrides ConsumeOptions) *consumerControl {
+	ctl := &consumerControl{
+		contentType: contentType,
+		resized:     make(chan struct{}, 1),
+		overrides:   overrides,
+	}
+	ctl.apply(ConsumerSettings{})
+	return ctl
+}
+
+// control applies the RuntimeConfig to ctl from now until release is called.
+func (s Service) control(ctl *consumerControl) (release func()) {
+	r := s.runtime
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ctl.apply(r.config.consumer(ctl.contentType))
+	r.consumers[ctl] = true
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.consumers, ctl)
+	}
+}
+
+func (ctl *consumerControl) apply(cs ConsumerSettings) {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	if ctl.overrides.MaxInFlight > 0 {
+		cs.MaxInFlight = ctl.overrides.MaxInFlight
+	}
+	if ctl.overrides.Concurrency > 0 {
+		cs.Concurrency = ctl.overrides.Concurrency
+	} else if !ctl.overrides.Concurrent {
+		cs.Concurrency = 0
+	}
+	ctl.settings = cs
+	for _, sub := range ctl.subs {
+		setMaxInFlight(sub, cs.MaxInFlight)
+	}
+	select {
+	case ctl.resized <- struct{}{}:
+	default:
+	}
+}
+
+// subscribed applies the settings to a new subscription of the consumer.
+func (ctl *consumerControl) subscribed(sub Subscription) {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	ctl.subs = append(ctl.subs, sub)
+	setMaxInFlight(sub, ctl.settings.MaxInFlight)
+}
+
+// setMaxInFlight changes the messages sub may have outstanding, if its
+// transport allows it. NSQ subscriptions do.
+func setMaxInFlight(sub Subscription, n int) {
+	if n <= 0 {
+		return
+	}
+	if sub, ok := sub.(interface{ ChangeMaxInFlight(int) }); ok {
+		sub.ChangeMaxInFlight(n)
+	}
+}
+
+// admit reports whether m passes the consumer's filters, and holds it back as
+// long as the rate limit asks.
+func (ctl *consumerControl) admit(m Message) bool {
+	ctl.mu.Lock()
+	cs := ctl.settings
+	if len(cs.Sources) > 0 && !contains(cs.Sources, m.FromName) {
+		ctl.mu.Unlock()
+		return false
+	}
+	if cs.RateLimit <= 0 {
+		ctl.mu.Unlock()
+		return true
+	}
+	now := time.Now()
+	if ctl.next.Before(now) {
+		ctl.next = now
+	}
+	wait := ctl.next.Sub(now)
+	ctl.next = ctl.next.Add(time.Duration(float64(time.Second) / cs.RateLimit))
+	ctl.mu.Unlock()
+	time.Sleep(wait)
+	return true
+}
+
+func (ctl *consumerControl) concurrency() int {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	if ctl.settings.Concurrency < 1 {
+		return 1
+	}
+	return ctl.settings.Concurrency
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// A worker is an extra copy of a consumer's handler, fed Messages on C until
+// quit is closed.
+type worker struct {
+	C      chan Message
+	quit   chan struct{}
+	exited chan struct{}
+}
+
+// dispatch runs h on in, along with as many extra copies as the consumer's
+// Concurrency asks for, starting and retiring them as it changes. An extra
+// copy is fed from in by a goroutine of its own, and is retired by closing
+// its channel, so handlers must return once their channel is closed rather
+// than handle the zero Messages it yields. A Message taken for an extra copy
+// that returns before receiving it is handed to the first copy, whose channel
+// is closed once in is and every such Message has been delivered. Once the
+// first copy returns the extras are retired and dispatch returns its error.
+func (ctl *consumerControl) dispatch(h Handler, in <-chan Message) error {
+	done := make(chan struct{})
+	defer close(done)
+	returned := make(chan Message) // taken for extra copies that had returned
+	fed := make(chan struct{})     // signalled as each extra copy's feeder stops
+
+	firstC := make(chan Message)
+	first := make(chan error, 1)
+	go func() { first <- h(firstC) }()
+
+	exits := make(chan *worker)
+	var workers []*worker
+	feeders := 0
+	start := func() {
+		w := &worker{
+			C:      make(chan Message),
+			quit:   make(chan struct{}),
+			exited: make(chan struct{}),
+		}
+		workers = append(workers, w)
+		feeders++
+		go func() {
+			defer func() {
+				close(w.C)
+				select {
+				case fed <- struct{}{}:
+				case <-done:
+				}
+			}()
+			for {
+				var m Message
+				select {
+				case <-w.quit:
+					return
+				case msg, ok := <-in:
+					if !ok {
+						return
+					}
+					m = msg
+				}
+				select {
+				case w.C <- m:
+				case <-w.exited:
+					select {
+					case returned <- m:
+					case <-done:
+					}
+					return
+				}
+			}
+		}()
+		go func() {
+			h(w.C)
+			close(w.exited)
+			select {
+			case exits <- w:
+			case <-w.quit:
+			}
+		}()
+	}
+	resize := func(n int) {
+		for len(workers) < n {
+			start()
+		}
+		for len(workers) > n {
+			close(workers[len(workers)-1].quit)
+			workers = workers[:len(workers)-1]
+		}
+	}
+
+	resize(ctl.concurrency() - 1)
+	var held []Message // for the first copy
+	open := in
+	for {
+		// take from in only once the first copy has what it was given
+		src, out := open, firstC
+		var next Message
+		if len(held) > 0 {
+			src, next = nil, held[0]
+		} else {
+			out = nil
+		}
+		if open == nil && len(held) == 0 && feeders == 0 && firstC != nil {
+			close(firstC)
+			firstC = nil
+		}
+		select {
+		case m, ok := <-src:
+			if !ok {
+				open = nil
+				continue
+			}
+			held = append(held, m)
+		case out <- next:
+			held = held[1:]
+		case m := <-returned:
+			held = append(held, m)
+		case <-fed:
+			feeders--
+		case err := <-first:
+			resize(0)
+			return err
+		case w := <-exits:
+			// returned on its own; it isn't replaced until the next change
+			for i := range workers {
+				if workers[i] == w {
+					close(w.quit)
+					workers = append(workers[:i], workers[i+1:]...)
+					break
+				}
+			}
+		case <-ctl.resized:
+			resize(ctl.concurrency() - 1)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package colony
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDispatchKeepsMessagesOfExitedCopies(t *testing.T) {
+	ctl := newConsumerControl("ants", ConsumeOptions{Concurrent: true})
+	in := make(chan Message)
+	var calls, handled int32
+	started := make(chan struct{})
+	dispatched := make(chan error, 1)
+	go func() {
+		dispatched <- ctl.dispatch(func(msgs <-chan Message) error {
+			if atomic.AddInt32(&calls, 1) > 1 {
+				// the extra copies give up without taking what their
+				// feeders hold for them
+				time.Sleep(10 * time.Millisecond)
+				return nil
+			}
+			close(started)
+			for range msgs {
+				atomic.AddInt32(&handled, 1)
+			}
+			return nil
+		}, in)
+	}()
+	<-started
+	ctl.apply(ConsumerSettings{Concurrency: 8})
+	for atomic.LoadInt32(&calls) < 8 {
+		time.Sleep(time.Millisecond)
+	}
+
+	const n = 1000
+	for i := 0; i < n; i++ {
+		in <- Message{}
+	}
+	close(in)
+	select {
+	case <-dispatched:
+	case <-time.After(5 * time.Second):
+		t.Fatal("dispatch did not return once its channel was closed")
+	}
+	if got := atomic.LoadInt32(&handled); got != n {
+		t.Fatalf("handled %d of %d Messages", got, n)
+	}
+}
+
+func TestRuntimeConcurrencyIsOptIn(t *testing.T) {
+	cfg := RuntimeConfig{Consumers: map[string]ConsumerSettings{"*": {Concurrency: 4}}}
+	for _, tc := range []struct {
+		opts ConsumeOptions
+		want int
+	}{
+		{ConsumeOptions{}, 1},
+		{ConsumeOptions{Concurrent: true}, 4},
+		{ConsumeOptions{Concurrency: 2}, 2},
+	} {
+		ctl := newConsumerControl("ants", tc.opts)
+		ctl.apply(cfg.consumer("ants"))
+		if got := ctl.concurrency(); got != tc.want {
+			t.Errorf("%+v: concurrency %d, want %d", tc.opts, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Package s3blob provides a colony BlobStore backed by Amazon S3.
+package s3blob
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Store keeps offloaded colony payloads as objects in an S3 bucket.
+type Store struct {
+	Client *s3.Client
+	Bucket string
+	Prefix string // prepended to every object key
+}
+
+// Put uploads data as the object for key.
+func (s Store) Put(key string, data []byte) error {
+	_, err := s.Client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.Prefix + key),
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
+
+// Get downloads the object for key.
+func (s Store) Get(key string) ([]byte, error) {
+	out, err := s.Client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.Prefix + key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return ioutil.ReadAll(out.Body)
+}
+
+// List returns the keys of the objects starting with prefix, in order.
+func (s Store) List(prefix string) ([]string, error) {
+	var keys []string
+	pages := s3.NewListObjectsV2Paginator(s.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(s.Prefix + prefix),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			keys = append(keys, strings.TrimPrefix(aws.ToString(obj.Key), s.Prefix))
+		}
+	}
+	return keys, nil
+}
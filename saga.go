@@ -0,0 +1,244 @@
+package colony
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrStepFailed is the error of a saga step whose response its Saga judged a
+// failure.
+var ErrStepFailed = errors.New("saga step failed")
+
+// A Saga runs a sequence of requests across services as one unit: if a step
+// fails, the compensating request of every step completed before it is sent,
+// most recent first, to undo it. Build one with NewSaga and Step.
+//
+// Each step is a Call. The first step is sent the payload the saga is run
+// with, and each step after that the payload of the response to the one
+// before. A step's compensation is sent the payload of that step's response.
+type Saga struct {
+	Name    string
+	Service *Service
+	Store   SagaStore
+	Steps   []SagaStep
+	// Timeout bounds each step and compensation. It defaults to 30 seconds.
+	Timeout time.Duration
+	// Failed, if set, reports whether a step's response means the step
+	// failed. By default every response is a success.
+	Failed func(response Message) bool
+}
+
+// A SagaStep is one request of a Saga, and the request that undoes it.
+type SagaStep struct {
+	ContentType  string
+	Compensation string // content type of the compensating request; empty if there's nothing to undo
+}
+
+// SagaStatus is where a saga run has got to.
+type SagaStatus string
+
+// The statuses of a saga run.
+const (
+	SagaRunning      SagaStatus = "running"
+	SagaCompleted    SagaStatus = "completed"
+	SagaCompensating SagaStatus = "compensating"
+	SagaCompensated  SagaStatus = "compensated"
+	// SagaFailed means a compensation failed too, and the saga's effects
+	// need undoing by hand.
+	SagaFailed SagaStatus = "failed"
+)
+
+// SagaState is the progress of one run of a Saga, as kept in a SagaStore.
+type SagaState struct {
+	ID      string
+	Saga    string
+	Status  SagaStatus
+	Step    int      // the next step to run, or compensate when compensating
+	Input   []byte   // the payload the saga was run with
+	Results [][]byte // the response payloads of the completed steps
+	Err     string   // why the saga is compensating, if it is
+	Updated time.Time
+}
+
+// A SagaStore keeps the state of saga runs, so that they can be resumed
+// after a crash.
+type SagaStore interface {
+	Save(state SagaState) error
+	// Load returns the state of the run with id, or false if there's none.
+	Load(id string) (SagaState, bool, error)
+}
+
+// MemorySagaStore is a SagaStore that keeps state in memory, for tests and
+// sagas that needn't survive a restart.
+type MemorySagaStore struct {
+	mu     sync.Mutex
+	states map[string]SagaState
+}
+
+// Save stores state.
+func (m *MemorySagaStore) Save(state SagaState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.states == nil {
+		m.states = make(map[string]SagaState)
+	}
+	m.states[state.ID] = state
+	return nil
+}
+
+// Load returns the state stored for id.
+func (m *MemorySagaStore) Load(id string) (SagaState, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.states[id]
+	return state, ok, nil
+}
+
+// A SagaError is returned by Saga.Run when a step fails.
+type SagaError struct {
+	Step        int
+	ContentType string
+	Err         error
+	// Compensated reports whether every completed step was undone.
+	Compensated bool
+}
+
+func (e *SagaError) Error() string {
+	outcome := "compensated"
+	if !e.Compensated {
+		outcome = "compensation failed"
+	}
+	return fmt.Sprintf("saga step %d (%s): %v; %s", e.Step, e.ContentType, e.Err, outcome)
+}
+
+// NewSaga returns an empty Saga called name, run by s and keeping its state in
+// store.
+func NewSaga(s *Service, name string, store SagaStore) *Saga {
+	return &Saga{
+		Name:    name,
+		Service: s,
+		Store:   store,
+	}
+}
+
+// Step adds a step requesting contentType to the saga, undone by requesting
+// compensation, and returns the saga.
+func (sg *Saga) Step(contentType, compensation string) *Saga {
+	sg.Steps = append(sg.Steps, SagaStep{ContentType: contentType, Compensation: compensation})
+	return sg
+}
+
+// Run runs the saga as id, starting with payload, and returns the payload of
+// the last step's response. If the store already has a run with id it is
+// resumed where it left off, and payload is ignored. A failed step is
+// returned as a *SagaError once compensation is over.
+func (sg *Saga) Run(ctx context.Context, id string, payload []byte) ([]byte, error) {
+	state, ok, err := sg.Store.Load(id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		state = SagaState{ID: id, Saga: sg.Name, Status: SagaRunning, Input: payload}
+		if err := sg.save(&state); err != nil {
+			return nil, err
+		}
+	}
+
+	for state.Status == SagaRunning && state.Step < len(sg.Steps) {
+		step := sg.Steps[state.Step]
+		input := state.Input
+		if state.Step > 0 {
+			input = state.Results[state.Step-1]
+		}
+		resp, err := sg.call(ctx, step.ContentType, input)
+		if err == nil && sg.Failed != nil && sg.Failed(resp) {
+			err = ErrStepFailed
+		}
+		if err != nil {
+			log.Println("COLONY\t saga", sg.Name, id, "step", state.Step, step.ContentType, "failed:", err)
+			state.Status = SagaCompensating
+			state.Err = err.Error()
+			state.Step--
+		} else {
+			state.Results = append(state.Results[:state.Step], resp.Payload)
+			state.Step++
+			if state.Step == len(sg.Steps) {
+				state.Status = SagaCompleted
+			}
+		}
+		if err := sg.save(&state); err != nil {
+			return nil, err
+		}
+	}
+
+	if state.Status == SagaRunning {
+		// a saga without steps
+		state.Status = SagaCompleted
+	}
+	if state.Status == SagaCompensating {
+		sg.compensate(ctx, &state)
+	}
+	switch state.Status {
+	case SagaCompleted:
+		if len(state.Results) == 0 {
+			return nil, nil
+		}
+		return state.Results[len(state.Results)-1], nil
+	default:
+		failed := len(state.Results)
+		return nil, &SagaError{
+			Step:        failed,
+			ContentType: sg.Steps[failed].ContentType,
+			Err:         errors.New(state.Err),
+			Compensated: state.Status == SagaCompensated,
+		}
+	}
+}
+
+// compensate undoes the completed steps of state, most recent first.
+func (sg *Saga) compensate(ctx context.Context, state *SagaState) {
+	for state.Step >= 0 {
+		step := sg.Steps[state.Step]
+		if step.Compensation != "" {
+			resp, err := sg.call(ctx, step.Compensation, state.Results[state.Step])
+			if err == nil && sg.Failed != nil && sg.Failed(resp) {
+				err = ErrStepFailed
+			}
+			if err != nil {
+				log.Println("COLONY\t saga", sg.Name, state.ID, "could not compensate step", state.Step, step.ContentType+":", err)
+				state.Status = SagaFailed
+				sg.save(state)
+				return
+			}
+		}
+		state.Step--
+		if err := sg.save(state); err != nil {
+			return
+		}
+	}
+	state.Status = SagaCompensated
+	sg.save(state)
+}
+
+func (sg *Saga) call(ctx context.Context, contentType string, payload []byte) (Message, error) {
+	timeout := sg.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return sg.Service.Call(ctx, sg.Service.NewMessage(contentType, payload))
+}
+
+func (sg *Saga) save(state *SagaState) error {
+	state.Updated = time.Now()
+	err := sg.Store.Save(*state)
+	if err != nil {
+		log.Println("COLONY\t could not save saga", sg.Name, state.ID+":", err)
+	}
+	return err
+}
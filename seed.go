@@ -0,0 +1,59 @@
+package colony
+
+import (
+	cryptorand "crypto/rand"
+	"math/rand"
+	"sync"
+)
+
+// WithSeed makes the random parts of what the service sends come from a
+// generator seeded with seed, so that tests comparing its traffic with golden
+// files are reproducible: the nsqd it publishes to, chunk sets, blob keys,
+// correlation IDs, idempotency keys and the names of its ephemeral channels.
+// MessageIDs count up from 1 whether or not it is used. It is meant for
+// tests; services sharing a seed pick the same names.
+func WithSeed(seed int64) Option {
+	return func(s *Service) {
+		s.random = &seededRand{r: rand.New(rand.NewSource(seed))}
+	}
+}
+
+// seededRand is a rand.Rand safe for concurrent use.
+type seededRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+// randInt63 returns a random number from the service's seeded generator, if
+// it has one.
+func (s Service) randInt63() int64 {
+	if s.random == nil {
+		return rand.Int63()
+	}
+	s.random.mu.Lock()
+	defer s.random.mu.Unlock()
+	return s.random.r.Int63()
+}
+
+// randIntn returns a random number in [0, n) from the service's seeded
+// generator, if it has one.
+func (s Service) randIntn(n int) int {
+	if s.random == nil {
+		return rand.Intn(n)
+	}
+	s.random.mu.Lock()
+	defer s.random.mu.Unlock()
+	return s.random.r.Intn(n)
+}
+
+// randRead fills b with random bytes from the service's seeded generator, if
+// it has one, or from crypto/rand.
+func (s Service) randRead(b []byte) {
+	if s.random == nil {
+		cryptorand.Read(b)
+		return
+	}
+	s.random.mu.Lock()
+	defer s.random.mu.Unlock()
+	s.random.r.Read(b)
+}
@@ -2,18 +2,18 @@
 package colony
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"math/rand"
-	"net/http"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
-	"github.com/bitly/go-nsq"
 	"github.com/daviddengcn/go-colortext"
 )
 
@@ -27,7 +27,33 @@ type topic struct {
 
 // getName returns the properly formatted topic name from a topic
 func (t topic) getName() string {
-	return t.ServiceName + "-" + t.ServiceID + "-" + t.ContentType
+	return t.ServiceName + "-" + t.ServiceID + "-" + topicContentType(t.ContentType)
+}
+
+// ErrBadContentType is returned for a content type with a hyphen, which would
+// be taken for the end of the service ID in the names of its topics.
+var ErrBadContentType = errors.New("content type contains a hyphen")
+
+// checkContentType returns ErrBadContentType if contentType can't be told
+// apart from the service ID in a topic name.
+func checkContentType(contentType string) error {
+	if strings.Contains(contentType, "-") {
+		return fmt.Errorf("%w: %q", ErrBadContentType, contentType)
+	}
+	return nil
+}
+
+// SplitTopic splits the name of a colony topic into the name and ID of the
+// service producing it and its content type. It reports false for names that
+// aren't colony topics, such as colony-announce. Service names and content
+// types can't contain hyphens; service IDs can.
+func SplitTopic(name string) (serviceName, serviceID, contentType string, ok bool) {
+	first := strings.Index(name, "-")
+	last := strings.LastIndex(name, "-")
+	if first <= 0 || last <= first+1 || last == len(name)-1 {
+		return "", "", "", false
+	}
+	return name[:first], name[first+1 : last], fromTopicContentType(name[last+1:]), true
 }
 
 type messageID string
@@ -36,13 +62,36 @@ type messageID string
 // successful routing through NSQ between services. Generally
 // NewMessage should be used to generate outbound messages and NewResponse to generate responses.
 type Message struct {
-	FromName      string    // name of originating service
-	Payload       []byte    // actual message content
-	Time          time.Time // time message was generated
-	ContentType   string    // contentType of message
-	MessageID     messageID // message id
-	Topic         topic     // topic message appears on
-	ResponseTopic topic     // responses to this message can be sent here
+	FromName      string            // name of originating service
+	Payload       []byte            // actual message content
+	Time          time.Time         // time message was generated
+	ContentType   string            // contentType of message
+	MessageID     messageID         // message id
+	Topic         topic             // topic message appears on
+	ResponseTopic topic             // responses to this message can be sent here
+	Signature     []byte            // signature over the envelope, set when the sender has a Signer
+	Identity      []byte            // DER certificate of the sender, set when it has an Identity
+	Chunk         *Chunk            `json:",omitempty"` // set when this is one part of a larger Message
+	PayloadRef    string            `json:",omitempty"` // BlobStore key of an offloaded payload
+	Format        *Format           `json:",omitempty"` // encoding and schema version of the payload, if declared
+	Origin        string            `json:",omitempty"` // original sender of a Message relayed by a Federation
+	Via           []string          `json:",omitempty"` // colonies a Message has been relayed from, oldest first
+	OriginDC      string            `json:",omitempty"` // datacenter the Message was first emitted in
+	Hops          int               `json:",omitempty"` // how many times the Message has been relayed
+	CorrelationID string            `json:",omitempty"` // ties together the Messages of one Workflow run, and their responses
+	Priority      int               `json:",omitempty"` // PriorityHigh or PriorityLow to send on a priority lane
+	Key           string            `json:",omitempty"` // picks the partition of a partitioned content type
+	Attempt       int               `json:",omitempty"` // retry tiers the Message has been through; not signed
+	Expiry        *time.Time        `json:",omitempty"` // when the Message stops being worth delivering
+	Error         *RemoteError      `json:",omitempty"` // set on a response reporting failure instead of a payload
+	Headers       map[string]string `json:",omitempty"` // set with SetHeader
+	Deadline      *time.Time        `json:",omitempty"` // when the requester stops waiting for the Message to be handled
+
+	blobs BlobStore // where an offloaded payload can be fetched from
+	job   *Job      // the lease on the Message, if it was consumed as a Job or with AckManual
+	// received is the Message as it arrived, signed by its sender: every
+	// part of a chunked Message, in order, and before any migration.
+	received []Message
 }
 
 type handlerIDPair struct {
@@ -50,6 +99,14 @@ type handlerIDPair struct {
 	id messageID
 }
 
+// runningHandler is a response Handler that has been set going. done is
+// closed when the Handler returns, so that responses arriving afterwards are
+// dropped instead of blocking.
+type runningHandler struct {
+	c    chan Message
+	done chan struct{}
+}
+
 // Handler receive a stream of Messages over the supplied channel
 // in response to a corresponding outbound message. Each service needs
 // to provide a Handler for each content type it consumes, and for
@@ -61,103 +118,180 @@ type Handler func(<-chan Message) error
 type Service struct {
 	Name               string // Name of the service
 	ID                 string // ID of the service
-	i                  int    // this is just for IDs #TODO make this not crap
-	handlers           map[messageID]chan Message
+	handlers           map[messageID]runningHandler
 	addHandlerChan     chan handlerIDPair
 	removeHandlerChan  chan handlerIDPair
 	callHandlerChan    chan Message
-	producer           *nsq.Producer
-	nsqLookupdHTTPAddr string
-	nsqdAddr           string
-	nsqdHTTPAddr       string
+	transport          Transport
 	responseTopic      topic
-}
-
-type nodesResponse struct {
-	Status_code int
-	Status_txt  string
-	Data        producers
-}
-type producers struct {
-	Producers []producer
-}
-type producer struct {
-	Topics            []string
-	Tombstones        []string
-	Version           string
-	Http_port         int
-	Tcp_port          int
-	Broadcast_address string
-	Hostname          string
-	Remote_address    string
+	signer             Signer
+	verifier           Verifier
+	rejectHandler      RejectHandler
+	identity           *tls.Certificate
+	identityRoots      *x509.CertPool
+	policy             Policy
+	chunkSize          int
+	chunks             *reassembler
+	blobs              BlobStore
+	blobThreshold      int
+	describer          *describer
+	registry           *Registry
+	migrations         map[string][]Migration
+	partitions         map[string]int
+	retryTiers         map[string][]time.Duration
+	ttls               map[string]time.Duration
+	expired            *int64
+	lastID             *uint64 // the last MessageID handed out
+	auditor            *auditor
+	offsets            *offsets
+	runtime            *runtime
+	reannounce         time.Duration
+	datacenter         string
+	namespace          string
+	buffers            Buffers
+	zeroCopy           bool
+	framing            *framing
+	handlerConcurrency int
+	producers          int
+	nodePoll           time.Duration
+	directConsumers    bool
+	lookupdDNS         time.Duration
+	discovery          Discovery
+	coalescer          *coalescer
+	responseOrder      *sequencer
+	topics             *topicCatalog
+	retry              *RetryPolicy
+	dedup              DedupStore
+	deadLetterExpired  bool
+	random             *seededRand
+	chaos              *Chaos
+	tracing            bool
+	traceContext       bool
+	metrics            Metrics
+	handlerInfo        *handlerTable
+	events             *eventBus
+	debugAddr          string
+	quiet              bool
+	ephemeral          bool
+	spool              *spooler
+	lifecycle          *lifecycle
 }
 
 // NewService returns a colony service associated with a specific NSQ setup.
 // Provide NSQ's lookupd address. This Service will be associated with an
 // NSQD node in the network at random. If you're running NSQ locally with the default
-// port then this will be "0.0.0.0/4161"
-func NewService(name, id, nsqLookupd string) *Service {
-	resp, err := http.Get("http://" + nsqLookupd + "/nodes")
-	if err != nil {
-		log.Fatal(err)
+// port then this will be "0.0.0.0/4161". Options can be supplied to further
+// configure the service.
+func NewService(name, id, nsqLookupd string, opts ...Option) *Service {
+	s := newService(name, id, opts)
+	s.callHandlerChan = make(chan Message, s.buffers.Response)
+	if !s.quiet {
+		ct.ChangeColor(ct.Cyan, false, ct.None, false)
+		fmt.Println(`
+                                        __
+                                       // \
+                                       \\_/ //`)
+		ct.ChangeColor(ct.Magenta, true, ct.None, false)
+		fmt.Print(`     colony`)
+		ct.ChangeColor(ct.Cyan, false, ct.None, false)
+		fmt.Print("          ''-.._.-''-.._.. -(||)(')\n")
+		fmt.Print("                                       '''\n\n")
+		ct.ResetColor()
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	var n nodesResponse
-	json.Unmarshal(body, &n)
-	if n.Status_code != 200 {
-		log.Fatal(errors.New("could not get list of nsqd nodes"))
+	if err := s.connect(nsqLookupd); err != nil {
+		log.Fatal(err.Error())
 	}
-
-	nProducers := len(n.Data.Producers)
-	if nProducers <= 0 {
-		log.Fatal(errors.New("found no NSQ daemons"))
+	if t, ok := s.transport.(*NSQTransport); ok && s.nodePoll > 0 {
+		go s.nodePoller(t, s.nodePoll)
 	}
-	productionNSQD := n.Data.Producers[rand.Intn(nProducers)]
-	nsqdAddr := productionNSQD.Broadcast_address + ":" + strconv.Itoa(productionNSQD.Tcp_port)
-	nsqdHTTPAddr := productionNSQD.Broadcast_address + ":" + strconv.Itoa(productionNSQD.Http_port)
-
-	conf := nsq.NewConfig()
-	err = conf.Set("lookupd_poll_interval", "5s")
-	producer, err := nsq.NewProducer(nsqdAddr, conf)
-	if err != nil {
-		log.Fatal(err.Error())
+	if t, ok := s.transport.(*NSQTransport); ok && s.lookupdDNS > 0 {
+		go s.lookupdResolver(t, s.lookupdDNS)
+	}
+	if s.namespace != "" {
+		s.transport = NamespaceTransport(s.transport, s.namespace)
+	}
+	s.registry = NewRegistry(s)
+	if s.describer.interval > 0 {
+		go s.heartbeat(s.describer.interval)
+	}
+	if s.reannounce > 0 {
+		go s.reannouncer(s.reannounce)
+	}
+	if s.spool != nil {
+		go s.replaySpool()
+	}
+	if s.coalescer != nil {
+		s.lifecycle.atClose(s.flushAll)
 	}
+	if s.debugAddr != "" {
+		go s.serveDebug(s.debugAddr)
+	}
+	if s.ephemeral {
+		s.lifecycle.atClose(s.deleteResponseTopic)
+	}
+	go s.start()
+	return s
+}
+
+// newService returns a service with its defaults and opts applied, before it
+// connects to the colony or starts.
+func newService(name, id string, opts []Option) *Service {
 	responseTopic := topic{
 		ServiceName: name,
 		ServiceID:   id,
 		ContentType: "responses",
 	}
 	s := &Service{
-		Name:               name,
-		ID:                 id,
-		handlers:           make(map[messageID]chan Message),
-		addHandlerChan:     make(chan handlerIDPair),
-		removeHandlerChan:  make(chan handlerIDPair),
-		callHandlerChan:    make(chan Message),
-		producer:           producer,
-		nsqLookupdHTTPAddr: nsqLookupd,
-		nsqdAddr:           nsqdAddr,
-		nsqdHTTPAddr:       nsqdHTTPAddr,
-		responseTopic:      responseTopic,
-	}
-	ct.ChangeColor(ct.Cyan, false, ct.None, false)
-	fmt.Println(`
-                                        __
-                                       // \
-                                       \\_/ //`)
-	ct.ChangeColor(ct.Magenta, true, ct.None, false)
-	fmt.Print(`     colony`)
-	ct.ChangeColor(ct.Cyan, false, ct.None, false)
-	fmt.Print("          ''-.._.-''-.._.. -(||)(')\n")
-	fmt.Print("                                       '''\n\n")
-	ct.ResetColor()
-	log.Println("COLONY\t Using NSQD TCP:", nsqdAddr)
-	log.Println("COLONY\t Using NSQD HTTP:", nsqdHTTPAddr)
-	go s.start()
+		Name:              name,
+		ID:                id,
+		handlers:          make(map[messageID]runningHandler),
+		addHandlerChan:    make(chan handlerIDPair),
+		removeHandlerChan: make(chan handlerIDPair),
+		responseTopic:     responseTopic,
+		rejectHandler:     logReject,
+		chunkSize:         DefaultChunkSize,
+		chunks:            newReassembler(time.Minute),
+		describer:         newDescriber(),
+		lifecycle:         newLifecycle(),
+		expired:           new(int64),
+		lastID:            new(uint64),
+		runtime:           newRuntime(),
+		responseOrder:     newSequencer(),
+		topics:            newTopicCatalog(),
+		metrics:           noMetrics{},
+		handlerInfo:       newHandlerTable(),
+		events:            newEventBus(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
 	return s
 }
 
+// connect gives the service a transport to the NSQ cluster of the lookupd at
+// nsqLookupd, unless it was given one by an option.
+func (s *Service) connect(nsqLookupd string) error {
+	if s.transport != nil {
+		return nil
+	}
+	conf := s.nsqConfig()
+	if err := conf.Set("lookupd_poll_interval", "5s"); err != nil {
+		return err
+	}
+	var t *NSQTransport
+	var err error
+	if s.discovery != nil {
+		t, err = s.discoverNSQ(conf)
+	} else {
+		t, err = s.dialNSQ(nsqLookupd, conf)
+	}
+	if err != nil {
+		return err
+	}
+	s.transport = t
+	return nil
+}
+
 // start starts a service. This should be called once, probably inside its own
 // goroutine.
 func (s Service) start() {
@@ -168,26 +302,41 @@ func (s Service) start() {
 		select {
 		case pair := <-s.addHandlerChan:
 			// make the channel that will be sent to the handler
-			c := make(chan Message)
+			r := runningHandler{
+				c:    make(chan Message, s.buffers.Response),
+				done: make(chan struct{}),
+			}
 			// add the channel to our handler map
-			s.handlers[pair.id] = c
+			s.handlers[pair.id] = r
+			s.handlerInfo.add(pair.id)
+			s.metrics.Gauge("colony.response_handlers", float64(len(s.handlers)), nil)
 			// set the handler going
 			go func() {
-				err := pair.h(c)
+				err := pair.h(r.c)
 				if err != nil {
 					log.Fatal(err.Error())
 				}
 				// once the handler is complete, delete it from the handler map
+				close(r.done)
 				s.removeHandlerChan <- pair
 			}()
 		case pair := <-s.removeHandlerChan:
 			delete(s.handlers, pair.id)
+			s.handlerInfo.remove(pair.id)
+			s.notify(ServiceEvent{Kind: HandlerExited, MessageID: string(pair.id)})
+			s.metrics.Gauge("colony.response_handlers", float64(len(s.handlers)), nil)
 		case msg := <-s.callHandlerChan:
-			c, ok := s.handlers[msg.MessageID]
+			r, ok := s.handlers[msg.MessageID]
 			if !ok {
 				continue
 			}
-			c <- msg
+			s.handlerInfo.waiting(msg.MessageID)
+			select {
+			case r.c <- msg:
+				s.handlerInfo.delivered(msg.MessageID, true)
+			case <-r.done:
+				s.handlerInfo.delivered(msg.MessageID, false)
+			}
 		}
 	}
 }
@@ -201,7 +350,7 @@ func (s *Service) NewMessage(contentType string, payload []byte) Message {
 		ContentType: contentType,
 	}
 
-	return Message{
+	m := Message{
 		Topic:         from,
 		FromName:      s.Name,
 		Payload:       payload,
@@ -209,13 +358,21 @@ func (s *Service) NewMessage(contentType string, payload []byte) Message {
 		ResponseTopic: s.responseTopic,
 		MessageID:     s.nextID(),
 		ContentType:   contentType,
+		OriginDC:      s.datacenter,
+	}
+	if ttl, ok := s.ttls[contentType]; ok {
+		m.ExpireAfter(ttl)
+	}
+	if s.traceContext {
+		m.SetHeader(TraceParentHeader, s.newTraceParent())
 	}
+	return m
 }
 
 // NewResponse builds a colony Message specifically as a response to a recieved Message. Use
 // Emit or Request to send this Message to the originating service.
 func (s *Service) NewResponse(m Message, contentType string, payload []byte) Message {
-	return Message{
+	r := Message{
 		Topic:         m.ResponseTopic,
 		FromName:      s.Name,
 		Payload:       payload,
@@ -223,74 +380,131 @@ func (s *Service) NewResponse(m Message, contentType string, payload []byte) Mes
 		ResponseTopic: s.responseTopic,
 		MessageID:     m.MessageID,
 		ContentType:   contentType,
+		CorrelationID: m.CorrelationID,
 	}
+	if key := m.Header(IdempotencyKeyHeader); key != "" {
+		r.SetHeader(IdempotencyKeyHeader, key)
+	}
+	if m.CorrelationID != "" {
+		r.SetHeader(ParentHeader, m.Span())
+	}
+	r.continueTrace(m)
+	return r
 }
 
+// nextID returns a MessageID no other Message from the service has. It is safe
+// for concurrent use, as gateways create Messages from many goroutines.
 func (s *Service) nextID() messageID {
-	s.i = s.i + 1
-	return messageID(strconv.Itoa(s.i))
-}
-
-type createTopicResponse struct {
-	Status_code int
-	Status_txt  string
-	Data        string
+	return messageID(strconv.FormatUint(atomic.AddUint64(s.lastID, 1), 10))
 }
 
-func (s *Service) createTopic(topic string) error {
-	resp, err := http.Get("http://" + s.nsqdHTTPAddr + "/create_topic?topic=" + topic)
+// handleResponse routes messages from the service's response topic
+// to the appopriate Handler.
+func (s Service) handleResponse(body []byte) error {
+	// handled concurrently, but handed over in order
+	t := s.responseOrder.ticket()
+	var out Message
+	err := unmarshalMessage(body, &out)
+	ok := false
+	if err == nil {
+		out, ok = s.receive(out)
+	}
+	s.responseOrder.wait(t)
+	defer s.responseOrder.done()
+	if errors.Is(err, ErrNotColony) {
+		return nil
+	}
 	if err != nil {
-		log.Fatal(err.Error())
+		return err
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	var r createTopicResponse
-	json.Unmarshal(body, &r)
-	if r.Status_code != 200 {
-		return errors.New("could not creat topic " + topic)
+	if ok {
+		s.callHandlerChan <- out
 	}
 	return nil
 }
 
-// HandleMessage routes messages from the service's response topic
-// to the appopriate Handler. This function can be safely ignored when building a service.
-func (s Service) HandleMessage(m *nsq.Message) error {
-	var out Message
-	err := json.Unmarshal(m.Body, &out)
+// receive runs an inbound Message through the service's checks, drops it if it
+// has expired, reassembles chunked payloads and migrates them to their latest
+// schema version. It reports whether the Message is ready to be delivered.
+func (s Service) receive(m Message) (Message, bool) {
+	return s.receiveWith(s.chunks, m)
+}
+
+// receiveWith is receive using its own reassembler, for consumers that see
+// copies of messages the service also receives elsewhere.
+func (s Service) receiveWith(chunks *reassembler, m Message) (Message, bool) {
+	if !s.accept(m) {
+		return m, false
+	}
+	if m.Expired() {
+		atomic.AddInt64(s.expired, 1)
+		s.count("colony.expired", m.ContentType)
+		return m, false
+	}
+	return s.assemble(chunks, m)
+}
+
+// assemble is receiveWith for a Message already accepted and found not to
+// have expired.
+func (s Service) assemble(chunks *reassembler, m Message) (Message, bool) {
+	m.blobs = s.blobs
+	m.received = []Message{m}
+	m, ok := chunks.add(m)
+	if !ok {
+		return m, false
+	}
+	m, err := s.migrate(m)
 	if err != nil {
-		return err
+		s.reject(m, err)
+		return m, false
 	}
-	s.callHandlerChan <- out
-	return nil
+	s.audit(AuditConsumed, m.Topic.getName(), m)
+	s.count("colony.consumed", m.ContentType)
+	return m, true
 }
 
 func (s *Service) responseHandler() {
 	// initialise response topic
 	channelName := s.Name + "-" + s.ID + "-responseHandler"
+	if s.ephemeral {
+		channelName += "#ephemeral"
+	}
 	log.Println("COLONY\t", s.Name, "is using response channel", channelName)
 
-	conf := nsq.NewConfig()
-	err := conf.Set("lookupd_poll_interval", "5s")
-	if err != nil {
-		log.Fatal(err.Error())
-	}
-	err = s.createTopic(s.responseTopic.getName())
+	err := s.transport.CreateTopic(s.responseTopic.getName())
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 
 	topicName := s.responseTopic.getName()
-	c, err := nsq.NewConsumer(topicName, channelName, conf)
-	if err != nil {
+	_, err = s.subscribeConcurrent(topicName, channelName, s.handlerConcurrency, s.handleResponse)
+	if err != nil && err != ErrClosed {
 		log.Fatal(err.Error())
 	}
-	c.AddHandler(s)
-	c.ConnectToNSQLookupd(s.nsqLookupdHTTPAddr)
+}
+
+// deleteResponseTopic deletes the service's response topic, if the transport
+// allows it.
+func (s Service) deleteResponseTopic() {
+	c, ok := s.transport.(ControlTransport)
+	if !ok {
+		return
+	}
+	if err := c.DeleteTopic(s.responseTopic.getName()); err != nil {
+		log.Println("COLONY\t could not delete response topic", s.responseTopic.getName()+":", err)
+	}
 }
 
 // Announce the production of a new content type to the colony, to alert existing services.
 // If Announce is not called, only new services will discover this contentType.
+// The announcement carries the service's ServiceDescriptor as its payload.
 func (s Service) Announce(contentType string) error {
+	if s.lifecycle.isClosed() {
+		return ErrClosed
+	}
+	if err := checkContentType(contentType); err != nil {
+		return err
+	}
 	topicToAnnounce := topic{
 		ServiceName: s.Name,
 		ServiceID:   s.ID,
@@ -302,13 +516,26 @@ func (s Service) Announce(contentType string) error {
 		ContentType: contentType,
 		Topic:       topicToAnnounce,
 	}
+	if !s.allowed(ActionEmit, s.Name, contentType) {
+		return ErrForbidden
+	}
+	s.describer.produce(contentType)
+	s.register(s.Describe())
+	desc, err := json.Marshal(s.Describe())
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	m.Payload = desc
+	m, err = s.sign(m)
+	if err != nil {
+		return err
+	}
 	out, err := json.Marshal(m)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
-	s.createTopic(topicToAnnounce.getName())
-	s.producer.Publish("colony-announce", out)
-	return nil
+	s.transport.CreateTopic(topicToAnnounce.getName())
+	return s.transport.Publish("colony-announce", out)
 }
 
 // Emit sends a Message from the service to the colony
@@ -322,42 +549,245 @@ func (s Service) Request(m Message, h Handler) error {
 	return s.produce(m, h)
 }
 
+// Call sends a Message from the service to the colony and waits for the first
+// response to it. It returns ctx's error if ctx is done before a response
+// arrives, and the response's RemoteError if it reports failure. Any further
+// responses are dropped. ctx's deadline goes with the Message as its
+// Deadline, unless it has one. CallOptions, such as WithHedging and
+// WithRetries, change how the Message is sent.
+func (s Service) Call(ctx context.Context, m Message, opts ...CallOption) (Message, error) {
+	var o callOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	defer func(start time.Time) {
+		s.observe("colony.call_seconds", m.ContentType, time.Since(start).Seconds())
+	}(time.Now())
+	if deadline, ok := ctx.Deadline(); ok && m.Deadline == nil {
+		m.WithDeadline(deadline)
+	}
+	if o.retries > 0 && m.Header(IdempotencyKeyHeader) == "" {
+		m.SetHeader(IdempotencyKeyHeader, s.newIdempotencyKey())
+	}
+	responses := make(chan Message)
+	answered := make(chan struct{})
+	defer close(answered)
+	err := s.Request(m, func(c <-chan Message) error {
+		for {
+			select {
+			case r := <-c:
+				select {
+				case responses <- r:
+				case <-answered:
+					return nil
+				}
+			case <-answered:
+				return nil
+			}
+		}
+	})
+	if err != nil {
+		return Message{}, err
+	}
+	if o.hedges > 0 {
+		go s.hedge(ctx, m, o, answered)
+	}
+	var timeout <-chan time.Time
+	timer := time.NewTimer(o.retryTimeout)
+	defer timer.Stop()
+	if o.retries > 0 && o.retryTimeout > 0 {
+		timeout = timer.C
+	}
+	for attempt := 0; ; attempt++ {
+		select {
+		case r := <-responses:
+			if err := r.Err(); attempt >= o.retries || !IsRetryable(err) {
+				return r, err
+			}
+		case <-timeout:
+			if attempt >= o.retries {
+				// out of retries; wait on the last copy until ctx is done
+				timeout = nil
+				continue
+			}
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		}
+		s.count("colony.call_retried", m.ContentType)
+		if err := s.publish(m); err != nil {
+			return Message{}, err
+		}
+		if timeout != nil {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(o.retryTimeout)
+		}
+	}
+}
+
 // produce emits a colony Message to the netowrk on the appropriate topic. If the
 // Handler is not nil, then it is registered with the service for
 // responses to this message.
 func (s Service) produce(m Message, h Handler) error {
+	if s.lifecycle.isClosed() {
+		return ErrClosed
+	}
+	if !s.allowed(ActionEmit, s.Name, m.ContentType) {
+		return ErrForbidden
+	}
+	if err := checkContentType(m.Topic.ContentType); err != nil {
+		return err
+	}
 	if h != nil {
 		s.addHandlerChan <- handlerIDPair{
 			h:  h,
 			id: m.MessageID,
 		}
 	}
+	return s.publish(m)
+}
+
+// publish signs m and sends it on its topic. Payloads over the service's blob
+// threshold are offloaded, and payloads larger than its chunk size are sent as
+// several parts. Failed sends are retried if the service has a RetryPolicy,
+// and spooled if it has a Spool. Messages of a partitioned content type are
+// sent on their key's partition, and Messages with a priority on its lane;
+// responses always go on the response topic.
+func (s Service) publish(m Message) error {
 	topic := m.Topic.getName()
-	out, err := json.Marshal(m)
+	if m.Topic.ContentType != "responses" {
+		if n := s.partitions[m.ContentType]; n > 0 {
+			topic = partitionTopic(topic, Partition(m.Key, n))
+		}
+		topic = laneTopic(topic, m.Priority)
+	}
+	sent := m
+	m, err := s.offload(m)
 	if err != nil {
-		log.Fatal(err.Error())
+		return err
+	}
+	for _, part := range s.split(m) {
+		part, err := s.sign(part)
+		if err != nil {
+			return err
+		}
+		out, err := s.marshal(part)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		err = s.send(topic, part, out.Bytes())
+		releaseBuffer(out)
+		if err != nil {
+			s.count("colony.emit_failed", sent.ContentType)
+			return err
+		}
 	}
-	s.producer.Publish(topic, out)
+	if sent.Topic.ContentType == "responses" {
+		s.responded(sent)
+	}
+	s.audit(AuditEmitted, topic, sent)
+	s.count("colony.emitted", sent.ContentType)
+	s.observe("colony.payload_bytes", sent.ContentType, float64(len(sent.Payload)))
 	return nil
 }
 
 // Consume registers the supplied Handler as a reciever of colony Messages of the specified contentType.
 // When the Handler returns the service will no longer recieve messages of this type.
+// A versioned contentType can have a constraint, such as SnakeRequest@>=2, to
+// consume several versions at once.
 func (s Service) Consume(contentType string, h Handler) error {
-	consumer := s.newConsumer(contentType)
-	h(consumer.C)
+	return s.consume(contentType, consumeOptions{}, h)
+}
+
+// consumeOptions change what a consumer receives and how.
+type consumeOptions struct {
+	partitions []int         // if not nil, the only partitions consumed
+	jobs       bool          // if set, each Message is delivered as a Job
+	lease      time.Duration // if set, each Message is held as a Job for this long
+	fixed      bool          // if set, the RuntimeConfig doesn't apply
+	ConsumeOptions
+}
+
+// consume is Consume with opts.
+func (s Service) consume(contentType string, opts consumeOptions, h Handler) error {
+	if s.lifecycle.isClosed() {
+		return ErrClosed
+	}
+	if !s.allowed(ActionConsume, s.Name, contentType) {
+		return ErrForbidden
+	}
+	if err := checkContentType(contentType); err != nil {
+		return err
+	}
+	s.describer.consume(contentType, 1)
+	defer s.describer.consume(contentType, -1)
+	if _, ok := s.Describe().Accepts[contentType]; ok {
+		// let producers know which formats to negotiate
+		if err := s.presence("describe", s.Describe()); err != nil {
+			log.Println("COLONY\t", s.Name, "could not describe itself:", err)
+		}
+	}
+	ctl := newConsumerControl(contentType, opts.ConsumeOptions)
+	if !opts.fixed {
+		defer s.control(ctl)()
+	}
+	consumer := s.newConsumer(contentType, opts, ctl)
+	ctl.dispatch(h, consumer.C)
 	return nil
 }
 
 type queueConsumer struct {
-	C chan Message
+	C           chan Message
+	s           Service
+	opts        consumeOptions
+	ctl         *consumerControl // if set, the runtime settings applied
+	contentType string           // the content type of the topic consumed, if consuming one
 }
 
-func (c queueConsumer) HandleMessage(m *nsq.Message) error {
-	var out Message
-	err := json.Unmarshal(m.Body, &out)
-	if err != nil {
-		log.Fatal(err.Error())
+func (c queueConsumer) handle(body []byte) error {
+	out, sc, err := c.decode(body)
+	switch {
+	case errors.Is(err, ErrNotColony):
+		log.Println("COLONY	 dropping a message that isn't from colony")
+		return nil
+	case errors.Is(err, ErrUnsupportedFrame):
+		// for a newer consumer on the channel to take
+		log.Println("COLONY	", err)
+		return err
+	case err != nil:
+		c.s.poison(c.contentType, body, err)
+		return nil
+	}
+	if sc != nil {
+		defer sc.release()
+	}
+	if !c.s.accept(out) {
+		return nil
+	}
+	if c.s.dropExpired(out, body) {
+		return nil
+	}
+	out, ok := c.s.assemble(c.s.chunks, out)
+	if !ok {
+		return nil
+	}
+	if c.contentType != "" && c.s.processed(out) {
+		// delivered again after a restart
+		return nil
+	}
+	if c.s.duplicate(out) {
+		// sent again by a requester that got no answer
+		return nil
+	}
+	if c.ctl != nil && !c.ctl.admit(out) {
+		return nil
+	}
+	if c.opts.jobs || c.opts.lease > 0 || c.opts.Ack == AckManual {
+		return c.backoff(c.handleJob(out))
 	}
 	c.C <- out
 	return nil
@@ -372,109 +802,193 @@ type consumer struct {
 	ContentType string
 }
 
-type lookupdTopics struct {
-	Topics []string
-}
-
-type lookupdTopic struct {
-	Status_code int
-	Status_txt  string
-	Data        lookupdTopics
-}
-
 func (s Service) lookupTopics(contentType string) []string {
-	resp, err := http.Get("http://" + s.nsqLookupdHTTPAddr + "/topics")
-	if err != nil {
-		log.Fatal(err.Error())
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	var t lookupdTopic
-	err = json.Unmarshal(body, &t)
+	topics, err := s.discoverTopics(contentType)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
-	var out []string
-	for _, topic := range t.Data.Topics {
-		if strings.HasSuffix(topic, contentType) {
-			out = append(out, topic)
-		}
-	}
-	return out
+	return topics
 }
 
 // newConsumer returns a colony consumer of the specified contentType. The new
 // consumer is hooked up and ready to go - messages will appear immediately on
 // its channel.
-func (s Service) newConsumer(contentType string) consumer {
-	inbound := make(chan Message)
-	conf := nsq.NewConfig()
+func (s Service) newConsumer(contentType string, opts consumeOptions, ctl *consumerControl) consumer {
+	buffer := s.buffers.Consumer
+	if opts.Buffer > 0 {
+		buffer = opts.Buffer
+	}
+	inbound := make(chan Message, buffer)
+	handlers := s.handlerConcurrency
+	if opts.Concurrency == 1 {
+		// asked to be handled in order
+		handlers = 1
+	}
 
 	consumer := consumer{
 		C:           inbound,
 		ContentType: contentType,
 	}
 
-	// find existing topcis of that contetType
-	topicsToConsume := s.lookupTopics(contentType)
+	// each topic is consumed along with its priority lanes
+	lanes := make([]chan Message, len(laneTopics("")))
+	for i := range lanes {
+		lanes[i] = make(chan Message)
+	}
+	go drainLanes(lanes, inbound, s.lifecycle.done)
+
+	// listen for announcements before looking for topics, so that none
+	// created in between are missed
+	announcements, err := s.watchAnnouncements(contentType)
+	if err == ErrClosed {
+		return consumer
+	}
+	if err != nil {
+		log.Fatal(err.Error())
+	}
 
 	channel := s.Name + "-" + s.ID
-	// create a consumer for each topic that matches
+
+	// take back the Messages this service has sent itself to retry, whose
+	// topics are those of each version consumed
+	retrying := make(map[string]bool)
+	retry := func(concrete string) bool {
+		if retrying[concrete] {
+			return true
+		}
+		retrying[concrete] = true
+		for _, topic := range s.retryTopics(concrete) {
+			c := queueConsumer{
+				C:           lanes[normalLane],
+				s:           s,
+				opts:        opts,
+				ctl:         ctl,
+				contentType: concrete,
+			}
+			sub, err := s.subscribeConcurrent(topic, channel, handlers, c.handle)
+			if err == ErrClosed {
+				return false
+			}
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+			ctl.subscribed(sub)
+		}
+		return true
+	}
+	if _, version := SplitVersion(contentType); version > 0 || !strings.Contains(contentType, "@") {
+		// not a version constraint, so no need to wait for a topic
+		if !retry(contentType) {
+			return consumer
+		}
+	}
+
+	subscribed := make(map[string]bool)
+	subscribe := func(topic string) bool {
+		if subscribed[topic] {
+			return true
+		}
+		concrete := contentType
+		if _, _, ct, ok := SplitTopic(topic); ok {
+			concrete = ct
+		}
+		if !retry(concrete) {
+			return false
+		}
+		topics := []string{topic}
+		if opts.partitions != nil {
+			topics = partitionTopics(topic, opts.partitions)
+		}
+		for _, topic := range topics {
+			for i, lane := range laneTopics(topic) {
+				c := queueConsumer{
+					C:           lanes[i],
+					s:           s,
+					opts:        opts,
+					ctl:         ctl,
+					contentType: concrete,
+				}
+				sub, err := s.subscribeConcurrent(lane, channel, handlers, c.handle)
+				if err == ErrClosed {
+					return false
+				}
+				if err != nil {
+					log.Fatal(err.Error())
+				}
+				ctl.subscribed(sub)
+			}
+		}
+		log.Println("COLONY\t connecting to topic:", topic)
+		subscribed[topic] = true
+		s.notify(ServiceEvent{Kind: TopicAttached, ContentType: contentType, Topic: topic})
+		return true
+	}
+
+	// find existing topcis of that contetType, both those lookupd knows of
+	// and those announced since the service started
+	topicsToConsume := append(s.lookupTopics(contentType), s.registry.topics(contentType)...)
 	for _, topic := range topicsToConsume {
-		c, err := nsq.NewConsumer(topic, channel, conf)
-		if err != nil {
-			log.Fatal(err.Error())
+		if !subscribe(topic) {
+			return consumer
 		}
-		c.AddHandler(queueConsumer{
-			C: inbound,
-		})
-		c.ConnectToNSQLookupd(s.nsqLookupdHTTPAddr)
 	}
 
 	// begin the watch for new topics of this content type
-	go s.watchForContentType(contentType, inbound)
+	go s.watchForContentType(contentType, announcements, subscribe)
 
 	// return the consumer to the caller
 	return consumer
 }
 
-func (s Service) watchForContentType(contentType string, inbound chan Message) {
-	channel := s.Name + "-" + s.ID + "-" + contentType
-
-	s.createTopic("colony-announce") // just in case
+// watchAnnouncements returns the announcements of contentType made from now
+// on.
+func (s Service) watchAnnouncements(contentType string) (<-chan Message, error) {
+	channel := s.Name + "-" + s.ID + "-" + channelContentType(contentType)
 
-	conf := nsq.NewConfig()
+	s.transport.CreateTopic("colony-announce") // just in case
 
 	// connect to the colonly-announce topic
-	c, err := nsq.NewConsumer("colony-announce", channel, conf)
-	if err != nil {
-		log.Fatal(err.Error())
-	}
-	announcements := make(chan Message)
-	c.AddHandler(queueConsumer{
+	announcements := make(chan Message, s.buffers.Announcements)
+	c := queueConsumer{
 		C: announcements,
-	})
-	c.ConnectToNSQLookupd(s.nsqLookupdHTTPAddr)
+		s: s,
+	}
+	_, err := s.subscribe("colony-announce", channel, c.handle)
+	return announcements, err
+}
 
-	// listen for new announcements
+// watchForContentType subscribes to each new topic of contentType as it is
+// announced or discovered, until the service is closed.
+func (s Service) watchForContentType(contentType string, announcements <-chan Message, subscribe func(topic string) bool) {
+	// look again for topics whose announcements were missed
+	ticker := time.NewTicker(s.topics.interval)
+	defer ticker.Stop()
 	for {
-		msg := <-announcements
-
-		// if the announcement isn't about this contentType we're not interested
-		if msg.ContentType != contentType {
-			continue
+		var topics []string
+		select {
+		case msg := <-announcements:
+			// if the announcement isn't about this contentType we're not interested
+			if !matchContentType(contentType, msg.ContentType) {
+				continue
+			}
+			topics = []string{msg.Topic.getName()}
+		case <-ticker.C:
+			var err error
+			topics, err = s.discoverTopics(contentType)
+			if err != nil {
+				log.Println("COLONY\t could not look for topics of", contentType+":", err)
+				s.notify(ServiceEvent{Kind: TopicSearchFailed, ContentType: contentType, Err: err})
+			}
+		case <-s.lifecycle.done:
+			return
 		}
 
-		// if the announcement is about this content type, then we need to associate
-		// this colony consumer with a new nsq.Consumer.
-		log.Println("COLONY\t connecting to new topic:", msg.Topic.getName())
-		c, err := nsq.NewConsumer(msg.Topic.getName(), s.Name+"-"+s.ID, conf)
-		if err != nil {
-			log.Fatal(err.Error())
+		// associate this colony consumer with a subscription to each of the
+		// topics, unless it has one already
+		for _, topic := range topics {
+			if !subscribe(topic) {
+				return
+			}
 		}
-		c.AddHandler(queueConsumer{
-			C: inbound,
-		})
-		c.ConnectToNSQLookupd(s.nsqLookupdHTTPAddr)
 	}
 }
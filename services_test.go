@@ -0,0 +1,79 @@
+package colony
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewMessageIDsUnique(t *testing.T) {
+	s := NewService("ids", "1", "", WithTransport(NewMemoryTransport()))
+	defer s.Close()
+
+	const goroutines, each = 16, 500
+	ids := make(chan messageID, goroutines*each)
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				ids <- s.NewMessage("idtest", nil).MessageID
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[messageID]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("MessageID %s handed out twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestConsumeVersionConstraint(t *testing.T) {
+	transport := NewMemoryTransport()
+	producer := NewService("producer", "1", "", WithTransport(transport))
+	defer producer.Close()
+	consumer := NewService("consumer", "1", "", WithTransport(transport))
+	defer consumer.Close()
+
+	got := make(chan Message, 1)
+	consumed := make(chan error, 1)
+	go func() {
+		consumed <- consumer.Consume("Foo@>=2", func(msgs <-chan Message) error {
+			for m := range msgs {
+				select {
+				case got <- m:
+				default:
+				}
+			}
+			return nil
+		})
+	}()
+
+	if err := producer.Announce("Foo@2"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.After(5 * time.Second)
+	for {
+		if err := producer.Emit(producer.NewMessage("Foo@2", []byte("hello"))); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case m := <-got:
+			if m.ContentType != "Foo@2" || string(m.Payload) != "hello" {
+				t.Fatalf("got %s %q, want Foo@2 %q", m.ContentType, m.Payload, "hello")
+			}
+			return
+		case err := <-consumed:
+			t.Fatal("Consume returned:", err)
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("no Foo@2 message consumed")
+		}
+	}
+}
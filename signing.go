@@ -0,0 +1,229 @@
+package colony
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrUnsigned is returned when a Verifier receives a Message with no signature.
+	ErrUnsigned = errors.New("message is not signed")
+	// ErrBadSignature is returned when a Message's signature does not match its contents.
+	ErrBadSignature = errors.New("message signature is invalid")
+	// ErrUnknownSigner is returned when a Verifier holds no key for the claimed FromName.
+	ErrUnknownSigner = errors.New("no key for message sender")
+)
+
+// A Signer produces a signature over the envelope of an outbound Message.
+type Signer interface {
+	Sign(data []byte) ([]byte, error)
+}
+
+// A Verifier checks that a signature over an inbound Message's envelope was
+// made by the service named in FromName.
+type Verifier interface {
+	Verify(fromName string, data, sig []byte) error
+}
+
+// A RejectHandler is called with each Message a service refuses to deliver to
+// its Handlers, along with the reason it was refused.
+type RejectHandler func(m Message, err error)
+
+func logReject(m Message, err error) {
+	log.Println("COLONY\t rejected", m.ContentType, "message", m.MessageID, "from", m.FromName+":", err)
+}
+
+// signingBytes returns the canonical bytes of the envelope that are covered by
+// its signature: the routing fields, the time, the payload or its reference,
+// its chunk, its format, where it has been relayed from, its correlation ID,
+// its priority, its key and its expiry.
+func (m Message) signingBytes() []byte {
+	var b bytes.Buffer
+	field := func(p []byte) {
+		var n [8]byte
+		binary.BigEndian.PutUint64(n[:], uint64(len(p)))
+		b.Write(n[:])
+		b.Write(p)
+	}
+	var t [8]byte
+	binary.BigEndian.PutUint64(t[:], uint64(m.Time.UnixNano()))
+	field([]byte(m.FromName))
+	field(t[:])
+	field([]byte(m.ContentType))
+	field([]byte(m.MessageID))
+	field([]byte(m.Topic.getName()))
+	field([]byte(m.ResponseTopic.getName()))
+	field(m.Payload)
+	field([]byte(m.PayloadRef))
+	if m.Chunk != nil {
+		field([]byte(fmt.Sprintf("%s/%d/%d", m.Chunk.Set, m.Chunk.Index, m.Chunk.Count)))
+	}
+	if m.Format != nil {
+		field([]byte("format " + m.Format.String()))
+	}
+	if m.Origin != "" || len(m.Via) > 0 {
+		field([]byte("origin " + m.Origin))
+		field([]byte("via " + strings.Join(m.Via, ",")))
+	}
+	if m.OriginDC != "" || m.Hops > 0 {
+		field([]byte(fmt.Sprintf("dc %s/%d", m.OriginDC, m.Hops)))
+	}
+	if m.CorrelationID != "" {
+		field([]byte("correlation " + m.CorrelationID))
+	}
+	if m.Priority != PriorityNormal {
+		field([]byte("priority " + strconv.Itoa(m.Priority)))
+	}
+	if m.Key != "" {
+		field([]byte("key " + m.Key))
+	}
+	if m.Expiry != nil {
+		field([]byte("expiry " + strconv.FormatInt(m.Expiry.UnixNano(), 10)))
+	}
+	if m.Deadline != nil {
+		field([]byte("deadline " + strconv.FormatInt(m.Deadline.UnixNano(), 10)))
+	}
+	if m.Error != nil {
+		e := m.Error
+		field([]byte(fmt.Sprintf("error %s/%s/%s/%t %s", e.Service, e.Origin, e.Code, e.Retryable, e.Message)))
+	}
+	if len(m.Headers) > 0 {
+		keys := make([]string, 0, len(m.Headers))
+		for k := range m.Headers {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			field([]byte("header " + k + ": " + m.Headers[k]))
+		}
+	}
+	return b.Bytes()
+}
+
+// sign attaches a signature to m if the service has a Signer or an Identity.
+func (s Service) sign(m Message) (Message, error) {
+	if s.identity != nil {
+		return s.signIdentity(m)
+	}
+	if s.signer == nil {
+		return m, nil
+	}
+	m.Signature = nil
+	sig, err := s.signer.Sign(m.signingBytes())
+	if err != nil {
+		return m, err
+	}
+	m.Signature = sig
+	return m, nil
+}
+
+// accept reports whether an inbound Message should be delivered to handlers.
+// Messages that are refused are passed to the service's RejectHandler.
+func (s Service) accept(m Message) bool {
+	if err := s.check(m); err != nil {
+		s.reject(m, err)
+		return false
+	}
+	return true
+}
+
+// check returns the reason an inbound Message must not be delivered, if any.
+// The sender is authenticated before the service's Policy is consulted.
+func (s Service) check(m Message) error {
+	if s.identityRoots != nil {
+		if err := verifyIdentity(m, s.identityRoots); err != nil {
+			return err
+		}
+	}
+	if s.verifier != nil {
+		if err := s.verifier.Verify(m.FromName, m.signingBytes(), m.Signature); err != nil {
+			return err
+		}
+	}
+	if !s.allowed(ActionEmit, m.FromName, m.ContentType) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+func (s Service) reject(m Message, err error) {
+	s.count("colony.rejected", m.ContentType)
+	s.notify(ServiceEvent{Kind: Rejected, ContentType: m.ContentType, Topic: m.Topic.getName(), MessageID: string(m.MessageID), Err: err})
+	if s.rejectHandler != nil {
+		s.rejectHandler(m, err)
+	}
+}
+
+// HMACSigner signs messages with HMAC-SHA256 using a key shared with the
+// services that verify them.
+type HMACSigner struct {
+	Key []byte
+}
+
+// Sign returns the HMAC-SHA256 of data.
+func (h HMACSigner) Sign(data []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, h.Key)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+// HMACVerifier verifies HMAC-SHA256 signatures using a shared key per service
+// name.
+type HMACVerifier struct {
+	Keys map[string][]byte // keyed by service name
+}
+
+// Verify checks sig against the key held for fromName.
+func (h HMACVerifier) Verify(fromName string, data, sig []byte) error {
+	if len(sig) == 0 {
+		return ErrUnsigned
+	}
+	key, ok := h.Keys[fromName]
+	if !ok {
+		return ErrUnknownSigner
+	}
+	expected, _ := HMACSigner{Key: key}.Sign(data)
+	if !hmac.Equal(expected, sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// Ed25519Signer signs messages with a service's Ed25519 private key.
+type Ed25519Signer struct {
+	Key ed25519.PrivateKey
+}
+
+// Sign returns the Ed25519 signature of data.
+func (e Ed25519Signer) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(e.Key, data), nil
+}
+
+// Ed25519Verifier verifies Ed25519 signatures using each service's public key.
+type Ed25519Verifier struct {
+	Keys map[string]ed25519.PublicKey // keyed by service name
+}
+
+// Verify checks sig against the public key held for fromName.
+func (e Ed25519Verifier) Verify(fromName string, data, sig []byte) error {
+	if len(sig) == 0 {
+		return ErrUnsigned
+	}
+	key, ok := e.Keys[fromName]
+	if !ok {
+		return ErrUnknownSigner
+	}
+	if !ed25519.Verify(key, data, sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
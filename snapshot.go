@@ -0,0 +1,72 @@
+package colony
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// A Snapshotter saves the state of a stateful consumer, such as the windows of
+// an aggregation, every Interval and when its service is closed, so that the
+// consumer can pick up where it left off when it restarts rather than
+// starting again empty.
+//
+// Marshal and Unmarshal are called from the Snapshotter's own goroutine, so
+// they must lock whatever state the consumer shares with them.
+type Snapshotter struct {
+	Service   *Service
+	Name      string
+	Store     Checkpointer
+	Interval  time.Duration
+	Marshal   func() ([]byte, error)
+	Unmarshal func([]byte) error
+}
+
+// Start restores the last snapshot, if there is one, and then takes a
+// snapshot every Interval until the service is closed, and a last one as it
+// closes. Start it before consuming, so that Messages are applied to the
+// restored state. It returns an error if Interval isn't positive.
+func (sn *Snapshotter) Start() error {
+	if sn.Interval <= 0 {
+		return errors.New("snapshot interval must be positive")
+	}
+	state, err := sn.Store.LoadCheckpoint(sn.Name)
+	if err != nil {
+		return err
+	}
+	if state != nil {
+		if err := sn.Unmarshal(state); err != nil {
+			return err
+		}
+	}
+	if !sn.Service.lifecycle.atClose(func() { sn.Save() }) {
+		return ErrClosed
+	}
+	go sn.run()
+	return nil
+}
+
+func (sn *Snapshotter) run() {
+	ticker := time.NewTicker(sn.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			sn.Save()
+		case <-sn.Service.lifecycle.done:
+			return
+		}
+	}
+}
+
+// Save takes a snapshot now.
+func (sn *Snapshotter) Save() error {
+	state, err := sn.Marshal()
+	if err == nil {
+		err = sn.Store.SaveCheckpoint(sn.Name, state)
+	}
+	if err != nil {
+		log.Println("COLONY\t could not snapshot", sn.Name+":", err)
+	}
+	return err
+}
@@ -0,0 +1,130 @@
+package colony
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// A Spool durably holds encoded Messages that couldn't be published, in the
+// order they were added, until they can be. The boltspool package has a Spool
+// kept in a local file.
+type Spool interface {
+	// Append adds body, to be published on topic, to the end of the spool.
+	Append(topic string, body []byte) error
+	// Oldest returns the entry at the front of the spool, or false if the
+	// spool is empty.
+	Oldest() (SpoolEntry, bool, error)
+	// Remove takes the entry numbered seq out of the spool.
+	Remove(seq uint64) error
+}
+
+// A SpoolEntry is an encoded Message held by a Spool.
+type SpoolEntry struct {
+	Seq   uint64
+	Topic string
+	Body  []byte
+}
+
+// spoolReplayInterval is how often a service tries to publish what it has
+// spooled.
+const spoolReplayInterval = time.Second
+
+// WithSpool makes the service write Messages it fails to publish to sp, after
+// any retries, and publish them from there in order once the transport takes
+// them again, so that short broker outages lose nothing. While anything is
+// spooled new Messages are spooled behind it, and Emit only fails if the spool
+// does. Messages left in sp by an earlier run are published when the service
+// starts.
+func WithSpool(sp Spool) Option {
+	return func(s *Service) {
+		s.spool = &spooler{Spool: sp, pending: true}
+	}
+}
+
+// spooler serialises publishing through a Spool, so that spooled Messages are
+// published before any sent after them.
+type spooler struct {
+	Spool
+	mu      sync.Mutex
+	pending bool // whether anything might be in the spool
+}
+
+// send publishes body, the encoding of m, on topic, or holds it back to be
+// published with others if the service coalesces.
+func (s Service) send(topic string, m Message, body []byte) error {
+	if s.coalescer != nil {
+		return s.coalesce(topic, m, body)
+	}
+	return s.deliver(topic, m, body)
+}
+
+// deliver publishes body, the encoding of m, on topic, retrying according to
+// the service's RetryPolicy and spooling it if that fails. Messages sent while
+// anything is spooled are spooled behind it without being tried.
+func (s Service) deliver(topic string, m Message, body []byte) error {
+	sp := s.spool
+	if sp == nil {
+		return s.retrying(topic, m, body)
+	}
+	sp.mu.Lock()
+	if !sp.pending {
+		// publish without the lock, so that other Messages needn't wait
+		// out this one's retries; one that fails while this is retrying
+		// may be spooled ahead of it
+		sp.mu.Unlock()
+		err := s.retrying(topic, m, body)
+		if err == nil {
+			return nil
+		}
+		log.Println("COLONY\t spooling", m.ContentType, "message", m.MessageID+":", err)
+		sp.mu.Lock()
+	}
+	defer sp.mu.Unlock()
+	// body is the caller's to reuse
+	if err := sp.Append(topic, append([]byte(nil), body...)); err != nil {
+		return err
+	}
+	s.count("colony.spooled", m.ContentType)
+	sp.pending = true
+	return nil
+}
+
+// replaySpool publishes what the service has spooled until it is closed.
+func (s Service) replaySpool() {
+	ticker := time.NewTicker(spoolReplayInterval)
+	defer ticker.Stop()
+	for {
+		s.spool.replay(s.transport)
+		select {
+		case <-ticker.C:
+		case <-s.lifecycle.done:
+			return
+		}
+	}
+}
+
+// replay publishes spooled entries in order until the spool is empty or
+// publishing fails.
+func (sp *spooler) replay(t Transport) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	for sp.pending {
+		e, ok, err := sp.Oldest()
+		if err != nil {
+			log.Println("COLONY\t could not read spool:", err)
+			return
+		}
+		if !ok {
+			sp.pending = false
+			return
+		}
+		if err := t.Publish(e.Topic, e.Body); err != nil {
+			return
+		}
+		if err := sp.Remove(e.Seq); err != nil {
+			log.Println("COLONY\t could not remove spooled message:", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package colony
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SSEHandler is an http.Handler streaming colony Messages to clients as
+// Server-Sent Events, for live debugging pages and ops dashboards. Each event
+// is named after its content type and carries a JSON object describing the
+// Message. Clients can pick some of the handler's content types with one or
+// more contentType query parameters.
+type SSEHandler struct {
+	Service      *Service
+	ContentTypes []string
+
+	mu      sync.Mutex
+	clients map[string]map[chan Message]bool
+}
+
+type sseEvent struct {
+	From        string    `json:"from"`
+	ContentType string    `json:"contentType"`
+	MessageID   string    `json:"messageId"`
+	Time        time.Time `json:"time"`
+	Payload     string    `json:"payload"`
+}
+
+// NewSSEHandler returns an SSEHandler for contentTypes and starts tapping
+// them, so that it takes no Messages from the colony's consumers and leaves no
+// channels behind when the service stops.
+func NewSSEHandler(s *Service, contentTypes ...string) *SSEHandler {
+	h := &SSEHandler{
+		Service:      s,
+		ContentTypes: contentTypes,
+		clients:      make(map[string]map[chan Message]bool),
+	}
+	for _, contentType := range contentTypes {
+		h.clients[contentType] = make(map[chan Message]bool)
+		go h.consume(contentType)
+	}
+	return h
+}
+
+func (h *SSEHandler) consume(contentType string) {
+	// tapped for as long as the service runs
+	msgs, _ := h.Service.Tap(contentType)
+	for m := range msgs {
+		h.mu.Lock()
+		for c := range h.clients[contentType] {
+			// clients that are behind miss messages
+			select {
+			case c <- m:
+			default:
+			}
+		}
+		h.mu.Unlock()
+	}
+}
+
+// ServeHTTP streams events to the client until it disconnects.
+func (h *SSEHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	wanted := r.URL.Query()["contentType"]
+	if len(wanted) == 0 {
+		wanted = h.ContentTypes
+	}
+
+	c := make(chan Message, 64)
+	h.mu.Lock()
+	for _, contentType := range wanted {
+		if clients, ok := h.clients[contentType]; ok {
+			clients[c] = true
+		}
+	}
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		for _, clients := range h.clients {
+			delete(clients, c)
+		}
+		h.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+	for {
+		select {
+		case m := <-c:
+			writeSSE(w, flusher, m)
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeSSE writes m to w as a Server-Sent Event named after its content type.
+// Messages whose payload can't be fetched are skipped.
+func writeSSE(w http.ResponseWriter, flusher http.Flusher, m Message) {
+	payload, err := m.FetchPayload()
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(sseEvent{
+		From:        m.FromName,
+		ContentType: m.ContentType,
+		MessageID:   string(m.MessageID),
+		Time:        m.Time,
+		Payload:     string(payload),
+	})
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\nid: %s\ndata: %s\n\n", m.ContentType, m.MessageID, data)
+	flusher.Flush()
+}
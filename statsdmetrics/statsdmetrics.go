@@ -0,0 +1,118 @@
+// Package statsdmetrics provides colony Metrics sent to a StatsD server, or to
+// the Datadog agent with its tag extension.
+package statsdmetrics
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultAddr is where StatsD servers and the Datadog agent listen by default.
+const DefaultAddr = "localhost:8125"
+
+// Client sends each measurement to a StatsD server as a UDP packet. Packets
+// that can't be sent are dropped, as StatsD clients do.
+type Client struct {
+	Prefix string            // prepended to every name, as in myapp.
+	Tags   map[string]string // sent with every measurement, if Datadog is set
+	// Datadog sends tags, which plain StatsD doesn't understand, and
+	// histograms as Datadog histograms rather than timers.
+	Datadog bool
+
+	conn net.Conn
+}
+
+// New returns a Client sending to the StatsD server at addr.
+func New(addr, prefix string) (*Client, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{Prefix: prefix, conn: conn}, nil
+}
+
+// NewDatadog returns a Client sending to the Datadog agent at addr, with tags
+// added to every measurement.
+func NewDatadog(addr, prefix string, tags map[string]string) (*Client, error) {
+	c, err := New(addr, prefix)
+	if err != nil {
+		return nil, err
+	}
+	c.Tags = tags
+	c.Datadog = true
+	return c, nil
+}
+
+// Counter sends delta as a count.
+func (c *Client) Counter(name string, delta int64, tags map[string]string) {
+	c.send(name, strconv.FormatInt(delta, 10), "c", tags)
+}
+
+// Histogram sends value as a histogram, or as a timer to plain StatsD. Timers
+// are in milliseconds, so a duration in seconds, named with _seconds as
+// colony.call_seconds is, is sent to plain StatsD in milliseconds, named with
+// _ms.
+func (c *Client) Histogram(name string, value float64, tags map[string]string) {
+	kind := "ms"
+	if c.Datadog {
+		kind = "h"
+	} else if strings.HasSuffix(name, "_seconds") {
+		name = strings.TrimSuffix(name, "_seconds") + "_ms"
+		value *= 1000
+	}
+	c.send(name, strconv.FormatFloat(value, 'f', -1, 64), kind, tags)
+}
+
+// Gauge sends value as a gauge.
+func (c *Client) Gauge(name string, value float64, tags map[string]string) {
+	c.send(name, strconv.FormatFloat(value, 'f', -1, 64), "g", tags)
+}
+
+// Close closes the Client's connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) send(name, value, kind string, tags map[string]string) {
+	var b strings.Builder
+	b.WriteString(c.Prefix)
+	b.WriteString(name)
+	b.WriteByte(':')
+	b.WriteString(value)
+	b.WriteByte('|')
+	b.WriteString(kind)
+	if c.Datadog {
+		writeTags(&b, c.Tags, tags)
+	}
+	c.conn.Write([]byte(b.String()))
+}
+
+// writeTags writes the Datadog tag section for the tags of every set, in
+// order of key, with later sets taking precedence.
+func writeTags(b *strings.Builder, sets ...map[string]string) {
+	all := make(map[string]string)
+	for _, set := range sets {
+		for k, v := range set {
+			all[k] = v
+		}
+	}
+	if len(all) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(all))
+	for k := range all {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b.WriteString("|#")
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteByte(':')
+		b.WriteString(all[k])
+	}
+}
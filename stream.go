@@ -0,0 +1,256 @@
+package colony
+
+import (
+	"log"
+	"sync"
+)
+
+// A Pipeline is a chain of stages each Message of a content type passes
+// through as it is consumed. Start one with Stream, add stages with Filter,
+// Map, FlatMap and Process, and run it with EmitAs or ForEach:
+//
+//	colony.Stream(s, "bees").Filter(f).Map(g).EmitAs("honey")
+//
+// Each stage runs in goroutines of its own, configured with StageOptions.
+type Pipeline struct {
+	s           *Service
+	contentType string
+	stages      []stage
+}
+
+// ErrorPolicy is what a stage does with a Message it fails to process.
+type ErrorPolicy int
+
+// The ErrorPolicies.
+const (
+	// DropOnError logs the error and drops the Message.
+	DropOnError ErrorPolicy = iota
+	// StopOnError stops the pipeline, returning the error from ForEach or
+	// EmitAs.
+	StopOnError
+)
+
+// A StageOption configures a stage of a Pipeline.
+type StageOption func(*stage)
+
+// Concurrency runs n copies of the stage at once. Messages can come out of a
+// stage with a concurrency over 1 in a different order than they went in.
+func Concurrency(n int) StageOption {
+	return func(st *stage) {
+		if n > 0 {
+			st.concurrency = n
+		}
+	}
+}
+
+// Buffer lets up to n Messages queue up for the stage while it is busy.
+func Buffer(n int) StageOption {
+	return func(st *stage) {
+		st.buffer = n
+	}
+}
+
+// Retry tries a failing Message up to n more times before the stage's
+// ErrorPolicy applies.
+func Retry(n int) StageOption {
+	return func(st *stage) {
+		st.retries = n
+	}
+}
+
+// OnError sets the stage's ErrorPolicy. The default is DropOnError.
+func OnError(policy ErrorPolicy) StageOption {
+	return func(st *stage) {
+		st.onError = policy
+	}
+}
+
+type stage struct {
+	process     func(Message) ([]Message, error)
+	concurrency int
+	buffer      int
+	retries     int
+	onError     ErrorPolicy
+}
+
+// Stream returns a Pipeline over the Messages of contentType consumed by s.
+func Stream(s *Service, contentType string) *Pipeline {
+	return &Pipeline{s: s, contentType: contentType}
+}
+
+// Filter drops the Messages f returns false for.
+func (p *Pipeline) Filter(f func(Message) bool, opts ...StageOption) *Pipeline {
+	return p.Process(func(m Message) ([]Message, error) {
+		if !f(m) {
+			return nil, nil
+		}
+		return []Message{m}, nil
+	}, opts...)
+}
+
+// Map replaces each Message with the one f makes of it.
+func (p *Pipeline) Map(f func(Message) Message, opts ...StageOption) *Pipeline {
+	return p.Process(func(m Message) ([]Message, error) {
+		return []Message{f(m)}, nil
+	}, opts...)
+}
+
+// FlatMap replaces each Message with the ones f makes of it, which may be
+// none.
+func (p *Pipeline) FlatMap(f func(Message) []Message, opts ...StageOption) *Pipeline {
+	return p.Process(func(m Message) ([]Message, error) {
+		return f(m), nil
+	}, opts...)
+}
+
+// Process replaces each Message with the ones f makes of it, like FlatMap, for
+// stages that can fail.
+func (p *Pipeline) Process(f func(Message) ([]Message, error), opts ...StageOption) *Pipeline {
+	st := stage{process: f, concurrency: 1}
+	for _, opt := range opts {
+		opt(&st)
+	}
+	stages := append(p.stages[:len(p.stages):len(p.stages)], st)
+	return &Pipeline{s: p.s, contentType: p.contentType, stages: stages}
+}
+
+// EmitAs announces contentType and emits the payload of each Message coming
+// out of the pipeline as a new Message of it. Like Consume, it blocks while
+// the pipeline runs.
+func (p *Pipeline) EmitAs(contentType string) error {
+	if err := p.s.Announce(contentType); err != nil {
+		return err
+	}
+	return p.ForEach(func(m Message) error {
+		out := p.s.NewMessage(contentType, m.Payload)
+		out.CorrelationID = m.CorrelationID
+		return p.s.Emit(out)
+	})
+}
+
+// ForEach calls f with each Message coming out of the pipeline. It blocks
+// while the pipeline runs, and returns the first error f returns or a
+// StopOnError stage fails with.
+func (p *Pipeline) ForEach(f func(Message) error) error {
+	var (
+		once    sync.Once
+		stopErr error
+	)
+	done := make(chan struct{})
+	stop := func(err error) {
+		once.Do(func() {
+			stopErr = err
+			close(done)
+		})
+	}
+
+	err := p.s.Consume(p.contentType, func(c <-chan Message) error {
+		// each stage's buffer is on the channel into it
+		buffer := func(i int) int {
+			if i < len(p.stages) {
+				return p.stages[i].buffer
+			}
+			return 0
+		}
+		in := make(chan Message, buffer(0))
+		var out <-chan Message = in
+		for i, st := range p.stages {
+			next := make(chan Message, buffer(i+1))
+			go st.run(out, next, done, stop)
+			out = next
+		}
+
+		go func() {
+			for {
+				select {
+				case m, ok := <-out:
+					if !ok {
+						return
+					}
+					if err := f(m); err != nil {
+						stop(err)
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+		for {
+			select {
+			case m, ok := <-c:
+				if !ok {
+					// retired as the consumer's concurrency fell; let the
+					// stages finish what they have
+					close(in)
+					return nil
+				}
+				select {
+				case in <- m:
+				case <-done:
+					return nil
+				}
+			case <-done:
+				return nil
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return stopErr
+}
+
+// run processes the Messages from in with the stage's concurrency, and sends
+// what comes of them to out until in or done is closed, closing out when it
+// stops.
+func (st stage) run(in <-chan Message, out chan<- Message, done <-chan struct{}, stop func(error)) {
+	var wg sync.WaitGroup
+	for i := 0; i < st.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var m Message
+				var ok bool
+				select {
+				case m, ok = <-in:
+				case <-done:
+					return
+				}
+				if !ok {
+					// the stage before has finished
+					return
+				}
+				msgs, err := st.apply(m)
+				if err != nil {
+					if st.onError == StopOnError {
+						stop(err)
+						return
+					}
+					log.Println("COLONY\t pipeline dropped a message of", m.ContentType+":", err)
+					continue
+				}
+				for _, m := range msgs {
+					select {
+					case out <- m:
+					case <-done:
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(out)
+}
+
+// apply processes m, retrying as many times as the stage allows.
+func (st stage) apply(m Message) ([]Message, error) {
+	for attempt := 0; ; attempt++ {
+		msgs, err := st.process(m)
+		if err == nil || attempt >= st.retries {
+			return msgs, err
+		}
+	}
+}
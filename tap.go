@@ -0,0 +1,101 @@
+package colony
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Tap attaches a new channel to every topic of contentType, including topics
+// announced later, and returns the Messages arriving on it. Because the
+// channel is its own, tapping never takes messages away from the colony's
+// consumers, so ops tooling can use it to peek at traffic. The channel is
+// ephemeral: calling the returned function detaches it, after which nothing
+// more is delivered.
+func (s Service) Tap(contentType string) (<-chan Message, func()) {
+	channel := fmt.Sprintf("%s-%s-tap-%x#ephemeral", s.Name, s.ID, s.randInt63())
+	out := make(chan Message)
+	done := make(chan struct{})
+	chunks := newReassembler(time.Minute)
+
+	var mu sync.Mutex
+	var subs []Subscription
+	tapped := make(map[string]bool) // topics subscribed to, or being
+	var stopped bool
+	handle := func(body []byte) error {
+		var m Message
+		if err := unmarshalMessage(body, &m); err != nil {
+			return nil
+		}
+		m, ok := s.receiveWith(chunks, m)
+		if !ok {
+			return nil
+		}
+		select {
+		case out <- m:
+		case <-done:
+		}
+		return nil
+	}
+	subscribe := func(topic string, h func([]byte) error) {
+		// a topic is announced again each time its producer restarts
+		mu.Lock()
+		if stopped || tapped[topic] {
+			mu.Unlock()
+			return
+		}
+		tapped[topic] = true
+		mu.Unlock()
+
+		sub, err := s.transport.Subscribe(topic, channel, h)
+		if err != nil {
+			log.Println("COLONY\t could not tap", topic+":", err)
+			mu.Lock()
+			delete(tapped, topic)
+			mu.Unlock()
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if stopped {
+			sub.Stop()
+			return
+		}
+		subs = append(subs, sub)
+	}
+
+	for _, topic := range s.lookupTopics(contentType) {
+		for _, lane := range laneTopics(topic) {
+			subscribe(lane, handle)
+		}
+	}
+	// follow announcements of new topics of this content type
+	subscribe("colony-announce", func(body []byte) error {
+		var m Message
+		if err := json.Unmarshal(body, &m); err != nil {
+			return nil
+		}
+		if matchContentType(contentType, m.ContentType) && s.accept(m) {
+			for _, lane := range laneTopics(m.Topic.getName()) {
+				go subscribe(lane, handle)
+			}
+		}
+		return nil
+	})
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			close(done)
+			mu.Lock()
+			stopped = true
+			for _, sub := range subs {
+				sub.Stop()
+			}
+			mu.Unlock()
+		})
+	}
+	return out, stop
+}
@@ -0,0 +1,112 @@
+package colony
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WithContentTypeDoc sets doc, a sentence or two on what Messages of
+// contentType mean, for the service to describe the content type with in its
+// announcements. Operators looking at a topic in nsqadmin can then find out
+// what it carries through a Registry or an Admin's /topics page.
+func WithContentTypeDoc(contentType, doc string) Option {
+	return func(s *Service) {
+		s.describer.docs[contentType] = doc
+	}
+}
+
+// TopicInfo maps the name of an NSQ topic back to the colony service that
+// produces it and what it carries.
+type TopicInfo struct {
+	Topic       string
+	Service     string
+	ID          string
+	ContentType string
+	Partition   *int              `json:",omitempty"` // set for a partition of a partitioned content type
+	Lane        string            `json:",omitempty"` // "high" or "low" for a priority lane
+	Doc         string            `json:",omitempty"` // what the content type means, from its producer
+	Version     string            `json:",omitempty"` // the version of the producer
+	Metadata    map[string]string `json:",omitempty"` // the producer's instance metadata
+}
+
+// ParseTopic returns what the name of a colony topic says about it. It
+// reports false for names that aren't colony topics.
+func ParseTopic(name string) (TopicInfo, bool) {
+	info := TopicInfo{Topic: name}
+	rest := name
+	for _, lane := range []string{"high", "low"} {
+		if strings.HasSuffix(rest, "."+lane) {
+			info.Lane = lane
+			rest = strings.TrimSuffix(rest, "."+lane)
+		}
+	}
+	if i := strings.LastIndex(rest, ".p"); i > 0 {
+		if p, err := strconv.Atoi(rest[i+2:]); err == nil && p >= 0 {
+			info.Partition = &p
+			rest = rest[:i]
+		}
+	}
+	var ok bool
+	info.Service, info.ID, info.ContentType, ok = SplitTopic(rest)
+	return info, ok
+}
+
+// Topic returns what is known of the topic called name: what its name says,
+// and what its producer last announced about the content type.
+func (r *Registry) Topic(name string) (TopicInfo, bool) {
+	info, ok := ParseTopic(name)
+	if !ok {
+		return info, false
+	}
+	if desc, ok := r.Lookup(info.Service, info.ID); ok {
+		describeTopic(&info, desc)
+	}
+	return info, true
+}
+
+// Topics returns the topics produced by the services in the registry that
+// haven't departed, in order of name. Partitions and priority lanes aren't
+// included.
+func (r *Registry) Topics() []TopicInfo {
+	var out []TopicInfo
+	for _, desc := range r.filter(func(d ServiceDescriptor) bool { return !d.Leaving }) {
+		for _, contentType := range desc.Produces {
+			info := TopicInfo{
+				Topic:       topic{desc.Name, desc.ID, contentType}.getName(),
+				Service:     desc.Name,
+				ID:          desc.ID,
+				ContentType: contentType,
+			}
+			describeTopic(&info, desc)
+			out = append(out, info)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Topic < out[j].Topic })
+	return out
+}
+
+func describeTopic(info *TopicInfo, desc ServiceDescriptor) {
+	info.Doc = desc.Docs[info.ContentType]
+	info.Version = desc.Version
+	info.Metadata = desc.Metadata
+}
+
+// serveTopics serves the topics produced in the colony as JSON, or just the
+// one named by the topic query parameter.
+func (a *Admin) serveTopics(w http.ResponseWriter, r *http.Request) {
+	registry := a.Service.Registry()
+	var v interface{} = registry.Topics()
+	if name := r.URL.Query().Get("topic"); name != "" {
+		info, ok := registry.Topic(name)
+		if !ok {
+			http.Error(w, "not a colony topic", http.StatusNotFound)
+			return
+		}
+		v = info
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,132 @@
+package colony
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultTopicRefresh is how often a service asks its transport for the topics
+// it knows of, once the service has needed them.
+const DefaultTopicRefresh = 30 * time.Second
+
+// WithTopicRefresh makes the service refresh its list of topics every interval
+// rather than every DefaultTopicRefresh. An interval that isn't positive
+// leaves it at DefaultTopicRefresh.
+func WithTopicRefresh(interval time.Duration) Option {
+	return func(s *Service) {
+		if interval > 0 {
+			s.topics.interval = interval
+		}
+	}
+}
+
+// topicCatalog caches the topics known to a service's transport, so that
+// consumers don't each ask lookupd for them. It is refreshed in the
+// background, and learns of topics as they are announced, in case lookupd
+// lags behind.
+type topicCatalog struct {
+	interval time.Duration
+
+	fetch   sync.Mutex // held while fetching the first time
+	mu      sync.Mutex
+	loaded  bool
+	topics  map[string]time.Time // when each topic was last seen
+	fetched time.Time            // when the topics were last refreshed
+}
+
+func newTopicCatalog() *topicCatalog {
+	return &topicCatalog{
+		interval: DefaultTopicRefresh,
+		topics:   make(map[string]time.Time),
+	}
+}
+
+// discoverTopics returns the topics of contentType known to the transport.
+func (s Service) discoverTopics(contentType string) ([]string, error) {
+	c := s.topics
+	if err := s.loadTopics(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out []string
+	for topic := range c.topics {
+		if matchTopic(contentType, topic) {
+			out = append(out, topic)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// loadTopics fetches the topics the first time they are needed, and then
+// keeps them fresh until the service is closed.
+func (s Service) loadTopics() error {
+	c := s.topics
+	c.fetch.Lock()
+	defer c.fetch.Unlock()
+	c.mu.Lock()
+	loaded := c.loaded
+	c.mu.Unlock()
+	if loaded {
+		return nil
+	}
+	if err := s.refreshTopics(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.loaded = true
+	c.mu.Unlock()
+	go s.topicRefresher()
+	return nil
+}
+
+func (s Service) topicRefresher() {
+	ticker := time.NewTicker(s.topics.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.lifecycle.done:
+			return
+		}
+		if err := s.refreshTopics(); err != nil {
+			log.Println("COLONY\t", s.Name, "could not refresh topics:", err)
+		}
+	}
+}
+
+// refreshTopics replaces the catalog with the transport's topics, keeping
+// those announced since the last refresh.
+func (s Service) refreshTopics() error {
+	topics, err := s.transport.Discover()
+	if err != nil {
+		return err
+	}
+	c := s.topics
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for _, topic := range topics {
+		c.topics[topic] = now
+	}
+	for topic, seen := range c.topics {
+		if seen.Before(c.fetched) {
+			delete(c.topics, topic)
+		}
+	}
+	c.fetched = now
+	return nil
+}
+
+// announced adds the topics a service announced to the catalog.
+func (c *topicCatalog) announced(desc ServiceDescriptor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for _, ct := range desc.Produces {
+		c.topics[topic{desc.Name, desc.ID, ct}.getName()] = now
+	}
+}
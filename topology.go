@@ -0,0 +1,146 @@
+package colony
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Topology is the graph of which services feed which in a colony. Services
+// are named name-id, as in their topics.
+type Topology struct {
+	Services []string // every producing or consuming service, in order
+	Edges    []TopologyEdge
+	// Labels holds the instance metadata of services whose
+	// ServiceDescriptor is known, such as the Kubernetes namespace and pod
+	// they run in.
+	Labels map[string]map[string]string `json:",omitempty"`
+}
+
+// A TopologyEdge is a content type flowing from the service producing it to
+// one consuming it.
+type TopologyEdge struct {
+	From        string
+	To          string
+	ContentType string
+}
+
+// DiscoverTopology derives the Topology of the colony using t from the topics
+// announced on it. Consumers are found from the channels of those topics, so
+// only services producing something are found unless t is a StatsTransport.
+func DiscoverTopology(t Transport) (Topology, error) {
+	names, err := t.Discover()
+	if err != nil {
+		return Topology{}, err
+	}
+	var stats []TopicStats
+	if st, ok := t.(StatsTransport); ok {
+		stats, err = st.TopicStats()
+		if err != nil {
+			return Topology{}, err
+		}
+	}
+	return buildTopology(names, stats), nil
+}
+
+// Topology returns the Topology of the service's colony, labelled with the
+// metadata of the services in its Registry.
+func (s Service) Topology() (Topology, error) {
+	t, err := DiscoverTopology(s.transport)
+	if err != nil {
+		return t, err
+	}
+	for _, desc := range s.registry.Services() {
+		name := desc.Name + "-" + desc.ID
+		if len(desc.Metadata) == 0 || !contains(t.Services, name) {
+			continue
+		}
+		if t.Labels == nil {
+			t.Labels = make(map[string]map[string]string)
+		}
+		t.Labels[name] = desc.Metadata
+	}
+	return t, nil
+}
+
+// buildTopology makes a Topology out of topic names and the channels in stats.
+// Response topics, and taps and other ephemeral channels, aren't part of it.
+func buildTopology(names []string, stats []TopicStats) Topology {
+	services := make(map[string]bool)
+	producer := func(name string) (string, string, bool) {
+		service, id, contentType, ok := SplitTopic(name)
+		if !ok || contentType == "responses" {
+			return "", "", false
+		}
+		services[service+"-"+id] = true
+		return service + "-" + id, contentType, true
+	}
+
+	for _, name := range names {
+		producer(name)
+	}
+	var t Topology
+	for _, ts := range stats {
+		from, contentType, ok := producer(ts.Name)
+		if !ok {
+			continue
+		}
+		for _, c := range ts.Channels {
+			if strings.HasSuffix(c.Name, "#ephemeral") {
+				continue
+			}
+			services[c.Name] = true
+			t.Edges = append(t.Edges, TopologyEdge{
+				From:        from,
+				To:          c.Name,
+				ContentType: contentType,
+			})
+		}
+	}
+	for s := range services {
+		t.Services = append(t.Services, s)
+	}
+	sort.Strings(t.Services)
+	sort.Slice(t.Edges, func(i, j int) bool {
+		a, b := t.Edges[i], t.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.ContentType != b.ContentType {
+			return a.ContentType < b.ContentType
+		}
+		return a.To < b.To
+	})
+	return t
+}
+
+// WriteDOT writes the Topology to w as a Graphviz digraph, with edges labelled
+// by content type and services by their Labels.
+func (t Topology) WriteDOT(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	b.WriteString("digraph colony {\n")
+	for _, s := range t.Services {
+		b.WriteString("\t" + strconv.Quote(s))
+		if labels := t.Labels[s]; len(labels) > 0 {
+			label := s
+			keys := make([]string, 0, len(labels))
+			for k := range labels {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				label += "\n" + k + "=" + labels[k]
+			}
+			b.WriteString(" [label=" + strconv.Quote(label) + "]")
+		}
+		b.WriteString(";\n")
+	}
+	for _, e := range t.Edges {
+		b.WriteString("\t" + strconv.Quote(e.From) + " -> " + strconv.Quote(e.To) +
+			" [label=" + strconv.Quote(e.ContentType) + "];\n")
+	}
+	b.WriteString("}\n")
+	return b.Flush()
+}
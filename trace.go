@@ -0,0 +1,225 @@
+package colony
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// traceTopic carries the trace events of services with WithTracing.
+const traceTopic = "colony-trace"
+
+// ParentHeader is the header naming the span of the Message a Message was
+// sent on behalf of: the request a response answers, or the Message whose
+// handler made a request. It is set by NewResponse and ChildOf.
+const ParentHeader = "Colony-Parent"
+
+// maxTraces is how many CorrelationIDs a TraceCollector keeps by default.
+const maxTraces = 1000
+
+// WithTracing has the service emit a trace event, in the form of an
+// AuditRecord, each time it emits or consumes a Message with a CorrelationID,
+// so that a TraceCollector can piece together everything that happened for
+// it.
+func WithTracing() Option {
+	return func(s *Service) {
+		s.tracing = true
+	}
+}
+
+// ChildOf makes m part of the same trace as parent, as the Message whose
+// handler is sending m: m takes parent's CorrelationID, and names parent's
+// span as its own's parent. m also carries on parent's W3C trace, if it has
+// one.
+func (m *Message) ChildOf(parent Message) {
+	m.CorrelationID = parent.CorrelationID
+	m.SetHeader(ParentHeader, parent.Span())
+	m.continueTrace(parent)
+}
+
+// Span identifies m within its trace: the service that sent it, and its
+// MessageID. A response has a span of its own, apart from its request's.
+func (m Message) Span() string {
+	return m.ResponseTopic.ServiceName + "-" + m.ResponseTopic.ServiceID + "/" + string(m.MessageID)
+}
+
+// trace publishes r, the record of m being emitted or consumed, as a trace
+// event if the service traces and m has a CorrelationID.
+func (s Service) trace(r AuditRecord) {
+	if !s.tracing || r.CorrelationID == "" {
+		return
+	}
+	body, err := json.Marshal(r)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if err := s.transport.Publish(traceTopic, body); err != nil {
+		log.Println("COLONY\t could not trace", r.ContentType, "message", r.MessageID+":", err)
+	}
+}
+
+// A TraceSpan is one Message of a trace, with what became of it.
+type TraceSpan struct {
+	Span        string
+	From        string // name-id of the service that emitted it
+	ContentType string
+	Emitted     time.Time     `json:",omitempty"`
+	Consumed    []TraceHandle `json:",omitempty"` // in order of Time
+	Children    []*TraceSpan  `json:",omitempty"` // responses and Messages sent on its behalf, in order of Time
+}
+
+// A TraceHandle is a service consuming the Message of a TraceSpan.
+type TraceHandle struct {
+	Service string // name-id
+	Time    time.Time
+}
+
+// start is the earliest the span is known to have happened.
+func (t *TraceSpan) start() time.Time {
+	if !t.Emitted.IsZero() || len(t.Consumed) == 0 {
+		return t.Emitted
+	}
+	return t.Consumed[0].Time
+}
+
+// A TraceCollector follows the trace events of every service with
+// WithTracing, and pieces them together into the tree of Messages sent for
+// each CorrelationID.
+type TraceCollector struct {
+	Service *Service
+
+	mu     sync.Mutex
+	events map[string][]AuditRecord // by CorrelationID
+	order  []string                 // CorrelationIDs, oldest first
+	max    int
+}
+
+// NewTraceCollector returns a TraceCollector following trace events through
+// s, keeping the events of the most recent max CorrelationIDs, or of 1000 if
+// max is 0.
+func NewTraceCollector(s *Service, max int) *TraceCollector {
+	if max <= 0 {
+		max = maxTraces
+	}
+	c := &TraceCollector{
+		Service: s,
+		events:  make(map[string][]AuditRecord),
+		max:     max,
+	}
+	channel := fmt.Sprintf("%s-%s-trace-%x#ephemeral", s.Name, s.ID, s.randInt63())
+	if _, err := s.subscribe(traceTopic, channel, c.collect); err != nil {
+		log.Println("COLONY\t trace collector could not follow trace events:", err)
+	}
+	return c
+}
+
+func (c *TraceCollector) collect(body []byte) error {
+	var r AuditRecord
+	if err := json.Unmarshal(body, &r); err != nil || r.CorrelationID == "" {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.events[r.CorrelationID]; !ok {
+		c.order = append(c.order, r.CorrelationID)
+		if len(c.order) > c.max {
+			delete(c.events, c.order[0])
+			c.order = c.order[1:]
+		}
+	}
+	c.events[r.CorrelationID] = append(c.events[r.CorrelationID], r)
+	return nil
+}
+
+// Trace returns the Messages sent for correlationID as a tree: the spans
+// with no known parent, in order of Time, with their children under them. It
+// reports false if the collector has seen nothing of correlationID.
+func (c *TraceCollector) Trace(correlationID string) ([]*TraceSpan, bool) {
+	c.mu.Lock()
+	events := append([]AuditRecord(nil), c.events[correlationID]...)
+	c.mu.Unlock()
+	if len(events) == 0 {
+		return nil, false
+	}
+	return buildTrace(events), true
+}
+
+// buildTrace assembles the spans of events into trees.
+func buildTrace(events []AuditRecord) []*TraceSpan {
+	spans := make(map[string]*TraceSpan)
+	parents := make(map[string]string)
+	for _, r := range events {
+		t, ok := spans[r.Span]
+		if !ok {
+			t = &TraceSpan{Span: r.Span, ContentType: r.ContentType}
+			spans[r.Span] = t
+		}
+		if r.Parent != "" {
+			parents[r.Span] = r.Parent
+		}
+		switch r.Direction {
+		case AuditEmitted:
+			t.From = r.Service
+			t.Emitted = r.Time
+		case AuditConsumed:
+			t.Consumed = append(t.Consumed, TraceHandle{Service: r.Service, Time: r.Time})
+		}
+	}
+	var roots []*TraceSpan
+	for _, t := range spans {
+		if t.From == "" {
+			t.From = t.Span[:strings.LastIndex(t.Span, "/")]
+		}
+		sort.Slice(t.Consumed, func(i, j int) bool { return t.Consumed[i].Time.Before(t.Consumed[j].Time) })
+		if parent, ok := spans[parents[t.Span]]; ok && parent != t {
+			parent.Children = append(parent.Children, t)
+		} else {
+			roots = append(roots, t)
+		}
+	}
+	byStart := func(ts []*TraceSpan) {
+		sort.Slice(ts, func(i, j int) bool { return ts[i].start().Before(ts[j].start()) })
+	}
+	for _, t := range spans {
+		byStart(t.Children)
+	}
+	byStart(roots)
+	return roots
+}
+
+// WriteTrace writes the tree of spans returned by Trace to w, a line for each
+// Message indented under the one it was sent for.
+func WriteTrace(w io.Writer, spans []*TraceSpan) error {
+	var write func(t *TraceSpan, depth int) error
+	write = func(t *TraceSpan, depth int) error {
+		var handled []string
+		for _, h := range t.Consumed {
+			handled = append(handled, h.Service)
+		}
+		when := ""
+		if start := t.start(); !start.IsZero() {
+			when = start.Format("15:04:05.000")
+		}
+		_, err := fmt.Fprintf(w, "%s%s %s %s -> %s\n", strings.Repeat("  ", depth), when, t.ContentType, t.From, strings.Join(handled, ", "))
+		if err != nil {
+			return err
+		}
+		for _, child := range t.Children {
+			if err := write(child, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, t := range spans {
+		if err := write(t, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
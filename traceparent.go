@@ -0,0 +1,82 @@
+package colony
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+// The W3C Trace Context headers. A Message carrying them is part of the trace
+// they name in whatever tracing system its senders and receivers use; colony
+// only passes them along.
+const (
+	TraceParentHeader = "traceparent"
+	TraceStateHeader  = "tracestate"
+)
+
+// WithTraceContext has the service start a W3C trace for each Message it
+// creates with NewMessage, so that Messages not sent on behalf of one already
+// traced can be followed too. Responses, and Messages made ChildOf another,
+// carry on their parent's trace whether or not it is used.
+func WithTraceContext() Option {
+	return func(s *Service) {
+		s.traceContext = true
+	}
+}
+
+// TraceParent returns m's W3C traceparent header, or "" if it isn't part of a
+// trace. A handler instrumented for tracing starts its span from it, and sets
+// its own on the Messages it sends with SetHeader.
+func (m Message) TraceParent() string {
+	return m.Header(TraceParentHeader)
+}
+
+// continueTrace has m carry on the W3C trace of parent, if it has one.
+func (m *Message) continueTrace(parent Message) {
+	if tp := parent.TraceParent(); tp != "" {
+		m.SetHeader(TraceParentHeader, tp)
+		if ts := parent.Header(TraceStateHeader); ts != "" {
+			m.SetHeader(TraceStateHeader, ts)
+		}
+	}
+}
+
+// SetTraceContext has m carry traceparent and tracestate, as received from
+// outside the colony, such as in HTTP headers. Nothing is set if traceparent
+// isn't valid.
+func (m *Message) SetTraceContext(traceparent, tracestate string) {
+	if !validTraceParent(traceparent) {
+		return
+	}
+	m.SetHeader(TraceParentHeader, traceparent)
+	if tracestate != "" {
+		m.SetHeader(TraceStateHeader, tracestate)
+	}
+}
+
+// newTraceParent returns a traceparent for a new, sampled trace.
+func (s Service) newTraceParent() string {
+	id := make([]byte, 24)
+	s.randRead(id)
+	return "00-" + hex.EncodeToString(id[:16]) + "-" + hex.EncodeToString(id[16:]) + "-01"
+}
+
+// validTraceParent reports whether tp is a traceparent colony can pass along:
+// a known version with a trace ID and parent ID that aren't all zeros.
+func validTraceParent(tp string) bool {
+	parts := strings.Split(tp, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return false
+	}
+	for i, n := range []int{2, 32, 16, 2} {
+		if len(parts[i]) != n || strings.ToLower(parts[i]) != parts[i] {
+			return false
+		}
+		if _, err := hex.DecodeString(parts[i]); err != nil {
+			return false
+		}
+		if (i == 1 || i == 2) && strings.Trim(parts[i], "0") == "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+package colony
+
+import (
+	"errors"
+	"time"
+)
+
+// A Transport carries colony messages between services. Services use NSQ
+// unless another Transport is supplied with WithTransport.
+type Transport interface {
+	// CreateTopic makes sure a topic exists, so that messages published to
+	// it are kept until they are consumed.
+	CreateTopic(topic string) error
+	// Publish sends body on topic. It must not keep body once it returns,
+	// as the caller reuses it.
+	Publish(topic string, body []byte) error
+	// Subscribe calls h with the body of every message published on topic.
+	// Subscriptions with the same topic and channel share its messages
+	// between them; each channel sees every message. A message for which h
+	// returns an error is delivered again later.
+	Subscribe(topic, channel string, h func(body []byte) error) (Subscription, error)
+	// Discover returns the names of all the topics known to the transport.
+	Discover() ([]string, error)
+}
+
+// A Subscription is a Transport's delivery of a topic to a handler.
+type Subscription interface {
+	// Stop ends delivery to the subscription's handler.
+	Stop()
+}
+
+// WithTransport makes the service use t instead of NSQ. The lookupd address
+// given to NewService is then ignored.
+func WithTransport(t Transport) Option {
+	return func(s *Service) {
+		s.transport = t
+	}
+}
+
+// A StatsTransport is a Transport that can also report on the state of its
+// topics. NSQTransport and MemoryTransport are StatsTransports.
+type StatsTransport interface {
+	Transport
+	TopicStats() ([]TopicStats, error)
+}
+
+// A DeferredTransport is a Transport that can hold a message back for a while
+// before delivering it. NSQTransport and MemoryTransport are
+// DeferredTransports.
+type DeferredTransport interface {
+	Transport
+	DeferredPublish(topic string, delay time.Duration, body []byte) error
+}
+
+// A ConcurrentTransport is a Transport that can call a subscription's handler
+// from n goroutines at once. NSQTransport and MemoryTransport are
+// ConcurrentTransports.
+type ConcurrentTransport interface {
+	Transport
+	SubscribeConcurrent(topic, channel string, n int, h func(body []byte) error) (Subscription, error)
+}
+
+// A RequeueError is returned by a subscription's handler to have the message
+// delivered again after Delay rather than the transport's usual wait.
+// Transports that can't choose the wait treat it as any other error.
+type RequeueError struct {
+	Err   error
+	Delay time.Duration
+}
+
+func (e *RequeueError) Error() string {
+	return e.Err.Error()
+}
+
+// requeueDelay returns how long to wait before delivering again a message
+// whose handler returned err, or def if err doesn't say.
+func requeueDelay(err error, def time.Duration) time.Duration {
+	var re *RequeueError
+	if errors.As(err, &re) {
+		return re.Delay
+	}
+	return def
+}
@@ -0,0 +1,113 @@
+package colony
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Content types can carry a version, as in SnakeRequest@2. Each version of a
+// content type is produced on a topic of its own, and consumers pick the
+// versions they want with a constraint after the @:
+//
+//	SnakeRequest@2    version 2 only
+//	SnakeRequest@>=2  version 2 and later
+//	SnakeRequest@*    any version
+//
+// A content type without an @ is unversioned and matches only itself.
+
+// SplitVersion splits a versioned content type such as SnakeRequest@2 into
+// SnakeRequest and 2. Unversioned content types have version 0.
+func SplitVersion(contentType string) (base string, version int) {
+	i := strings.LastIndex(contentType, "@")
+	if i < 0 {
+		return contentType, 0
+	}
+	v, err := strconv.Atoi(contentType[i+1:])
+	if err != nil || v < 1 {
+		return contentType, 0
+	}
+	return contentType[:i], v
+}
+
+// topicVersion is how the @ of a versioned content type is written in topic
+// names, which can't contain an @.
+const topicVersion = ".v"
+
+// topicContentType returns contentType as it is written in topic names.
+func topicContentType(contentType string) string {
+	base, version := SplitVersion(contentType)
+	if version == 0 {
+		return contentType
+	}
+	return base + topicVersion + strconv.Itoa(version)
+}
+
+// channelContentType returns contentType, which may carry a version
+// constraint, as it is written in channel names: Foo@>=2 as Foo.v2up and
+// Foo@* as Foo.vany.
+func channelContentType(contentType string) string {
+	i := strings.LastIndex(contentType, "@")
+	if i < 0 {
+		return contentType
+	}
+	base, constraint := contentType[:i], contentType[i+1:]
+	switch {
+	case constraint == "*":
+		return base + topicVersion + "any"
+	case strings.HasPrefix(constraint, ">="):
+		return base + topicVersion + constraint[2:] + "up"
+	default:
+		return topicContentType(contentType)
+	}
+}
+
+// fromTopicContentType reverses topicContentType.
+func fromTopicContentType(name string) string {
+	i := strings.LastIndex(name, topicVersion)
+	if i < 0 {
+		return name
+	}
+	v, err := strconv.Atoi(name[i+len(topicVersion):])
+	if err != nil || v < 1 {
+		return name
+	}
+	return name[:i] + "@" + strconv.Itoa(v)
+}
+
+// matchContentType reports whether contentType satisfies pattern, which is a
+// content type optionally followed by a version constraint.
+func matchContentType(pattern, contentType string) bool {
+	i := strings.LastIndex(pattern, "@")
+	if i < 0 {
+		return pattern == contentType
+	}
+	base, constraint := pattern[:i], pattern[i+1:]
+	b, version := SplitVersion(contentType)
+	if b != base || version == 0 {
+		return false
+	}
+	switch {
+	case constraint == "*":
+		return true
+	case strings.HasPrefix(constraint, ">="):
+		min, err := strconv.Atoi(constraint[2:])
+		return err == nil && version >= min
+	default:
+		v, err := strconv.Atoi(constraint)
+		return err == nil && version == v
+	}
+}
+
+// matchTopic reports whether the topic name carries a content type satisfying
+// pattern. Unversioned content types are matched by the end of the name.
+func matchTopic(pattern, name string) bool {
+	i := strings.LastIndex(pattern, "@")
+	if i < 0 {
+		return strings.HasSuffix(name, pattern)
+	}
+	j := strings.LastIndex(name, "-"+pattern[:i]+topicVersion)
+	if j < 0 {
+		return false
+	}
+	return matchContentType(pattern, fromTopicContentType(name[j+1:]))
+}
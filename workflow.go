@@ -0,0 +1,337 @@
+package colony
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrWorkflowCycle is returned by Workflow.Start when its steps feed back
+// into themselves.
+var ErrWorkflowCycle = errors.New("workflow steps form a cycle")
+
+// A Transform turns a Message consumed by a Workflow into the payload of the
+// Message it emits. Returning a nil payload emits nothing, ending that branch
+// of the run.
+type Transform func(Message) ([]byte, error)
+
+// A Workflow is a graph of content types joined by Transforms, all run by one
+// Service. Declare it with NewWorkflow and Step, and Start it: the workflow
+// consumes the content types its steps start from and emits the ones they
+// produce, carrying the CorrelationID of the Message that began each run
+// through to every Message of it.
+//
+// Runs begun elsewhere in the colony are followed from the first Message of
+// them the workflow sees. Finished runs are forgotten after KeepFinished, or
+// sooner once there are more than MaxFinished of them.
+type Workflow struct {
+	Name    string
+	Service *Service
+	Steps   []WorkflowStep
+
+	// KeepFinished is how long a completed or failed run is kept after it
+	// last changed. It is an hour if 0.
+	KeepFinished time.Duration
+	// MaxFinished is how many completed and failed runs are kept, the
+	// earliest to finish going first. It is 1000 if 0.
+	MaxFinished int
+
+	mu       sync.Mutex
+	runs     map[string]*WorkflowRun
+	finished []string // IDs of finished runs, in the order they finished
+}
+
+// The defaults of Workflow.KeepFinished and Workflow.MaxFinished.
+const (
+	keepFinishedRuns = time.Hour
+	maxFinishedRuns  = 1000
+)
+
+// A WorkflowStep consumes one content type and emits another.
+type WorkflowStep struct {
+	From      string
+	To        string
+	Transform Transform
+}
+
+// WorkflowStatus is where a workflow run has got to.
+type WorkflowStatus string
+
+// The statuses of a workflow run.
+const (
+	WorkflowRunning   WorkflowStatus = "running"
+	WorkflowCompleted WorkflowStatus = "completed"
+	WorkflowFailed    WorkflowStatus = "failed"
+)
+
+// A WorkflowRun is the progress of one run of a Workflow.
+type WorkflowRun struct {
+	ID      string // the run's CorrelationID
+	Status  WorkflowStatus
+	Pending int            // Messages of the run the workflow has yet to handle
+	Done    map[string]int // times each step has run, by From and To content types
+	Err     string         // why the run failed, if it has
+	Started time.Time
+	Updated time.Time
+
+	queued bool // in Workflow.finished
+}
+
+// NewWorkflow returns an empty Workflow called name, run by s.
+func NewWorkflow(s *Service, name string) *Workflow {
+	return &Workflow{
+		Name:    name,
+		Service: s,
+		runs:    make(map[string]*WorkflowRun),
+	}
+}
+
+// Step adds a step to the workflow that consumes from, and emits to with the
+// payload t makes of each Message. It returns the workflow.
+func (w *Workflow) Step(from, to string, t Transform) *Workflow {
+	w.Steps = append(w.Steps, WorkflowStep{From: from, To: to, Transform: t})
+	return w
+}
+
+// Start announces the content types the workflow emits and starts consuming
+// the ones it handles.
+func (w *Workflow) Start() error {
+	if w.cyclic() {
+		return ErrWorkflowCycle
+	}
+	emitted := make(map[string]bool)
+	for _, step := range w.Steps {
+		if emitted[step.To] {
+			continue
+		}
+		if err := w.Service.Announce(step.To); err != nil {
+			return err
+		}
+		emitted[step.To] = true
+	}
+	for _, contentType := range w.sources() {
+		contentType := contentType
+		go func() {
+			err := w.Service.Consume(contentType, func(c <-chan Message) error {
+				for m := range c {
+					w.handle(m)
+				}
+				return nil
+			})
+			if err != nil {
+				log.Println("COLONY\t workflow", w.Name, "could not consume", contentType+":", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// Begin emits a Message of contentType with payload as the start of a new run
+// of the workflow, and returns the run's ID.
+func (w *Workflow) Begin(contentType string, payload []byte) (string, error) {
+	m := w.Service.NewMessage(contentType, payload)
+	m.CorrelationID = fmt.Sprintf("%s-%x", w.Name, w.Service.randInt63())
+	w.mu.Lock()
+	run := w.newRun(m.CorrelationID)
+	run.Pending = w.handled(contentType)
+	w.finish(run)
+	w.mu.Unlock()
+	return m.CorrelationID, w.Service.Emit(m)
+}
+
+// Run returns the progress of the run with id.
+func (w *Workflow) Run(id string) (WorkflowRun, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.evict(time.Now())
+	run, ok := w.runs[id]
+	if !ok {
+		return WorkflowRun{}, false
+	}
+	return run.copy(), true
+}
+
+// Runs returns the progress of every run the workflow has seen, oldest first.
+func (w *Workflow) Runs() []WorkflowRun {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.evict(time.Now())
+	out := make([]WorkflowRun, 0, len(w.runs))
+	for _, run := range w.runs {
+		out = append(out, run.copy())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Started.Before(out[j].Started) })
+	return out
+}
+
+// handle runs every step starting from m's content type on m.
+func (w *Workflow) handle(m Message) {
+	id := m.CorrelationID
+	if id == "" {
+		// a run begun by something outside the workflow
+		id = fmt.Sprintf("%s-%s-%s", m.Topic.ServiceName, m.Topic.ServiceID, m.MessageID)
+	}
+	w.mu.Lock()
+	run, ok := w.runs[id]
+	if !ok {
+		run = w.newRun(id)
+		run.Pending = 1
+	}
+	w.mu.Unlock()
+
+	for _, step := range w.Steps {
+		if step.From != m.ContentType {
+			continue
+		}
+		payload, err := step.Transform(m)
+		if err == nil && payload != nil {
+			out := w.Service.NewMessage(step.To, payload)
+			out.CorrelationID = id
+			w.mu.Lock()
+			run.Pending += w.handled(step.To)
+			w.mu.Unlock()
+			err = w.Service.Emit(out)
+		}
+		w.mu.Lock()
+		run.Done[step.From+" -> "+step.To]++
+		if err != nil && run.Status == WorkflowRunning {
+			log.Println("COLONY\t workflow", w.Name, "run", id, "failed at", step.From, "->", step.To+":", err)
+			run.Status = WorkflowFailed
+			run.Err = err.Error()
+		}
+		w.mu.Unlock()
+	}
+
+	w.mu.Lock()
+	run.Pending--
+	w.finish(run)
+	w.mu.Unlock()
+}
+
+// newRun records a new run with id. w.mu must be held.
+func (w *Workflow) newRun(id string) *WorkflowRun {
+	now := time.Now()
+	run := &WorkflowRun{
+		ID:      id,
+		Status:  WorkflowRunning,
+		Done:    make(map[string]int),
+		Started: now,
+		Updated: now,
+	}
+	w.runs[id] = run
+	return run
+}
+
+// finish marks run completed if it has nothing left to handle, and forgets
+// runs that finished long enough ago. w.mu must be held.
+func (w *Workflow) finish(run *WorkflowRun) {
+	run.Updated = time.Now()
+	if run.Pending > 0 {
+		return
+	}
+	if run.Status == WorkflowRunning {
+		run.Status = WorkflowCompleted
+	}
+	if !run.queued {
+		run.queued = true
+		w.finished = append(w.finished, run.ID)
+	}
+	w.evict(run.Updated)
+}
+
+// evict forgets the finished runs that are older than KeepFinished or beyond
+// MaxFinished. w.mu must be held.
+func (w *Workflow) evict(now time.Time) {
+	keep, max := w.KeepFinished, w.MaxFinished
+	if keep <= 0 {
+		keep = keepFinishedRuns
+	}
+	if max <= 0 {
+		max = maxFinishedRuns
+	}
+	for len(w.finished) > 0 {
+		run := w.runs[w.finished[0]]
+		if run != nil && len(w.finished) <= max && now.Sub(run.Updated) < keep {
+			return
+		}
+		w.finished = w.finished[1:]
+		if run == nil {
+			continue
+		}
+		run.queued = false
+		// a run that has had more Messages since it finished is queued
+		// again when it next finishes
+		if run.Pending <= 0 {
+			delete(w.runs, run.ID)
+		}
+	}
+}
+
+// handled returns 1 if the workflow has steps starting from contentType, and 0
+// if not: how many Messages of the run each Message of contentType adds.
+func (w *Workflow) handled(contentType string) int {
+	for _, step := range w.Steps {
+		if step.From == contentType {
+			return 1
+		}
+	}
+	return 0
+}
+
+// sources returns the content types the workflow's steps start from.
+func (w *Workflow) sources() []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, step := range w.Steps {
+		if !seen[step.From] {
+			seen[step.From] = true
+			out = append(out, step.From)
+		}
+	}
+	return out
+}
+
+// cyclic reports whether any content type can lead back to itself.
+func (w *Workflow) cyclic() bool {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var visit func(contentType string) bool
+	visit = func(contentType string) bool {
+		switch state[contentType] {
+		case visiting:
+			return true
+		case visited:
+			return false
+		}
+		state[contentType] = visiting
+		for _, step := range w.Steps {
+			if step.From == contentType && visit(step.To) {
+				return true
+			}
+		}
+		state[contentType] = visited
+		return false
+	}
+	for _, contentType := range w.sources() {
+		if visit(contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+func (run *WorkflowRun) copy() WorkflowRun {
+	out := *run
+	out.queued = false
+	out.Done = make(map[string]int, len(run.Done))
+	for k, v := range run.Done {
+		out.Done[k] = v
+	}
+	return out
+}
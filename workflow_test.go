@@ -0,0 +1,33 @@
+package colony
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWorkflowForgetsFinishedRuns(t *testing.T) {
+	w := NewWorkflow(nil, "w")
+	w.MaxFinished = 2
+	w.mu.Lock()
+	running := w.newRun("running")
+	running.Pending = 1
+	w.finish(running)
+	for i := 0; i < 3; i++ {
+		w.finish(w.newRun(fmt.Sprint(i)))
+	}
+	w.mu.Unlock()
+	if _, ok := w.Run("0"); ok {
+		t.Fatal("the earliest run to finish was kept beyond MaxFinished")
+	}
+	if got := len(w.Runs()); got != 3 {
+		t.Fatalf("kept %d runs, want the running one and 2 finished", got)
+	}
+
+	w.KeepFinished = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+	runs := w.Runs()
+	if len(runs) != 1 || runs[0].ID != "running" {
+		t.Fatalf("kept %+v, want only the running run", runs)
+	}
+}
@@ -0,0 +1,234 @@
+// Package wsbridge lets browsers take part in a colony over WebSockets, so
+// dashboards can watch colony traffic live and emit messages without polling.
+//
+// Clients send JSON frames of the form
+//
+//	{"type": "subscribe", "contentType": "ants"}
+//	{"type": "unsubscribe", "contentType": "ants"}
+//	{"type": "emit", "contentType": "bees", "payload": "bzz"}
+//
+// and receive a "message" frame for each Message of a content type they are
+// subscribed to, or an "error" frame when a request fails. Payloads are sent
+// as text. Every request is refused as forbidden until the Bridge is given an
+// Authorize function; AllowAll lets everything through.
+package wsbridge
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nytlabs/colony"
+)
+
+// A Frame is a single JSON message exchanged with a browser.
+type Frame struct {
+	Type        string    `json:"type"`
+	ContentType string    `json:"contentType,omitempty"`
+	From        string    `json:"from,omitempty"`
+	MessageID   string    `json:"messageId,omitempty"`
+	Time        time.Time `json:"time,omitempty"`
+	Payload     string    `json:"payload,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// An Authorizer decides whether the client that made the WebSocket request r
+// may emit or consume contentType.
+type Authorizer func(r *http.Request, action colony.Action, contentType string) bool
+
+// AllowAll is an Authorizer letting every client emit and consume everything,
+// for bridges that only trusted clients can reach.
+func AllowAll(r *http.Request, action colony.Action, contentType string) bool {
+	return true
+}
+
+// Bridge is an http.Handler upgrading requests to WebSockets. The bridge taps
+// each content type while clients are subscribed to it, so that it takes no
+// Messages from the colony's consumers, and copies its Messages to every
+// subscribed client. Clients that fall too far behind miss messages rather
+// than hold up the colony.
+type Bridge struct {
+	Service   *colony.Service
+	Authorize Authorizer // nil forbids everything
+	Upgrader  websocket.Upgrader
+
+	mu          sync.Mutex
+	subscribers map[string]map[*client]bool
+	taps        map[string]func() // detaches the tap of each subscribed content type
+	announced   map[string]bool
+}
+
+type client struct {
+	conn *websocket.Conn
+	send chan Frame
+}
+
+// NewBridge returns a Bridge for s.
+func NewBridge(s *colony.Service) *Bridge {
+	return &Bridge{
+		Service:     s,
+		subscribers: make(map[string]map[*client]bool),
+		taps:        make(map[string]func()),
+		announced:   make(map[string]bool),
+	}
+}
+
+// ServeHTTP upgrades r to a WebSocket and serves the client until it
+// disconnects.
+func (b *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := b.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &client{
+		conn: conn,
+		send: make(chan Frame, 64),
+	}
+	go c.write()
+	defer func() {
+		b.unsubscribeAll(c)
+		close(c.send)
+	}()
+
+	for {
+		var f Frame
+		if err := conn.ReadJSON(&f); err != nil {
+			return
+		}
+		action := colony.ActionConsume
+		if f.Type == "emit" {
+			action = colony.ActionEmit
+		}
+		if b.Authorize == nil || !b.Authorize(r, action, f.ContentType) {
+			c.deliver(Frame{Type: "error", ContentType: f.ContentType, Error: "forbidden"})
+			continue
+		}
+		switch f.Type {
+		case "subscribe":
+			b.subscribe(c, f.ContentType)
+		case "unsubscribe":
+			b.unsubscribe(c, f.ContentType)
+		case "emit":
+			if err := b.emit(f.ContentType, f.Payload); err != nil {
+				c.deliver(Frame{Type: "error", ContentType: f.ContentType, Error: err.Error()})
+			}
+		default:
+			c.deliver(Frame{Type: "error", Error: "unknown frame type " + f.Type})
+		}
+	}
+}
+
+func (c *client) write() {
+	defer c.conn.Close()
+	for f := range c.send {
+		if err := c.conn.WriteJSON(f); err != nil {
+			return
+		}
+	}
+}
+
+// deliver queues f for the client, dropping it if the client is behind.
+func (c *client) deliver(f Frame) {
+	select {
+	case c.send <- f:
+	default:
+	}
+}
+
+func (b *Bridge) subscribe(c *client, contentType string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subs, ok := b.subscribers[contentType]
+	if !ok {
+		subs = make(map[*client]bool)
+		b.subscribers[contentType] = subs
+		msgs, stop := b.Service.Tap(contentType)
+		done := make(chan struct{})
+		b.taps[contentType] = func() {
+			stop()
+			close(done)
+		}
+		go b.relay(contentType, msgs, done)
+	}
+	subs[c] = true
+}
+
+func (b *Bridge) unsubscribe(c *client, contentType string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remove(c, contentType)
+}
+
+func (b *Bridge) unsubscribeAll(c *client) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for contentType := range b.subscribers {
+		b.remove(c, contentType)
+	}
+}
+
+// remove unsubscribes c from contentType, and detaches the tap of
+// contentType once nobody is subscribed. b.mu must be held.
+func (b *Bridge) remove(c *client, contentType string) {
+	subs, ok := b.subscribers[contentType]
+	if !ok {
+		return
+	}
+	delete(subs, c)
+	if len(subs) == 0 {
+		b.taps[contentType]()
+		delete(b.taps, contentType)
+		delete(b.subscribers, contentType)
+	}
+}
+
+// relay copies the Messages tapped from contentType to its subscribers until
+// done is closed.
+func (b *Bridge) relay(contentType string, msgs <-chan colony.Message, done <-chan struct{}) {
+	for {
+		var m colony.Message
+		select {
+		case m = <-msgs:
+		case <-done:
+			return
+		}
+		payload, err := m.FetchPayload()
+		if err != nil {
+			log.Println("COLONY\t could not fetch payload for WebSocket clients:", err)
+			continue
+		}
+		f := Frame{
+			Type:        "message",
+			ContentType: m.ContentType,
+			From:        m.FromName,
+			MessageID:   string(m.MessageID),
+			Time:        m.Time,
+			Payload:     string(payload),
+		}
+		b.mu.Lock()
+		select {
+		case <-done:
+			// detached while the payload was fetched
+		default:
+			for c := range b.subscribers[contentType] {
+				c.deliver(f)
+			}
+		}
+		b.mu.Unlock()
+	}
+}
+
+func (b *Bridge) emit(contentType, payload string) error {
+	b.mu.Lock()
+	announce := !b.announced[contentType]
+	b.announced[contentType] = true
+	b.mu.Unlock()
+	if announce {
+		if err := b.Service.Announce(contentType); err != nil {
+			return err
+		}
+	}
+	return b.Service.Emit(b.Service.NewMessage(contentType, []byte(payload)))
+}
@@ -0,0 +1,126 @@
+package colony
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// frameZeroCopy is the first byte of a body in the zero-copy format: the byte,
+// the length of the envelope as four big-endian bytes, the envelope as JSON
+// without its payload, and then the payload as it is. A JSON body starts
+// with '{' instead.
+const frameZeroCopy = 0x01
+
+// zeroCopyHeader is the length of the frame before the envelope.
+const zeroCopyHeader = 5
+
+// WithZeroCopy makes the service send Messages in the zero-copy format, with
+// the payload left raw after a small header instead of base64 encoded inside
+// the JSON envelope. Consumers then slice the payload out of the transport's
+// message rather than decoding it into a copy, so they mustn't modify it.
+// Only consumers that can read the format, as services of this version can,
+// should consume from the service.
+func WithZeroCopy() Option {
+	return func(s *Service) {
+		s.zeroCopy = true
+	}
+}
+
+// writeZeroCopy appends m to b in the zero-copy format, without the leading
+// frameZeroCopy byte.
+func writeZeroCopy(b *bytes.Buffer, m Message) error {
+	start := b.Len()
+	b.Write(make([]byte, zeroCopyHeader-1))
+	payload := m.Payload
+	m.Payload = nil
+	if err := encodeEnvelope(b, m); err != nil {
+		return err
+	}
+	b.Truncate(b.Len() - 1) // the Encoder's newline
+	binary.BigEndian.PutUint32(b.Bytes()[start:], uint32(b.Len()-start-(zeroCopyHeader-1)))
+	b.Write(payload)
+	return nil
+}
+
+// UnmarshalMessage decodes the body of a transport message into a Message,
+// whichever format it was sent in. The Payload of a zero-copy Message is part
+// of body. It returns ErrNotColony if body wasn't sent by a colony service,
+// ErrUnsupportedFrame if it was framed by a newer version of colony, and
+// ErrMalformed if it can't be decoded or describes an impossible Message. It
+// is the whole of the decoding consumers do before a Message is checked and
+// handled, and doesn't panic whatever body holds.
+func UnmarshalMessage(body []byte) (Message, error) {
+	var m Message
+	err := unmarshalMessage(body, &m)
+	return m, err
+}
+
+func unmarshalMessage(body []byte, m *Message) error {
+	return checkDecoded(m, decodeBody(body, m))
+}
+
+// checkDecoded returns err, the result of decoding m, as ErrMalformed unless
+// it already says what is wrong, or checks m's envelope if there is none.
+func checkDecoded(m *Message, err error) error {
+	switch {
+	case err == nil:
+		return checkEnvelope(*m)
+	case errors.Is(err, ErrNotColony), errors.Is(err, ErrUnsupportedFrame), errors.Is(err, ErrMalformed):
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrMalformed, err)
+}
+
+// maxChunks is the most parts a chunked Message may claim to have.
+const maxChunks = 1 << 14
+
+// checkEnvelope returns ErrMalformed if m's envelope can't be right.
+func checkEnvelope(m Message) error {
+	if c := m.Chunk; c != nil && (c.Count <= 0 || c.Count > maxChunks || c.Index < 0 || c.Index >= c.Count) {
+		return fmt.Errorf("%w: chunk %d of %d", ErrMalformed, c.Index, c.Count)
+	}
+	if m.Hops < 0 || m.Attempt < 0 {
+		return fmt.Errorf("%w: negative hops or attempts", ErrMalformed)
+	}
+	return nil
+}
+
+// decodeBody decodes body into m, whichever format it is in.
+func decodeBody(body []byte, m *Message) error {
+	switch {
+	case len(body) == 0:
+		return ErrNotColony
+	case isJSONBody(body):
+		return decodeEnvelope(body, m)
+	case body[0] == frameZeroCopy:
+		return unmarshalZeroCopy(body[1:], m)
+	case bytes.HasPrefix(body, []byte(frameMagic)):
+		return unmarshalFrame(body, m)
+	}
+	return ErrNotColony
+}
+
+// unmarshalZeroCopy decodes body, in the zero-copy format without its leading
+// frameZeroCopy byte, into m.
+func unmarshalZeroCopy(body []byte, m *Message) error {
+	if len(body) < zeroCopyHeader-1 {
+		return errBadFrame
+	}
+	end := zeroCopyHeader - 1 + int(binary.BigEndian.Uint32(body))
+	if end < zeroCopyHeader-1 || end > len(body) {
+		return errBadFrame
+	}
+	if err := decodeEnvelope(body[zeroCopyHeader-1:end], m); err != nil {
+		return err
+	}
+	m.Payload = body[end:]
+	return nil
+}
+
+// isJSONBody reports whether body is a Message encoded as JSON.
+func isJSONBody(body []byte) bool {
+	body = bytes.TrimLeft(body, " \t\r\n")
+	return len(body) > 0 && body[0] == '{'
+}